@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/govice/golinksd/pkg/config"
+	"github.com/govice/golinksd/pkg/peers"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	peersCmd.AddCommand(peersListCmd, peersAddCmd, peersRemoveCmd)
+	rootCmd.AddCommand(peersCmd)
+}
+
+// peerListPath returns the peer list file of the daemon run by the current
+// user. A running daemon picks up changes on its next probe round.
+func peerListPath() string {
+	return filepath.Join((&config.Service{}).HomeDir(), "peers.json")
+}
+
+var peersCmd = &cobra.Command{
+	Use:   "peers",
+	Short: "manage the peers listed in the peer list file",
+}
+
+var peersListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "list the managed peers",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		list, err := peers.ReadList(peerListPath())
+		if err != nil {
+			return err
+		}
+		for _, address := range list.Peers {
+			fmt.Println(address)
+		}
+		return nil
+	},
+}
+
+var peersAddCmd = &cobra.Command{
+	Use:   "add <address>",
+	Short: "add a peer to the peer list",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		address, err := peers.NormalizeAddress(args[0])
+		if err != nil {
+			return err
+		}
+		list, err := peers.ReadList(peerListPath())
+		if err != nil {
+			return err
+		}
+		if !list.Add(address) {
+			fmt.Println(address, "is already listed")
+			return nil
+		}
+		return peers.WriteList(peerListPath(), list)
+	},
+}
+
+var peersRemoveCmd = &cobra.Command{
+	Use:   "remove <address>",
+	Short: "remove a peer from the peer list",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		address, err := peers.NormalizeAddress(args[0])
+		if err != nil {
+			return err
+		}
+		list, err := peers.ReadList(peerListPath())
+		if err != nil {
+			return err
+		}
+		if !list.Remove(address) {
+			return fmt.Errorf("%s: %w", address, peers.ErrUnknownPeer)
+		}
+		return peers.WriteList(peerListPath(), list)
+	},
+}
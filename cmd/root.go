@@ -11,6 +11,11 @@ func Execute() error {
 	return rootCmd.Execute()
 }
 
+func init() {
+	rootCmd.PersistentFlags().String("config", "", "config file merged over the system and user config files")
+	viper.BindPFlag("config", rootCmd.PersistentFlags().Lookup("config"))
+}
+
 var rootCmd = &cobra.Command{
 	Use:   "golinksd",
 	Short: "golinksd is a daemon for managing filesystem integrity over time",
@@ -0,0 +1,73 @@
+// Package middleware holds gin middleware shared by the daemon's servers.
+package middleware
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DecompressRequests transparently decompresses gzip encoded request bodies
+// and advertises them in the Accept-Encoding response header. Bodies are
+// limited to limit bytes both before and after decompression, so a small
+// compressed body cannot expand without bound.
+func DecompressRequests(limit int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Accept-Encoding", "gzip")
+		c.Request.Body = newMaxBytesReader(c.Request.Body, limit)
+		switch c.GetHeader("Content-Encoding") {
+		case "", "identity":
+		case "gzip":
+			zr, err := gzip.NewReader(c.Request.Body)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"status": "invalid gzip body",
+				})
+				c.Abort()
+				return
+			}
+			c.Request.Body = newMaxBytesReader(ioutil.NopCloser(zr), limit)
+			c.Request.Header.Del("Content-Encoding")
+		default:
+			c.JSON(http.StatusUnsupportedMediaType, gin.H{
+				"status": "unsupported content encoding",
+			})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// CompressResponses gzip encodes responses for clients accepting gzip.
+func CompressResponses() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !strings.Contains(c.GetHeader("Accept-Encoding"), "gzip") {
+			c.Next()
+			return
+		}
+
+		c.Header("Content-Encoding", "gzip")
+		c.Header("Vary", "Accept-Encoding")
+		zw := gzip.NewWriter(c.Writer)
+		defer zw.Close()
+		c.Writer = &gzipWriter{ResponseWriter: c.Writer, writer: zw}
+		c.Next()
+	}
+}
+
+type gzipWriter struct {
+	gin.ResponseWriter
+	writer *gzip.Writer
+}
+
+func (g *gzipWriter) Write(data []byte) (int, error) {
+	return g.writer.Write(data)
+}
+
+func (g *gzipWriter) WriteString(s string) (int, error) {
+	return g.writer.Write([]byte(s))
+}
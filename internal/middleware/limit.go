@@ -0,0 +1,72 @@
+package middleware
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErrBodyTooLarge is returned by reads past the limit of a request body.
+var ErrBodyTooLarge = errors.New("middleware: request body too large")
+
+// maxBytesReader limits a request body like http.MaxBytesReader, failing reads
+// past the limit with ErrBodyTooLarge.
+type maxBytesReader struct {
+	body      io.ReadCloser
+	remaining int64
+	err       error
+}
+
+func newMaxBytesReader(body io.ReadCloser, limit int64) *maxBytesReader {
+	return &maxBytesReader{body: body, remaining: limit}
+}
+
+func (r *maxBytesReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	// read one byte past the limit to tell a body of exactly limit bytes
+	// from a larger one
+	if int64(len(p)) > r.remaining+1 {
+		p = p[:r.remaining+1]
+	}
+	n, err := r.body.Read(p)
+	if int64(n) <= r.remaining {
+		r.remaining -= int64(n)
+		r.err = err
+		return n, err
+	}
+	n = int(r.remaining)
+	r.remaining = 0
+	r.err = ErrBodyTooLarge
+	return n, r.err
+}
+
+func (r *maxBytesReader) Close() error {
+	return r.body.Close()
+}
+
+// ReadBody reads the request body. A body that is too large is answered with
+// 413 and any other unreadable body with 400, and false is returned.
+func ReadBody(c *gin.Context) ([]byte, bool) {
+	body, err := c.GetRawData()
+	if errors.Is(err, ErrBodyTooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"status": "request body too large",
+		})
+		c.Abort()
+		return nil, false
+	} else if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "unreadable request body",
+		})
+		c.Abort()
+		return nil, false
+	}
+	return body, true
+}
@@ -0,0 +1,62 @@
+package peerserver
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/govice/golinksd/internal/middleware"
+	"github.com/govice/golinksd/pkg/blobstore"
+	"github.com/govice/golinksd/pkg/log"
+)
+
+// maxBlobSize caps the size of an uploaded blob.
+const maxBlobSize = 512 << 20
+
+func (ps *PeerServer) getBlobEndpoint(c *gin.Context) {
+	blob, err := ps.servicer.BlobStore().Get(c.Param("hash"))
+	if errors.Is(err, blobstore.ErrInvalidHash) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "invalid blob hash",
+		})
+		return
+	} else if errors.Is(err, blobstore.ErrBlobNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status": "blob not found",
+		})
+		return
+	} else if err != nil {
+		log.Errln("failed to read blob", c.Param("hash"), err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error reading blob",
+		})
+		return
+	}
+
+	c.Data(http.StatusOK, "application/octet-stream", blob)
+}
+
+func (ps *PeerServer) postBlobEndpoint(c *gin.Context) {
+	blob, ok := middleware.ReadBody(c)
+	if !ok {
+		return
+	}
+
+	err := ps.servicer.BlobStore().PutVerified(c.Param("hash"), blob)
+	if errors.Is(err, blobstore.ErrInvalidHash) || errors.Is(err, blobstore.ErrHashMismatch) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "blob does not match its hash",
+		})
+		return
+	} else if err != nil {
+		log.Errln("failed to store blob", c.Param("hash"), err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error storing blob",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "blob stored",
+	})
+}
@@ -0,0 +1,121 @@
+package peerserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/govice/golinks/block"
+	"github.com/govice/golinksd/internal/middleware"
+	"github.com/govice/golinksd/pkg/blockchain"
+	"github.com/govice/golinksd/pkg/chainbackend"
+	"github.com/govice/golinksd/pkg/log"
+)
+
+// maxRangeBlocks caps the blocks returned by a single range request.
+const maxRangeBlocks = 500
+
+func (ps *PeerServer) getLengthEndpoint(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"length": ps.servicer.BlockchainService().ChainLength(),
+	})
+}
+
+func (ps *PeerServer) getBlockEndpoint(c *gin.Context) {
+	index, err := strconv.Atoi(c.Query("index"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "invalid block index",
+		})
+		return
+	}
+
+	blk, err := ps.servicer.BlockchainService().GetBlock(index)
+	if errors.Is(err, chainbackend.ErrBlockNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status": "block not found",
+		})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error reading block",
+		})
+		return
+	}
+
+	c.PureJSON(http.StatusOK, blk)
+}
+
+func (ps *PeerServer) getRangeEndpoint(c *gin.Context) {
+	start, startErr := strconv.Atoi(c.Query("start"))
+	end, endErr := strconv.Atoi(c.Query("end"))
+	if startErr != nil || endErr != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "invalid block range",
+		})
+		return
+	}
+	if end-start >= maxRangeBlocks {
+		end = start + maxRangeBlocks - 1
+	}
+
+	blocks, err := ps.servicer.BlockchainService().GetRange(start, end)
+	if errors.Is(err, chainbackend.ErrBlockNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status": "block not found",
+		})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error reading blocks",
+		})
+		return
+	}
+
+	c.PureJSON(http.StatusOK, gin.H{
+		"blocks": blocks,
+	})
+}
+
+func (ps *PeerServer) postBlockEndpoint(c *gin.Context) {
+	body, ok := middleware.ReadBody(c)
+	if !ok {
+		return
+	}
+	blk := &block.Block{}
+	if err := json.Unmarshal(body, blk); err != nil || len(blk.BlockHash) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "recieved invalid block",
+		})
+		return
+	}
+
+	err := ps.servicer.BlockchainService().Append(blk)
+	if errors.Is(err, chainbackend.ErrConflict) {
+		c.JSON(http.StatusConflict, gin.H{
+			"status": "block does not extend the chain head",
+			"length": ps.servicer.BlockchainService().ChainLength(),
+		})
+		return
+	} else if errors.Is(err, chainbackend.ErrInvalidBlock) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "block hash does not match its contents",
+		})
+		return
+	} else if errors.Is(err, blockchain.ErrMissingChain) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"status": "chain has not been initialized",
+		})
+		return
+	} else if err != nil {
+		log.Errln("failed to append block from peer", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error adding block to chain",
+		})
+		return
+	}
+
+	c.PureJSON(http.StatusOK, blk)
+}
@@ -0,0 +1,70 @@
+package peerserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/govice/golinksd/internal/middleware"
+	"github.com/govice/golinksd/pkg/gossip"
+	"github.com/govice/golinksd/pkg/log"
+	"github.com/govice/golinksd/pkg/peers"
+)
+
+func (ps *PeerServer) announceEndpoint(c *gin.Context) {
+	body, ok := middleware.ReadBody(c)
+	if !ok {
+		return
+	}
+	head := gossip.Announcement{}
+	if err := json.Unmarshal(body, &head); err != nil || head.Length < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "recieved invalid announcement",
+		})
+		return
+	}
+
+	// the source is taken from the connection rather than from headers a
+	// client can set
+	source, _, err := net.SplitHostPort(c.Request.RemoteAddr)
+	if err != nil {
+		source = c.Request.RemoteAddr
+	}
+	ownHead, err := ps.servicer.GossipService().HandleAnnouncement(source, head)
+	if errors.Is(err, peers.ErrSelfPeer) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "announcement from this daemon",
+		})
+		return
+	} else if errors.Is(err, peers.ErrInvalidAddress) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "invalid peer address",
+		})
+		return
+	} else if errors.Is(err, gossip.ErrUnverifiedAddress) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"status": "announced address is not on the announcing host",
+		})
+		return
+	} else if errors.Is(err, peers.ErrTooManyPeers) {
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"status": "too many announced peers",
+		})
+		return
+	} else if errors.Is(err, peers.ErrBannedPeer) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"status": "peer is banned",
+		})
+		return
+	} else if err != nil {
+		log.Errln("failed to handle announcement from peer", source, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error handling announcement",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, ownHead)
+}
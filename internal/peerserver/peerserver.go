@@ -0,0 +1,144 @@
+package peerserver
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/govice/golinksd/internal/middleware"
+	"github.com/govice/golinksd/pkg/blobstore"
+	"github.com/govice/golinksd/pkg/blockchain"
+	"github.com/govice/golinksd/pkg/gossip"
+	"github.com/govice/golinksd/pkg/log"
+	"github.com/spf13/viper"
+)
+
+// PeerServer serves the daemon's own chain to other golinksd instances on
+// peer_port when running as a chain authority or gossiping with peers.
+type PeerServer struct {
+	router   *gin.Engine
+	servicer Servicer
+}
+
+type BlockchainServicer interface {
+	BlockchainService() *blockchain.Service
+}
+
+type BlobStoreServicer interface {
+	BlobStore() *blobstore.Store
+}
+
+type GossipServicer interface {
+	GossipService() *gossip.Service
+}
+
+type Servicer interface {
+	BlockchainServicer
+	BlobStoreServicer
+	GossipServicer
+}
+
+func New(servicer Servicer) (*PeerServer, error) {
+	return &PeerServer{
+		router:   gin.Default(),
+		servicer: servicer,
+	}, nil
+}
+
+// maxRequestSize caps the size of request bodies other than blobs.
+const maxRequestSize = 64 << 20
+
+func (ps *PeerServer) registerChainRoutes() {
+	chainGroup := ps.router.Group("/chain")
+	chainGroup.Use(ps.tokenAuthenticator(), middleware.DecompressRequests(maxRequestSize), middleware.CompressResponses())
+	{
+		chainGroup.GET("/length", ps.getLengthEndpoint)
+		chainGroup.GET("", ps.getBlockEndpoint)
+		chainGroup.POST("", ps.writeAuthenticator(), ps.postBlockEndpoint)
+		chainGroup.GET("/range", ps.getRangeEndpoint)
+	}
+
+	blobGroup := ps.router.Group("/blobs")
+	blobGroup.Use(ps.tokenAuthenticator(), middleware.DecompressRequests(maxBlobSize), middleware.CompressResponses())
+	{
+		blobGroup.GET("/:hash", ps.getBlobEndpoint)
+		blobGroup.POST("/:hash", ps.writeAuthenticator(), ps.postBlobEndpoint)
+	}
+
+	if ps.servicer.GossipService() != nil {
+		gossipGroup := ps.router.Group("/gossip")
+		gossipGroup.Use(ps.tokenAuthenticator(), middleware.DecompressRequests(maxRequestSize), middleware.CompressResponses())
+		{
+			gossipGroup.POST("/announce", ps.writeAuthenticator(), ps.announceEndpoint)
+		}
+	}
+}
+
+// tokenAuthenticator requires the authority_token as a bearer token when one
+// is configured.
+func (ps *PeerServer) tokenAuthenticator() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := viper.GetString("authority_token")
+		if token == "" {
+			c.Next()
+			return
+		}
+
+		bearer := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) == 1 {
+			c.Next()
+			return
+		}
+
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"status": "not authorized",
+		})
+		c.Abort()
+	}
+}
+
+// writeAuthenticator refuses writes while no authority_token is configured.
+// It runs after tokenAuthenticator, which checks the token itself.
+func (ps *PeerServer) writeAuthenticator() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if viper.GetString("authority_token") != "" {
+			c.Next()
+			return
+		}
+
+		c.JSON(http.StatusForbidden, gin.H{
+			"status": "writes require an authority_token",
+		})
+		c.Abort()
+	}
+}
+
+func (ps *PeerServer) Execute(ctx context.Context) error {
+	ps.registerChainRoutes()
+
+	server := &http.Server{
+		Addr:    ":" + viper.GetString("peer_port"),
+		Handler: ps.router,
+	}
+
+	serverErr := make(chan error, 1)
+	go func() {
+		log.Logln("serving chain to peers on port", viper.GetString("peer_port"))
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			serverErr <- err
+		}
+		close(serverErr)
+	}()
+
+	select {
+	case err := <-serverErr:
+		return err
+	case <-ctx.Done():
+	}
+	log.Logln("received termination on peer server context")
+
+	return server.Shutdown(context.Background())
+}
@@ -0,0 +1,421 @@
+package peerserver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/govice/golinks/block"
+	"github.com/govice/golinksd/internal/remotetest"
+	"github.com/govice/golinksd/internal/servicertest"
+	"github.com/govice/golinksd/pkg/blobstore"
+	"github.com/govice/golinksd/pkg/blockchain"
+	"github.com/govice/golinksd/pkg/chainbackend"
+	"github.com/govice/golinksd/pkg/golinks"
+	"github.com/govice/golinksd/pkg/gossip"
+	"github.com/govice/golinksd/pkg/peers"
+	"github.com/spf13/viper"
+)
+
+func TestServeChain(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	viper.Set("http_compression", "gzip")
+	defer viper.Set("http_compression", "")
+	viper.Set("authority_token", "secret")
+	defer viper.Set("authority_token", nil)
+
+	bs, err := blockchain.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bs.ResetChain(); err != nil {
+		t.Fatal(err)
+	}
+
+	ps, err := New(&servicertest.Servicer{Blockchain: bs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps.registerChainRoutes()
+	server := httptest.NewServer(ps.router)
+	defer server.Close()
+
+	gs, err := golinks.New(&remotetest.Remote{URL: server.URL, AuthToken: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	genesis, err := gs.GetBlock(0)
+	if err != nil {
+		t.Fatal("expected genesis block from authority.", err)
+	}
+
+	staged := block.NewSHA512(1, []byte("blockmap"), genesis.BlockHash)
+	if err := gs.Append(staged); err != nil {
+		t.Fatal("expected successful upload to authority.", err)
+	}
+
+	forged := block.NewSHA512(2, []byte("blockmap"), staged.BlockHash)
+	forged.Data = []byte("tampered")
+	if err := gs.Append(forged); err == nil || errors.Is(err, chainbackend.ErrConflict) {
+		t.Error("expected upload of a block with a mismatched hash to be rejected as invalid. got", err)
+	}
+
+	stale := block.NewSHA512(1, []byte("blockmap"), genesis.BlockHash)
+	if err := gs.Append(stale); !errors.Is(err, chainbackend.ErrConflict) {
+		t.Error("expected ErrConflict for a block built on a stale head. got", err)
+	}
+
+	if length, err := gs.Length(); err != nil || length != 2 {
+		t.Error("expected authority length 2. got", length, err)
+	}
+
+	blocks, err := gs.GetRange(0, 5)
+	if err != nil {
+		t.Fatal("expected range from authority.", err)
+	}
+	if len(blocks) != 2 || !block.Equal(blocks[1], staged) {
+		t.Error("expected range to end with the uploaded block")
+	}
+
+	if _, err := gs.GetBlock(2); !errors.Is(err, chainbackend.ErrBlockNotFound) {
+		t.Error("expected ErrBlockNotFound beyond the head. got", err)
+	}
+}
+
+func TestServeBlobs(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	viper.Set("authority_token", "secret")
+	defer viper.Set("authority_token", nil)
+
+	dir, err := ioutil.TempDir("", "golinksd-peerserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := blobstore.New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ps, err := New(&servicertest.Servicer{Blobs: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps.registerChainRoutes()
+	server := httptest.NewServer(ps.router)
+	defer server.Close()
+
+	gs, err := golinks.New(&remotetest.Remote{URL: server.URL, AuthToken: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blob := []byte(`{"rootHash":"aGFzaA==","root":"/var/www"}`)
+	hash := blobstore.Hash(blob)
+	if err := gs.PutBlob(hash, blob); err != nil {
+		t.Fatal("expected successful blob upload.", err)
+	}
+	if !store.Has(hash) {
+		t.Error("expected uploaded blob in store")
+	}
+
+	if err := gs.PutBlob(blobstore.Hash([]byte("other")), blob); err == nil {
+		t.Error("expected upload of a blob under another hash to fail")
+	}
+
+	fetched, err := gs.GetBlob(hash)
+	if err != nil {
+		t.Fatal("expected blob from peer.", err)
+	}
+	if string(fetched) != string(blob) {
+		t.Error("expected fetched blob to equal uploaded blob")
+	}
+
+	if _, err := gs.GetBlob(blobstore.Hash([]byte("missing"))); !errors.Is(err, golinks.ErrNotFound) {
+		t.Error("expected ErrNotFound for a missing blob. got", err)
+	}
+}
+
+func TestWriteLimits(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	bs, err := blockchain.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bs.ResetChain(); err != nil {
+		t.Fatal(err)
+	}
+	ps, err := New(&servicertest.Servicer{Blockchain: bs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps.registerChainRoutes()
+	server := httptest.NewServer(ps.router)
+	defer server.Close()
+
+	post := func(body []byte, gzipped bool, token string) int {
+		req, err := http.NewRequest(http.MethodPost, server.URL+"/chain", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if gzipped {
+			req.Header.Set("Content-Encoding", "gzip")
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	// without a token nobody may write
+	if status := post([]byte("{}"), false, ""); status != http.StatusForbidden {
+		t.Error("expected 403 for a write without a configured token. got", status)
+	}
+	if length := bs.ChainLength(); length != 1 {
+		t.Error("expected chain to be left untouched. got length", length)
+	}
+
+	viper.Set("authority_token", "secret")
+	defer viper.Set("authority_token", nil)
+	if status := post([]byte("{}"), false, "other"); status != http.StatusUnauthorized {
+		t.Error("expected 401 for a write with the wrong token. got", status)
+	}
+
+	// a small gzip body expanding past the limit is cut off
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zeros := make([]byte, 1<<20)
+	for written := 0; written <= maxRequestSize; written += len(zeros) {
+		zw.Write(zeros)
+	}
+	zw.Close()
+	if status := post(buf.Bytes(), true, "secret"); status != http.StatusRequestEntityTooLarge {
+		t.Error("expected 413 for a body expanding past the limit. got", status)
+	}
+	if status := post(make([]byte, maxRequestSize+1), false, "secret"); status != http.StatusRequestEntityTooLarge {
+		t.Error("expected 413 for a body past the limit. got", status)
+	}
+}
+
+func TestGossip(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	viper.Set("gossip_period", 50)
+	defer viper.Set("gossip_period", nil)
+	viper.Set("authority_token", "secret")
+	defer viper.Set("authority_token", nil)
+	viper.Set("announced_peer_limit", 8)
+	defer viper.Set("announced_peer_limit", nil)
+	viper.Set("announced_peer_expiry", 60000)
+	defer viper.Set("announced_peer_expiry", nil)
+	defer viper.Set("peers", nil)
+	defer viper.Set("peer_address", nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	var nodes []*gossip.Service
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	// a holds the longest chain, b a shorter fork of it it did not add
+	// itself, c no chain and d a shorter chain of its own
+	dir, err := ioutil.TempDir("", "golinksd-peerserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	newNode := func(name string, setup func(*blockchain.Service), peerAddresses ...string) (*blockchain.Service, string) {
+		bs, err := blockchain.New(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		setup(bs)
+
+		servicer := &servicertest.Servicer{Blockchain: bs}
+		ps, err := New(servicer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		server := httptest.NewUnstartedServer(ps.router)
+		address := "http://" + server.Listener.Addr().String()
+		viper.Set("peer_address", address)
+		viper.Set("peers", peerAddresses)
+		servicer.Peers, err = peers.New(servicer, filepath.Join(dir, name+"-peers.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		servicer.Gossip, err = gossip.New(servicer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ps.registerChainRoutes()
+		server.Start()
+		t.Cleanup(server.Close)
+		nodes = append(nodes, servicer.Gossip)
+		return bs, address
+	}
+	addOwn := func(blocks int) func(*blockchain.Service) {
+		return func(bs *blockchain.Service) {
+			if err := bs.ResetChain(); err != nil {
+				t.Fatal(err)
+			}
+			for i := 1; i < blocks; i++ {
+				if _, err := bs.AddBlock([]byte("own")); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+
+	a, aAddress := newNode("a", addOwn(4))
+	fork := a.Chain()
+	fork.Blocks = fork.Blocks[:2]
+	fork.Blocks = append(fork.Blocks, *block.NewSHA512(2, []byte("fork"), fork.At(1).BlockHash))
+	b, bAddress := newNode("b", func(bs *blockchain.Service) {
+		if err := bs.Adopt(fork); err != nil {
+			t.Fatal(err)
+		}
+	}, aAddress)
+	c, _ := newNode("c", func(*blockchain.Service) {}, bAddress)
+	d, _ := newNode("d", addOwn(2), aAddress)
+	dHead, err := d.GetBlock(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, node := range nodes {
+		node := node
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			node.Execute(ctx)
+		}()
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if b.ChainLength() == 4 && c.ChainLength() == 4 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	head, err := a.GetBlock(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, bs := range map[string]*blockchain.Service{"b": b, "c": c} {
+		blk, err := bs.GetBlock(3)
+		if err != nil || !block.Equal(blk, head) {
+			t.Error("expected", name, "to adopt the longest chain.", err)
+		}
+	}
+	if a.ChainLength() != 4 {
+		t.Error("expected a to keep its chain. got length", a.ChainLength())
+	}
+	if blk, err := d.GetBlock(1); err != nil || d.ChainLength() != 2 || !block.Equal(blk, dHead) {
+		t.Error("expected d to keep the blocks it added.", err)
+	}
+}
+
+func TestAnnounce(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	viper.Set("announced_peer_limit", 1)
+	defer viper.Set("announced_peer_limit", nil)
+	viper.Set("announced_peer_expiry", 60000)
+	defer viper.Set("announced_peer_expiry", nil)
+
+	dir, err := ioutil.TempDir("", "golinksd-peerserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	bs, err := blockchain.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	servicer := &servicertest.Servicer{Blockchain: bs}
+	if servicer.Peers, err = peers.New(servicer, filepath.Join(dir, "peers.json")); err != nil {
+		t.Fatal(err)
+	}
+	if servicer.Gossip, err = gossip.New(servicer); err != nil {
+		t.Fatal(err)
+	}
+	ps, err := New(servicer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps.registerChainRoutes()
+	server := httptest.NewServer(ps.router)
+	defer server.Close()
+
+	announce := func(head gossip.Announcement, token string, forwardedFor string) int {
+		headBytes, _ := json.Marshal(head)
+		req, err := http.NewRequest(http.MethodPost, server.URL+"/gossip/announce", bytes.NewReader(headBytes))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		if forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	if status := announce(gossip.Announcement{Port: 7777}, "", ""); status != http.StatusForbidden {
+		t.Error("expected 403 for an announcement without a configured token. got", status)
+	}
+
+	viper.Set("authority_token", "secret")
+	defer viper.Set("authority_token", nil)
+	if status := announce(gossip.Announcement{Port: 7777}, "other", ""); status != http.StatusUnauthorized {
+		t.Error("expected 401 for an announcement with the wrong token. got", status)
+	}
+
+	// announced addresses must be on the announcing host
+	if status := announce(gossip.Announcement{Address: "http://169.254.169.254", Port: 80}, "secret", ""); status != http.StatusForbidden {
+		t.Error("expected 403 for an address on another host. got", status)
+	}
+
+	// the source is taken from the connection, not from forwarding headers
+	if status := announce(gossip.Announcement{Port: 7777}, "secret", "10.0.0.9"); status != http.StatusOK {
+		t.Error("expected announcement to be accepted. got", status)
+	}
+	if _, err := servicer.Peers.Peer("http://127.0.0.1:7777"); err != nil {
+		t.Error("expected peer on the connection's host.", err)
+	}
+	if _, err := servicer.Peers.Peer("http://10.0.0.9:7777"); !errors.Is(err, peers.ErrUnknownPeer) {
+		t.Error("expected no peer on the forwarded host. got", err)
+	}
+
+	if status := announce(gossip.Announcement{Port: 7778}, "secret", ""); status != http.StatusTooManyRequests {
+		t.Error("expected 429 past announced_peer_limit. got", status)
+	}
+	if status := announce(gossip.Announcement{Port: 7777}, "secret", ""); status != http.StatusOK {
+		t.Error("expected known peers to keep announcing. got", status)
+	}
+}
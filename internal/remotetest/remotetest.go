@@ -0,0 +1,23 @@
+// Package remotetest provides a golinks remote for tests talking to an
+// httptest server.
+package remotetest
+
+// Remote serves the chain and blob endpoints under URL, the way golinksd's
+// peer server lays them out.
+type Remote struct {
+	URL       string
+	AuthToken string
+}
+
+func (r *Remote) Token() string {
+	return r.AuthToken
+}
+
+func (r *Remote) Name() string {
+	return "test"
+}
+
+func (r *Remote) ChainLengthEndpoint() string { return r.URL + "/chain/length" }
+func (r *Remote) ChainBlockEndpoint() string  { return r.URL + "/chain" }
+func (r *Remote) ChainRangeEndpoint() string  { return r.URL + "/chain/range" }
+func (r *Remote) BlobEndpoint() string        { return r.URL + "/blobs" }
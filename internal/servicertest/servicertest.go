@@ -0,0 +1,58 @@
+// Package servicertest provides a servicer for tests of the daemon's servers.
+package servicertest
+
+import (
+	"github.com/govice/golinksd/pkg/authentication"
+	"github.com/govice/golinksd/pkg/blobstore"
+	"github.com/govice/golinksd/pkg/blockchain"
+	"github.com/govice/golinksd/pkg/chaintracker"
+	"github.com/govice/golinksd/pkg/config"
+	"github.com/govice/golinksd/pkg/gossip"
+	"github.com/govice/golinksd/pkg/peers"
+	"github.com/govice/golinksd/pkg/worker"
+)
+
+// Servicer returns the services it holds. Services left nil are returned as
+// nil.
+type Servicer struct {
+	Blockchain     *blockchain.Service
+	Workers        *worker.Service
+	Authentication *authentication.Service
+	Config         *config.Service
+	Blobs          *blobstore.Store
+	ChainTracker   *chaintracker.Service
+	Peers          *peers.Service
+	Gossip         *gossip.Service
+}
+
+func (s *Servicer) BlockchainService() *blockchain.Service {
+	return s.Blockchain
+}
+
+func (s *Servicer) WorkerService() *worker.Service {
+	return s.Workers
+}
+
+func (s *Servicer) AuthenticationService() *authentication.Service {
+	return s.Authentication
+}
+
+func (s *Servicer) ConfigService() *config.Service {
+	return s.Config
+}
+
+func (s *Servicer) BlobStore() *blobstore.Store {
+	return s.Blobs
+}
+
+func (s *Servicer) ChainTrackerService() *chaintracker.Service {
+	return s.ChainTracker
+}
+
+func (s *Servicer) PeerService() *peers.Service {
+	return s.Peers
+}
+
+func (s *Servicer) GossipService() *gossip.Service {
+	return s.Gossip
+}
@@ -3,13 +3,21 @@ package webserver
 import (
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"log"
+	"math"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/govice/golinks/block"
+	"github.com/govice/golinksd/internal/middleware"
 	"github.com/govice/golinksd/pkg/authentication"
+	"github.com/govice/golinksd/pkg/blobstore"
+	"github.com/govice/golinksd/pkg/blockchain"
+	"github.com/govice/golinksd/pkg/chainbackend"
+	"github.com/govice/golinksd/pkg/worker"
 )
 
 func (w *Webserver) externalAuthenticator() gin.HandlerFunc {
@@ -18,6 +26,10 @@ func (w *Webserver) externalAuthenticator() gin.HandlerFunc {
 			Token: c.Query("token"),
 			Email: c.Query("email"),
 		}
+		// golinks clients send their token as a bearer token
+		if userAuth.Token == "" {
+			userAuth.Token = strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		}
 
 		ok, err := w.servicer.AuthenticationService().Valid(userAuth)
 		if (err == nil) && ok {
@@ -38,7 +50,18 @@ func (w *Webserver) externalAuthenticator() gin.HandlerFunc {
 }
 
 func (w *Webserver) postBlockEndpoint(c *gin.Context) {
-	body, _ := c.GetRawData()
+	body, ok := middleware.ReadBody(c)
+	if !ok {
+		return
+	}
+
+	// golinks clients upload whole blocks staged on the chain head
+	staged := &block.Block{}
+	if err := json.Unmarshal(body, staged); err == nil && len(staged.BlockHash) != 0 {
+		w.appendBlock(c, staged)
+		return
+	}
+
 	var data blockData
 	if err := json.Unmarshal(body, &data); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
@@ -48,7 +71,12 @@ func (w *Webserver) postBlockEndpoint(c *gin.Context) {
 	}
 
 	block, err := w.servicer.BlockchainService().AddBlock([]byte(data.Data))
-	if err != nil {
+	if errors.Is(err, blockchain.ErrMissingChain) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"status": "chain has not been initialized",
+		})
+		return
+	} else if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"status": "error adding block to chain",
 		})
@@ -58,9 +86,40 @@ func (w *Webserver) postBlockEndpoint(c *gin.Context) {
 	c.PureJSON(http.StatusOK, block)
 }
 
+func (w *Webserver) appendBlock(c *gin.Context, staged *block.Block) {
+	err := w.servicer.BlockchainService().Append(staged)
+	if errors.Is(err, chainbackend.ErrConflict) {
+		c.JSON(http.StatusConflict, gin.H{
+			"status": "block does not extend the chain head",
+			"length": w.servicer.BlockchainService().ChainLength(),
+		})
+		return
+	} else if errors.Is(err, chainbackend.ErrInvalidBlock) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "block hash does not match its contents",
+		})
+		return
+	} else if errors.Is(err, blockchain.ErrMissingChain) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"status": "chain has not been initialized",
+		})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error adding block to chain",
+		})
+		return
+	}
+
+	c.PureJSON(http.StatusOK, staged)
+}
+
 func (w *Webserver) findBlockEndpoint(c *gin.Context) {
 	//todo find a way to pass raw bytes in parameter or migrate to body request
-	body, _ := c.GetRawData()
+	body, ok := middleware.ReadBody(c)
+	if !ok {
+		return
+	}
 	var finder blockchainSearch
 	if err := json.Unmarshal(body, &finder); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
@@ -98,8 +157,242 @@ func (w *Webserver) findBlockEndpoint(c *gin.Context) {
 	}
 }
 
+// getChainEndpoint responds with the block at the index query parameter, or
+// with the whole chain without one.
 func (w *Webserver) getChainEndpoint(c *gin.Context) {
-	c.PureJSON(http.StatusOK, w.servicer.BlockchainService().Chain())
+	if _, ok := c.GetQuery("index"); !ok {
+		c.PureJSON(http.StatusOK, w.servicer.BlockchainService().Chain())
+		return
+	}
+
+	index, err := strconv.Atoi(c.Query("index"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "invalid block index",
+		})
+		return
+	}
+
+	blk, err := w.servicer.BlockchainService().GetBlock(index)
+	if errors.Is(err, chainbackend.ErrBlockNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status": "Block not found",
+		})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error reading block",
+		})
+		return
+	}
+
+	c.PureJSON(http.StatusOK, blk)
+}
+
+func (w *Webserver) getChainRangeEndpoint(c *gin.Context) {
+	start, startErr := strconv.Atoi(c.Query("start"))
+	end, endErr := strconv.Atoi(c.Query("end"))
+	if startErr != nil || endErr != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "invalid block range",
+		})
+		return
+	}
+	if end-start >= maxRangeBlocks {
+		end = start + maxRangeBlocks - 1
+	}
+
+	blocks, err := w.servicer.BlockchainService().GetRange(start, end)
+	if errors.Is(err, chainbackend.ErrBlockNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status": "Block not found",
+		})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error reading blocks",
+		})
+		return
+	}
+
+	c.PureJSON(http.StatusOK, gin.H{
+		"blocks": blocks,
+	})
+}
+
+// maxRangeBlocks caps the blocks returned by a single range request.
+const maxRangeBlocks = 500
+
+func (w *Webserver) getChainLengthEndpoint(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"length": w.servicer.BlockchainService().ChainLength(),
+	})
+}
+
+// queryBlocksEndpoint looks blocks of the local chain of the remote query
+// parameter up in its index by index, hash, parent_hash, from and to
+// timestamps, worker or root. Hashes are base64 encoded.
+func (w *Webserver) queryBlocksEndpoint(c *gin.Context) {
+	tracker, err := w.servicer.ChainTrackerService().Tracker(c.Query("remote"))
+	if err != nil || tracker.Index() == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status": "unknown remote",
+		})
+		return
+	}
+	index := tracker.Index()
+
+	var blocks []*block.Block
+	switch {
+	case c.Query("index") != "":
+		i, parseErr := strconv.Atoi(c.Query("index"))
+		if parseErr != nil {
+			err = errInvalidQuery
+			break
+		}
+		blocks, err = single(index.Block(i))
+	case c.Query("hash") != "":
+		hash, parseErr := base64.StdEncoding.DecodeString(c.Query("hash"))
+		if parseErr != nil {
+			err = errInvalidQuery
+			break
+		}
+		blocks, err = single(index.ByHash(hash))
+	case c.Query("parent_hash") != "":
+		hash, parseErr := base64.StdEncoding.DecodeString(c.Query("parent_hash"))
+		if parseErr != nil {
+			err = errInvalidQuery
+			break
+		}
+		blocks, err = single(index.ByParentHash(hash))
+	case c.Query("from") != "" || c.Query("to") != "":
+		from, fromErr := strconv.ParseInt(c.DefaultQuery("from", "0"), 10, 64)
+		to, toErr := strconv.ParseInt(c.DefaultQuery("to", strconv.FormatInt(math.MaxInt64, 10)), 10, 64)
+		if fromErr != nil || toErr != nil {
+			err = errInvalidQuery
+			break
+		}
+		blocks, err = index.ByTimestamp(from, to)
+	case c.Query("worker") != "":
+		blocks, err = index.ByWorker(c.Query("worker"))
+	case c.Query("root") != "":
+		blocks, err = index.ByRoot(c.Query("root"))
+	default:
+		err = errInvalidQuery
+	}
+
+	if errors.Is(err, errInvalidQuery) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "invalid block query",
+		})
+		return
+	} else if errors.Is(err, chainbackend.ErrBlockNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status": "Block not found",
+		})
+		return
+	} else if errors.Is(err, chainbackend.ErrBlockPruned) {
+		c.JSON(http.StatusGone, gin.H{
+			"status": "block was pruned",
+		})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error querying blocks",
+		})
+		return
+	}
+
+	if len(blocks) > maxRangeBlocks {
+		blocks = blocks[:maxRangeBlocks]
+	}
+	c.PureJSON(http.StatusOK, gin.H{
+		"blocks": blocks,
+	})
+}
+
+var errInvalidQuery = errors.New("webserver: invalid block query")
+
+// single wraps the result of a lookup of one block for queryBlocksEndpoint.
+func single(blk *block.Block, err error) ([]*block.Block, error) {
+	if err != nil {
+		return nil, err
+	}
+	return []*block.Block{blk}, nil
+}
+
+func (w *Webserver) getBlobEndpoint(c *gin.Context) {
+	blob, err := w.servicer.BlobStore().Get(c.Param("hash"))
+	if errors.Is(err, blobstore.ErrInvalidHash) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "invalid blob hash",
+		})
+		return
+	} else if errors.Is(err, blobstore.ErrBlobNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status": "blob not found",
+		})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error reading blob",
+		})
+		return
+	}
+
+	c.Data(http.StatusOK, "application/octet-stream", blob)
+}
+
+func (w *Webserver) getWorkersEndpoint(c *gin.Context) {
+	workers := []gin.H{}
+	for index, worker := range w.servicer.WorkerService().Workers() {
+		workers = append(workers, gin.H{
+			"index":             index,
+			"id":                worker.ID(),
+			"root_path":         worker.RootPath,
+			"generation_period": worker.GenerationPeriod,
+			"ignore_paths":      worker.IgnorePaths,
+			"remote":            worker.Remote,
+			"read_only":         worker.ReadOnly(),
+			"source":            worker.Source(),
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"workers": workers,
+	})
+}
+
+func (w *Webserver) deleteWorkerEndpoint(c *gin.Context) {
+	index, err := strconv.Atoi(c.Param("index"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "invalid worker index",
+		})
+		return
+	}
+
+	err = w.servicer.WorkerService().DeleteWorkerByIndex(index)
+	if errors.Is(err, worker.ErrReadOnlyWorker) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"status": "worker is read-only",
+		})
+		return
+	} else if errors.Is(err, worker.ErrWorkerIndexOutOfBonds) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status": "worker not found",
+		})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error deleting worker",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "worker deleted",
+	})
 }
 
 type blockchainSearch struct {
@@ -0,0 +1,131 @@
+package webserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/govice/golinks/block"
+	"github.com/govice/golinksd/internal/remotetest"
+	"github.com/govice/golinksd/internal/servicertest"
+	"github.com/govice/golinksd/pkg/authentication"
+	"github.com/govice/golinksd/pkg/blockchain"
+	"github.com/govice/golinksd/pkg/chainbackend"
+	"github.com/govice/golinksd/pkg/golinks"
+	"github.com/spf13/viper"
+)
+
+func TestGolinksCompatibleEndpoints(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer authServer.Close()
+	viper.Set("auth_server", authServer.URL)
+	defer viper.Set("auth_server", nil)
+
+	bs, err := blockchain.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bs.ResetChain(); err != nil {
+		t.Fatal(err)
+	}
+	as, err := authentication.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ws, err := New(&servicertest.Servicer{Blockchain: bs, Authentication: as})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.registerAPIRoutes(); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(ws.router)
+	defer server.Close()
+
+	gs, err := golinks.New(&remotetest.Remote{URL: server.URL + "/api"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	genesis, err := gs.GetBlock(0)
+	if err != nil {
+		t.Fatal("expected genesis block.", err)
+	}
+
+	staged := block.NewSHA512(1, []byte("blockmap"), genesis.BlockHash)
+	if err := gs.Append(staged); err != nil {
+		t.Fatal("expected successful append of staged block.", err)
+	}
+	if err := gs.Append(block.NewSHA512(1, []byte("blockmap"), genesis.BlockHash)); !errors.Is(err, chainbackend.ErrConflict) {
+		t.Error("expected ErrConflict for a block on a stale head. got", err)
+	}
+
+	if length, err := gs.GetLength(); err != nil || length != 2 {
+		t.Error("expected length 2. got", length, err)
+	}
+
+	blocks, err := gs.GetRange(0, 1)
+	if err != nil || len(blocks) != 2 || !block.Equal(blocks[1], staged) {
+		t.Error("expected range to end with the staged block.", err)
+	}
+
+	if _, err := gs.GetBlock(5); !errors.Is(err, chainbackend.ErrBlockNotFound) {
+		t.Error("expected ErrBlockNotFound beyond the head. got", err)
+	}
+}
+
+func TestMetricsRequireAuthentication(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var userAuth authentication.ExternalUserAuth
+		if err := json.NewDecoder(r.Body).Decode(&userAuth); err != nil || userAuth.Token != "token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer authServer.Close()
+	viper.Set("auth_server", authServer.URL)
+	defer viper.Set("auth_server", nil)
+
+	as, err := authentication.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws, err := New(&servicertest.Servicer{Authentication: as})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.registerFrontendRoutes(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.registerAPIRoutes(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{path: "/debug/vars", status: http.StatusNotFound},
+		{path: "/api/metrics", status: http.StatusUnauthorized},
+		{path: "/api/metrics?token=wrong", status: http.StatusUnauthorized},
+		{path: "/api/metrics?token=token", status: http.StatusOK},
+	}
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		ws.router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, test.path, nil))
+		if rec.Code != test.status {
+			t.Error("expected status", test.status, "for", test.path, "got", rec.Code)
+		}
+	}
+}
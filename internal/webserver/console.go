@@ -1,12 +1,17 @@
 package webserver
 
 import (
+	"crypto/subtle"
+	"errors"
 	"net/http"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"github.com/govice/golinksd/pkg/config"
 	"github.com/govice/golinksd/pkg/log"
+	"github.com/govice/golinksd/pkg/peers"
 	"github.com/govice/golinksd/pkg/worker"
+	"github.com/spf13/viper"
 )
 
 func (w *Webserver) registerConsoleHandlers() error {
@@ -33,32 +38,48 @@ func (w *Webserver) registerConsoleHandlers() error {
 			},
 		}
 
-		for index, worker := range w.servicer.WorkerService().WorkerConfig.Workers {
+		for index, worker := range w.servicer.WorkerService().Workers() {
+			label := worker.RootPath
+			if worker.ReadOnly() {
+				label += " (read-only)"
+			}
 			option := &CardOption{
-				Label: worker.RootPath,
+				Label: label,
 				URL:   "/console/worker/view/" + strconv.Itoa(index),
 			}
 			workersCard.Options = append(workersCard.Options, option)
 		}
+		chainCard := &ConsoleCard{
+			Title: "Block Chainer",
+			Options: []*CardOption{
+				{
+					Label: "Add Block",
+					URL:   "/console/addBlock",
+				},
+				{
+					Label: "Get Chain",
+					URL:   "/console/getChain",
+				},
+			},
+		}
+		if !viper.GetBool("genesis") {
+			chainCard.Options = append(chainCard.Options, &CardOption{
+				Label: "Delete Chain",
+				URL:   "/console/deleteChain",
+			})
+		}
 		consoleCards := []*ConsoleCard{
+			chainCard,
+			workersCard,
 			{
-				Title: "Block Chainer",
+				Title: "Peers",
 				Options: []*CardOption{
 					{
-						Label: "Add Block",
-						URL:   "/console/addBlock",
-					},
-					{
-						Label: "Get Chain",
-						URL:   "/console/getChain",
-					},
-					{
-						Label: "Delete Chain",
-						URL:   "/console/deleteChain",
+						Label: "View Peers",
+						URL:   "/console/peers",
 					},
 				},
 			},
-			workersCard,
 		}
 
 		c.HTML(http.StatusOK, "index.html", gin.H{
@@ -90,14 +111,35 @@ func (w *Webserver) registerConsoleHandlers() error {
 	})
 
 	router.GET("console/deleteChain", func(c *gin.Context) {
+		if viper.GetBool("genesis") {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
 		c.HTML(http.StatusOK, "deleteChain.html", gin.H{
-			"title":   "GoLinks | Delete Chain",
-			"heading": "Delete Chain?",
+			"title":        "GoLinks | Delete Chain",
+			"heading":      "Delete Chain?",
+			"RequireToken": viper.GetString("authority_token") != "",
 		})
 	})
 
+	// the authority chain is never deleted from the console, and deleting the
+	// daemon's own chain is confirmed with the authority_token when one is set
 	router.POST("console/deleteChain", func(c *gin.Context) {
-		w.servicer.BlockchainService().ResetChain()
+		if viper.GetBool("genesis") {
+			log.Warnln("refused to delete the authority chain from the console")
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		if token := viper.GetString("authority_token"); token != "" && subtle.ConstantTimeCompare([]byte(c.PostForm("token")), []byte(token)) != 1 {
+			log.Warnln("refused to delete the chain without the authority_token")
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		if err := w.servicer.BlockchainService().ResetChain(); err != nil {
+			log.Errln("failed to reset chain", err)
+			c.Redirect(http.StatusSeeOther, "/error")
+			return
+		}
 		c.Redirect(http.StatusSeeOther, "/console")
 	})
 
@@ -121,10 +163,18 @@ func (w *Webserver) registerConsoleHandlers() error {
 			return
 		}
 
+		remote := worker.Remote
+		if remote == "" {
+			remote = config.DefaultRemote
+		}
+
 		c.HTML(http.StatusOK, "worker.tmpl.html", gin.H{
 			"Root":          worker.RootPath,
 			"Index":         id,
 			"RefreshPeriod": worker.GenerationPeriod,
+			"Remote":        remote,
+			"ReadOnly":      worker.ReadOnly(),
+			"Source":        worker.Source(),
 		})
 
 	})
@@ -143,7 +193,11 @@ func (w *Webserver) registerConsoleHandlers() error {
 			return
 		}
 
-		if err := w.servicer.WorkerService().DeleteWorkerByIndex(id); err != nil {
+		if err := w.servicer.WorkerService().DeleteWorkerByIndex(id); errors.Is(err, worker.ErrReadOnlyWorker) {
+			log.Logln(err)
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		} else if err != nil {
 			log.Logln(err)
 			c.AbortWithStatus(http.StatusBadRequest)
 			return
@@ -153,7 +207,13 @@ func (w *Webserver) registerConsoleHandlers() error {
 	})
 
 	router.GET("console/worker/add", func(c *gin.Context) {
-		c.HTML(http.StatusOK, "workerAdd.tmpl.html", nil)
+		var remotes []string
+		for _, remote := range w.servicer.ConfigService().Remotes() {
+			remotes = append(remotes, remote.Name())
+		}
+		c.HTML(http.StatusOK, "workerAdd.tmpl.html", gin.H{
+			"Remotes": remotes,
+		})
 	})
 
 	router.POST("console/worker/add", func(c *gin.Context) {
@@ -179,6 +239,7 @@ func (w *Webserver) registerConsoleHandlers() error {
 		if err := w.servicer.WorkerService().AddWorker(&worker.NewWorkerConfig{
 			RootPath:         rootPath,
 			GenerationPeriod: generationPeriod,
+			Remote:           c.PostForm("remote"),
 		}); err != nil {
 			c.AbortWithError(http.StatusInternalServerError, err)
 			return
@@ -187,6 +248,40 @@ func (w *Webserver) registerConsoleHandlers() error {
 		c.Redirect(http.StatusSeeOther, "/console")
 	})
 
+	router.GET("console/peers", func(c *gin.Context) {
+		c.HTML(http.StatusOK, "peers.tmpl.html", gin.H{
+			"title": "GoLinks | Peers",
+			"Peers": w.servicer.PeerService().Status(),
+		})
+	})
+
+	router.POST("console/peers/add", func(c *gin.Context) {
+		if _, err := w.servicer.PeerService().Add(c.PostForm("address")); errors.Is(err, peers.ErrInvalidAddress) || errors.Is(err, peers.ErrSelfPeer) {
+			log.Logln(err)
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		} else if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+
+		c.Redirect(http.StatusSeeOther, "/console/peers")
+	})
+
+	router.POST("console/peers/remove", func(c *gin.Context) {
+		if err := w.servicer.PeerService().Remove(c.PostForm("address")); errors.Is(err, peers.ErrReadOnlyPeer) {
+			log.Logln(err)
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		} else if err != nil {
+			log.Logln(err)
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+
+		c.Redirect(http.StatusSeeOther, "/console/peers")
+	})
+
 	return nil
 
 }
@@ -0,0 +1,68 @@
+package webserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/govice/golinksd/internal/servicertest"
+	"github.com/govice/golinksd/pkg/blockchain"
+	"github.com/spf13/viper"
+)
+
+func TestDeleteChain(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	bs, err := blockchain.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bs.ResetChain(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bs.AddBlock([]byte("blockmap")); err != nil {
+		t.Fatal(err)
+	}
+
+	ws, err := New(&servicertest.Servicer{Blockchain: bs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.registerConsoleHandlers(); err != nil {
+		t.Fatal(err)
+	}
+
+	deleteChain := func(token string) int {
+		form := url.Values{"token": {token}}
+		req := httptest.NewRequest(http.MethodPost, "/console/deleteChain", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		ws.router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	viper.Set("genesis", true)
+	if status := deleteChain(""); status != http.StatusForbidden {
+		t.Error("expected 403 deleting the authority chain. got", status)
+	}
+	viper.Set("genesis", nil)
+
+	viper.Set("authority_token", "secret")
+	defer viper.Set("authority_token", nil)
+	if status := deleteChain("other"); status != http.StatusForbidden {
+		t.Error("expected 403 deleting the chain with the wrong token. got", status)
+	}
+	if length := bs.ChainLength(); length != 2 {
+		t.Fatal("expected the chain to be kept. got length", length)
+	}
+
+	if status := deleteChain("secret"); status != http.StatusSeeOther {
+		t.Error("expected the chain to be deleted with the token. got", status)
+	}
+	if length := bs.ChainLength(); length != 1 {
+		t.Error("expected a reset chain of length 1. got", length)
+	}
+}
@@ -0,0 +1,90 @@
+package webserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/govice/golinksd/internal/middleware"
+	"github.com/govice/golinksd/pkg/log"
+	"github.com/govice/golinksd/pkg/peers"
+)
+
+type peerData struct {
+	Address string `json:"address"`
+}
+
+func (w *Webserver) getPeersEndpoint(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"peers": w.servicer.PeerService().Status(),
+	})
+}
+
+func (w *Webserver) addPeerEndpoint(c *gin.Context) {
+	body, ok := middleware.ReadBody(c)
+	if !ok {
+		return
+	}
+	var data peerData
+	if err := json.Unmarshal(body, &data); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "recieved invalid peer",
+		})
+		return
+	}
+
+	peer, err := w.servicer.PeerService().Add(data.Address)
+	if errors.Is(err, peers.ErrInvalidAddress) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "invalid peer address",
+		})
+		return
+	} else if errors.Is(err, peers.ErrSelfPeer) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "peer address refers to this daemon",
+		})
+		return
+	} else if err != nil {
+		log.Errln("failed to add peer", data.Address, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error adding peer",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "peer added",
+		"address": peer.Address(),
+	})
+}
+
+func (w *Webserver) removePeerEndpoint(c *gin.Context) {
+	err := w.servicer.PeerService().Remove(c.Query("address"))
+	if errors.Is(err, peers.ErrInvalidAddress) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "invalid peer address",
+		})
+		return
+	} else if errors.Is(err, peers.ErrUnknownPeer) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status": "peer not found",
+		})
+		return
+	} else if errors.Is(err, peers.ErrReadOnlyPeer) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"status": "peer is read-only",
+		})
+		return
+	} else if err != nil {
+		log.Errln("failed to remove peer", c.Query("address"), err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error removing peer",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "peer removed",
+	})
+}
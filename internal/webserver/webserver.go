@@ -2,12 +2,19 @@ package webserver
 
 import (
 	"context"
+	"expvar"
 	"net/http"
+	"path/filepath"
 
 	"github.com/gin-gonic/gin"
+	"github.com/govice/golinksd/internal/middleware"
 	"github.com/govice/golinksd/pkg/authentication"
+	"github.com/govice/golinksd/pkg/blobstore"
 	"github.com/govice/golinksd/pkg/blockchain"
+	"github.com/govice/golinksd/pkg/chaintracker"
+	"github.com/govice/golinksd/pkg/config"
 	"github.com/govice/golinksd/pkg/log"
+	"github.com/govice/golinksd/pkg/peers"
 	"github.com/govice/golinksd/pkg/worker"
 	"github.com/spf13/viper"
 )
@@ -29,10 +36,30 @@ type AuthenticationServicer interface {
 	AuthenticationService() *authentication.Service
 }
 
+type BlobStoreServicer interface {
+	BlobStore() *blobstore.Store
+}
+
+type ChainTrackerServicer interface {
+	ChainTrackerService() *chaintracker.Service
+}
+
+type PeerServicer interface {
+	PeerService() *peers.Service
+}
+
+type ConfigServicer interface {
+	ConfigService() *config.Service
+}
+
 type Servicer interface {
 	BlockchainServicer
 	WorkerServicer
 	AuthenticationServicer
+	ConfigServicer
+	BlobStoreServicer
+	ChainTrackerServicer
+	PeerServicer
 }
 
 func New(servicer Servicer) (*Webserver, error) {
@@ -52,19 +79,41 @@ func (w *Webserver) registerFrontendRoutes() error {
 	return w.registerConsoleHandlers()
 }
 
+// maxRequestSize caps the size of API request bodies.
+const maxRequestSize = 64 << 20
+
 func (w *Webserver) registerAPIRoutes() error {
 	apiGroup := w.router.Group("/api")
-	apiGroup.Use(w.externalAuthenticator())
+	apiGroup.Use(w.externalAuthenticator(), middleware.DecompressRequests(maxRequestSize), middleware.CompressResponses())
 	{
 		apiGroup.POST("/chain", w.postBlockEndpoint)
 		apiGroup.GET("/chain", w.getChainEndpoint)
+		apiGroup.GET("/chain/length", w.getChainLengthEndpoint)
+		apiGroup.GET("/chain/range", w.getChainRangeEndpoint)
 		apiGroup.POST("/chain/find", w.findBlockEndpoint)
+		apiGroup.GET("/chain/blocks", w.queryBlocksEndpoint)
+		apiGroup.GET("/blobs/:hash", w.getBlobEndpoint)
+		apiGroup.GET("/workers", w.getWorkersEndpoint)
+		apiGroup.DELETE("/workers/:index", w.deleteWorkerEndpoint)
+		apiGroup.GET("/peers", w.getPeersEndpoint)
+		apiGroup.POST("/peers", w.addPeerEndpoint)
+		apiGroup.DELETE("/peers", w.removePeerEndpoint)
+		apiGroup.GET("/metrics", gin.WrapH(expvar.Handler()))
 	}
 
 	return nil
 }
 
 func (w *Webserver) Execute(ctx context.Context) error {
+	// the console and API are only served once their routes are registered
+	w.router.LoadHTMLGlob(filepath.Join(viper.GetString("templates_home"), "*"))
+	if err := w.registerFrontendRoutes(); err != nil {
+		return err
+	}
+	if err := w.registerAPIRoutes(); err != nil {
+		return err
+	}
+
 	var frontendErr error
 	go func() {
 		if err := w.router.Run(":" + viper.GetString("port")); err != nil {
@@ -0,0 +1,108 @@
+// Package atomicfile writes state files so that a crash never leaves a
+// partially written file in place.
+package atomicfile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/govice/golinksd/pkg/log"
+)
+
+// tempInfix marks the temporary files data is written to before they are
+// renamed into place.
+const tempInfix = ".tmp-"
+
+// corruptInfix marks files moved aside by Quarantine.
+const corruptInfix = ".corrupt-"
+
+// WriteFile writes data to a temporary file in the directory of path, syncs
+// it and renames it over path, so that path holds either its previous or its
+// new contents.
+func WriteFile(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(path)+tempInfix)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return syncDir(dir)
+}
+
+// syncDir makes the directory entries of dir durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil && !os.IsPermission(err) {
+		return err
+	}
+	return nil
+}
+
+// IsTemp reports whether name is a temporary file left by WriteFile.
+func IsTemp(name string) bool {
+	return strings.HasPrefix(name, ".") && strings.Contains(name, tempInfix)
+}
+
+// Recover removes the temporary files under dir left by writes that were
+// interrupted by a crash and returns their paths. A missing dir is not an
+// error.
+func Recover(dir string) ([]string, error) {
+	var removed []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if info.IsDir() || !IsTemp(info.Name()) {
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		log.Warnln("removed partially written file", path)
+		removed = append(removed, path)
+		return nil
+	})
+	return removed, err
+}
+
+// Quarantine moves the unreadable file at path aside to a timestamped name in
+// the same directory and returns the new path.
+func Quarantine(path string) (string, error) {
+	quarantined := path + corruptInfix + time.Now().UTC().Format("20060102T150405Z")
+	if err := os.Rename(path, quarantined); err != nil {
+		return "", err
+	}
+	log.Warnln("moved unreadable file", path, "to", quarantined)
+	return quarantined, syncDir(filepath.Dir(path))
+}
@@ -0,0 +1,51 @@
+package atomicfile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFileAndRecover(t *testing.T) {
+	dir, err := ioutil.TempDir("", "golinksd-atomicfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "workers.json")
+	if err := WriteFile(path, []byte(`{"workers": []}`), 0644); err != nil {
+		t.Fatal("expected successful write.", err)
+	}
+	if err := WriteFile(path, []byte(`{"workers": null}`), 0644); err != nil {
+		t.Fatal("expected successful overwrite.", err)
+	}
+	if data, err := ioutil.ReadFile(path); err != nil || string(data) != `{"workers": null}` {
+		t.Error("expected overwritten contents. got", string(data), err)
+	}
+
+	// simulate a write interrupted before its rename
+	partial := filepath.Join(dir, ".workers.json"+tempInfix+"123")
+	if err := ioutil.WriteFile(partial, []byte(`{"work`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	removed, err := Recover(dir)
+	if err != nil || len(removed) != 1 || removed[0] != partial {
+		t.Error("expected partial file to be removed. got", removed, err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Error("expected state file to be kept.", err)
+	}
+
+	quarantined, err := Quarantine(path)
+	if err != nil {
+		t.Fatal("expected successful quarantine.", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("expected quarantined file to be moved.", err)
+	}
+	if _, err := os.Stat(quarantined); err != nil {
+		t.Error("expected quarantined file at", quarantined, err)
+	}
+}
@@ -5,10 +5,13 @@ import (
 	"encoding/json"
 	"net/http"
 
+	"github.com/govice/golinksd/pkg/httpclient"
 	"github.com/spf13/viper"
 )
 
-type Service struct{}
+type Service struct {
+	client *httpclient.Client
+}
 
 type ExternalUserAuth struct {
 	Token string `json:"token"`
@@ -16,7 +19,11 @@ type ExternalUserAuth struct {
 }
 
 func New() (*Service, error) {
-	as := &Service{}
+	client, err := httpclient.New(httpclient.OptionsFromConfig())
+	if err != nil {
+		return nil, err
+	}
+	as := &Service{client: client}
 	return as, nil
 }
 
@@ -28,10 +35,16 @@ func (service *Service) Valid(userAuth *ExternalUserAuth) (bool, error) {
 	}
 	var buffer bytes.Buffer
 	buffer.Write(authJSON)
-	res, err := http.Post(authServerURI, "application/json", &buffer)
+	req, err := http.NewRequest("POST", authServerURI, &buffer)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := service.client.Do(req)
 	if err != nil {
 		return false, err
 	}
+	defer res.Body.Close()
 
 	if res.StatusCode == http.StatusOK {
 		return true, nil
@@ -0,0 +1,134 @@
+// Package blobstore stores blobs on disk addressed by the SHA-256 hash of their
+// content.
+package blobstore
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/govice/golinksd/pkg/atomicfile"
+	"github.com/govice/golinksd/pkg/log"
+)
+
+var (
+	ErrBlobNotFound = errors.New("blobstore: blob not found")
+	ErrInvalidHash  = errors.New("blobstore: invalid blob hash")
+	ErrHashMismatch = errors.New("blobstore: blob content does not match its hash")
+)
+
+// Store keeps gzip compressed blobs under <dir>/<first two hash digits>/<hash>.gz.
+type Store struct {
+	dir string
+}
+
+func New(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	return &Store{dir: dir}, nil
+}
+
+// Hash returns the hex encoded SHA-256 hash addressing data.
+func Hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// ValidHash reports whether hash is a hex encoded SHA-256 hash.
+func ValidHash(hash string) bool {
+	decoded, err := hex.DecodeString(hash)
+	return err == nil && len(decoded) == sha256.Size && hex.EncodeToString(decoded) == hash
+}
+
+// Put stores data and returns its hash. Storing a blob that already exists is
+// a no-op.
+func (s *Store) Put(data []byte) (string, error) {
+	hash := Hash(data)
+	if s.Has(hash) {
+		return hash, nil
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return "", err
+	}
+	if err := zw.Close(); err != nil {
+		return "", err
+	}
+
+	path := s.path(hash)
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return "", err
+	}
+
+	// blobs are renamed into place so that readers never see a partial blob
+	if err := atomicfile.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		log.Errln("failed to store blob", hash)
+		return "", err
+	}
+
+	return hash, nil
+}
+
+// PutVerified stores data if it matches hash.
+func (s *Store) PutVerified(hash string, data []byte) error {
+	if !ValidHash(hash) {
+		return ErrInvalidHash
+	}
+	if Hash(data) != hash {
+		return ErrHashMismatch
+	}
+	_, err := s.Put(data)
+	return err
+}
+
+// Get returns the blob addressed by hash, verifying its content.
+func (s *Store) Get(hash string) ([]byte, error) {
+	if !ValidHash(hash) {
+		return nil, ErrInvalidHash
+	}
+
+	f, err := os.Open(s.path(hash))
+	if os.IsNotExist(err) {
+		return nil, ErrBlobNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	data, err := ioutil.ReadAll(zr)
+	if err != nil {
+		return nil, err
+	}
+	if Hash(data) != hash {
+		log.Errln("stored blob", hash, "does not match its hash")
+		return nil, ErrHashMismatch
+	}
+	return data, nil
+}
+
+// Has reports whether the blob addressed by hash is stored.
+func (s *Store) Has(hash string) bool {
+	if !ValidHash(hash) {
+		return false
+	}
+	_, err := os.Stat(s.path(hash))
+	return err == nil
+}
+
+func (s *Store) path(hash string) string {
+	return filepath.Join(s.dir, hash[:2], hash+".gz")
+}
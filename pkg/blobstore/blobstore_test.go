@@ -0,0 +1,62 @@
+package blobstore
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "golinksd-blobstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blob := []byte("blockmap")
+	hash, err := store.Put(blob)
+	if err != nil {
+		t.Fatal("expected successful put.", err)
+	}
+	if hash != Hash(blob) {
+		t.Error("expected put to return the blob's hash. got", hash)
+	}
+
+	fetched, err := store.Get(hash)
+	if err != nil || string(fetched) != string(blob) {
+		t.Error("expected stored blob.", err)
+	}
+
+	if _, err := store.Get(Hash([]byte("missing"))); !errors.Is(err, ErrBlobNotFound) {
+		t.Error("expected ErrBlobNotFound. got", err)
+	}
+	if _, err := store.Get("../../etc/passwd"); !errors.Is(err, ErrInvalidHash) {
+		t.Error("expected ErrInvalidHash. got", err)
+	}
+	if err := store.PutVerified(Hash([]byte("other")), blob); !errors.Is(err, ErrHashMismatch) {
+		t.Error("expected ErrHashMismatch. got", err)
+	}
+
+	// a blob altered on disk is not returned
+	other, err := New(filepath.Join(dir, "other"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherHash, err := other.Put([]byte("tampered"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(other.path(otherHash), store.path(hash)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(hash); !errors.Is(err, ErrHashMismatch) {
+		t.Error("expected ErrHashMismatch for a tampered blob. got", err)
+	}
+}
@@ -3,29 +3,178 @@ package blockchain
 import (
 	"encoding/json"
 	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/govice/golinks/block"
 	"github.com/govice/golinks/blockchain"
+	"github.com/govice/golinksd/pkg/atomicfile"
+	"github.com/govice/golinksd/pkg/chainbackend"
+	"github.com/govice/golinksd/pkg/log"
 )
 
 type Service struct {
 	mutex sync.Mutex
 	chain *blockchain.Blockchain
+	store *chainbackend.Filesystem
+	// owned is the length of the chain through the last block this daemon
+	// added itself
+	owned int
 }
 
-func New() (*Service, error) {
-	return &Service{}, nil
+// ownedFileName records owned in the store's directory.
+const ownedFileName = "owned.json"
+
+type ownedRecord struct {
+	Length int `json:"length"`
 }
 
+// New returns a service persisting its chain to store. The chain previously
+// persisted to store is loaded. A nil store keeps the chain in memory only.
+// The chain is empty until it is loaded or reset.
+func New(store *chainbackend.Filesystem) (*Service, error) {
+	service := &Service{
+		chain: &blockchain.Blockchain{Blocks: []block.Block{}},
+		store: store,
+	}
+	if store == nil {
+		return service, nil
+	}
+
+	length, err := store.Length()
+	if err != nil {
+		log.Errln("failed to read length of persisted chain")
+		return nil, err
+	}
+	if length == 0 {
+		return service, nil
+	}
+
+	blocks, err := store.GetRange(0, length-1)
+	if err != nil {
+		log.Errln("failed to read persisted chain")
+		return nil, err
+	}
+	chain := &blockchain.Blockchain{}
+	for _, b := range blocks {
+		chain.Blocks = append(chain.Blocks, *b)
+	}
+	if err := chain.Validate(); err != nil {
+		log.Errln("persisted chain is invalid")
+		return nil, err
+	}
+
+	owned, err := readOwned(store, chain.Length())
+	if err != nil {
+		log.Errln("failed to read owned blocks of persisted chain")
+		return nil, err
+	}
+
+	service.chain = chain
+	service.owned = owned
+	return service, nil
+}
+
+// readOwned reads the owned length persisted to store. Chains persisted by
+// earlier versions did not record it, and every block of them is treated as
+// owned.
+func readOwned(store *chainbackend.Filesystem, length int) (int, error) {
+	ownedBytes, err := ioutil.ReadFile(filepath.Join(store.Dir(), ownedFileName))
+	if os.IsNotExist(err) {
+		return length, nil
+	} else if err != nil {
+		return 0, err
+	}
+	record := ownedRecord{}
+	if err := json.Unmarshal(ownedBytes, &record); err != nil {
+		log.Errln("unreadable", ownedFileName, "of", store.Dir(), "treating every block as owned")
+		return length, nil
+	}
+	if record.Length > length {
+		return length, nil
+	}
+	return record.Length, nil
+}
+
+// setOwnedLocked records that the blocks through length were added by this
+// daemon.
+func (service *Service) setOwnedLocked(length int) error {
+	if service.store != nil {
+		ownedBytes, err := json.Marshal(ownedRecord{Length: length})
+		if err != nil {
+			return err
+		}
+		if err := atomicfile.WriteFile(filepath.Join(service.store.Dir(), ownedFileName), ownedBytes, 0644); err != nil {
+			log.Errln("failed to record owned blocks")
+			return err
+		}
+	}
+	service.owned = length
+	return nil
+}
+
+var ErrMissingChain = errors.New("blockchainService: chain has not been initialized")
+
+// AddBlock appends a block holding content to the chain.
 func (service *Service) AddBlock(content []byte) (*block.Block, error) {
 	service.mutex.Lock()
 	defer service.mutex.Unlock()
-	service.chain.AddSHA512(content)
-	block := service.chain.At(service.chain.Length() - 1)
-	return block, nil
+	if service.chain.Length() == 0 {
+		return nil, ErrMissingChain
+	}
+
+	head := service.chain.At(service.chain.Length() - 1)
+	blk := block.NewSHA512(service.chain.Length(), content, head.BlockHash)
+	if err := service.appendLocked(blk); err != nil {
+		return nil, err
+	}
+	if err := service.setOwnedLocked(service.chain.Length()); err != nil {
+		return nil, err
+	}
+	return blk, nil
+}
+
+// Append adds blk to the chain if it extends the chain head and its hash
+// matches its contents. The block is owned by this daemon.
+func (service *Service) Append(blk *block.Block) error {
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+	if err := service.appendLocked(blk); err != nil {
+		return err
+	}
+	return service.setOwnedLocked(service.chain.Length())
 }
 
+// appendLocked validates blk against the chain head and persists it before
+// adding it to the chain. Every block added to the chain is added here.
+func (service *Service) appendLocked(blk *block.Block) error {
+	if service.chain.Length() == 0 {
+		return ErrMissingChain
+	}
+	if !chainbackend.VerifyHash(blk) {
+		return chainbackend.ErrInvalidBlock
+	}
+	head := service.chain.At(service.chain.Length() - 1)
+	if err := block.Validate(head, blk); err != nil {
+		return chainbackend.ErrConflict
+	}
+
+	if service.store != nil {
+		if err := service.store.Append(blk); err != nil {
+			log.Errln("failed to persist block", blk.Index)
+			return err
+		}
+	}
+	service.chain.Blocks = append(service.chain.Blocks, *blk)
+	return nil
+}
+
+// ResetChain replaces the chain with a new genesis block. The blocks of the
+// previous chain are moved to the quarantine directory of the store.
 func (service *Service) ResetChain() error {
 	genesis := block.NewSHA512Genesis()
 	service.mutex.Lock()
@@ -35,10 +184,89 @@ func (service *Service) ResetChain() error {
 		return err
 	}
 
+	if dir, err := service.quarantineLocked(service.chain.Blocks, "reset"); err != nil {
+		log.Errln("failed to quarantine chain before reset")
+		return err
+	} else if dir != "" {
+		log.Warnln("reset chain,", service.chain.Length(), "blocks moved to", dir)
+	}
+	if err := service.persistLocked(chain); err != nil {
+		return err
+	}
+
 	service.chain = chain
+	return service.setOwnedLocked(chain.Length())
+}
+
+// quarantineLocked writes blocks about to be removed from the chain to a new
+// directory under the store's quarantine directory, named after the time and
+// reason of their removal, and returns it. Nothing is written for an in-memory
+// chain or no blocks.
+func (service *Service) quarantineLocked(blocks []block.Block, reason string) (string, error) {
+	if service.store == nil || len(blocks) == 0 {
+		return "", nil
+	}
+
+	dir := filepath.Join(service.store.QuarantineDir(), time.Now().UTC().Format("20060102T150405.000000000Z")+"-"+reason)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", err
+	}
+	for i := range blocks {
+		blockBytes, err := json.Marshal(&blocks[i])
+		if err != nil {
+			return "", err
+		}
+		if err := atomicfile.WriteFile(filepath.Join(dir, strconv.Itoa(blocks[i].Index)+".json"), blockBytes, 0644); err != nil {
+			return "", err
+		}
+	}
+	return dir, nil
+}
+
+// persistLocked replaces the persisted chain with chain. The blocks chain
+// shares with the current chain are kept and the blocks after them replaced,
+// so a crash leaves a prefix of chain persisted. A chain sharing no blocks is
+// written aside and renamed into place, so a crash leaves either chain.
+func (service *Service) persistLocked(chain *blockchain.Blockchain) error {
+	if service.store == nil {
+		return nil
+	}
+
+	shared := sharedLength(service.chain, chain)
+	if shared == 0 {
+		blocks := make([]*block.Block, chain.Length())
+		for i := range chain.Blocks {
+			blocks[i] = &chain.Blocks[i]
+		}
+		if err := service.store.Replace(blocks); err != nil {
+			log.Errln("failed to replace persisted chain")
+			return err
+		}
+		return nil
+	}
+
+	if err := service.store.Truncate(shared); err != nil {
+		log.Errln("failed to truncate persisted chain to", shared, "blocks")
+		return err
+	}
+	for i := shared; i < chain.Length(); i++ {
+		if err := service.store.Append(chain.At(i)); err != nil {
+			log.Errln("failed to persist block", chain.At(i).Index)
+			return err
+		}
+	}
 	return nil
 }
 
+// sharedLength returns the number of leading blocks a and b have in common.
+func sharedLength(a, b *blockchain.Blockchain) int {
+	n := 0
+	for n < a.Length() && n < b.Length() && block.Equal(a.At(n), b.At(n)) {
+		n++
+	}
+	return n
+}
+
 func (service *Service) GCI(other *blockchain.Blockchain) (int, error) {
 	service.mutex.Lock()
 	defer service.mutex.Unlock()
@@ -56,6 +284,41 @@ func (service *Service) ChainLength() int {
 	return service.chain.Length()
 }
 
+// Length returns the number of blocks in the chain.
+func (service *Service) Length() (int, error) {
+	return service.ChainLength(), nil
+}
+
+// GetBlock returns the block at index.
+func (service *Service) GetBlock(index int) (*block.Block, error) {
+	blk, err := service.FindBlockByIndex(index)
+	if errors.Is(err, ErrBlockNotFound) {
+		return nil, chainbackend.ErrBlockNotFound
+	}
+	return blk, err
+}
+
+// GetRange returns the blocks from start through end, truncated to the head
+// of the chain.
+func (service *Service) GetRange(start, end int) ([]*block.Block, error) {
+	service.lock()
+	defer service.unlock()
+
+	if start < 0 || start > end || start >= service.chain.Length() {
+		return nil, chainbackend.ErrBlockNotFound
+	}
+	if end >= service.chain.Length() {
+		end = service.chain.Length() - 1
+	}
+
+	var blocks []*block.Block
+	for index := start; index <= end; index++ {
+		blk := service.chain.Blocks[index]
+		blocks = append(blocks, &blk)
+	}
+	return blocks, nil
+}
+
 func (service *Service) lock() {
 	service.mutex.Lock()
 }
@@ -71,8 +334,95 @@ func (service *Service) UpdateChain(other *blockchain.Blockchain) error {
 	if err != nil {
 		return err
 	}
+	return service.replaceLocked(newChain)
+}
+
+// ErrProtectedBlocks indicates a fork that would replace blocks this daemon
+// added itself or blocks covered by the store's checkpoint.
+var ErrProtectedBlocks = errors.New("blockchainService: fork replaces protected blocks")
+
+// protectedLength returns the number of leading blocks a fork may not
+// replace: the blocks this daemon added and the blocks its checkpoint covers.
+func (service *Service) protectedLength() int {
+	protected := service.owned
+	if service.store != nil && service.store.Base() > protected {
+		protected = service.store.Base()
+	}
+	return protected
+}
+
+// replaceLocked replaces the chain with chain. The blocks of the chain that
+// chain does not share are moved to the quarantine directory of the store,
+// and protected blocks are never replaced.
+func (service *Service) replaceLocked(chain *blockchain.Blockchain) error {
+	shared := sharedLength(service.chain, chain)
+	if shared < service.chain.Length() {
+		if shared < service.protectedLength() {
+			log.Warnln("refusing fork at block", shared, "replacing blocks through", service.protectedLength()-1)
+			return ErrProtectedBlocks
+		}
+		if dir, err := service.quarantineLocked(service.chain.Blocks[shared:], "fork"); err != nil {
+			log.Errln("failed to quarantine blocks replaced by fork")
+			return err
+		} else if dir != "" {
+			log.Warnln("replacing chain with a fork at block", shared, "replaced blocks moved to", dir)
+		}
+	}
+
+	if err := service.persistLocked(chain); err != nil {
+		return err
+	}
+	service.chain = chain
+	return nil
+}
+
+// ErrInvalidChain indicates a chain whose blocks are not linked by hash or do
+// not match their hashes.
+var ErrInvalidChain = errors.New("blockchainService: invalid chain")
+
+// Adopt replaces the chain with candidate if candidate is a valid chain longer
+// than it. A candidate extending the chain has its new blocks appended one by
+// one; a candidate forking from the chain replaces it, unless the fork would
+// replace protected blocks.
+func (service *Service) Adopt(candidate *blockchain.Blockchain) error {
+	if err := validateChain(candidate); err != nil {
+		return err
+	}
+
+	service.lock()
+	defer service.unlock()
+	if candidate.Length() <= service.chain.Length() {
+		return blockchain.ErrShorterChain
+	}
+
+	if service.chain.Length() > 0 {
+		if _, err := blockchain.UpdateChain(service.chain, candidate); err == nil {
+			for i := service.chain.Length(); i < candidate.Length(); i++ {
+				if err := service.appendLocked(candidate.At(i)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
 
-	service.chain = newChain
+	return service.replaceLocked(blockchain.Copy(candidate))
+}
+
+// validateChain checks that chain starts with a genesis block and that every
+// block matches its hash and extends the block before it.
+func validateChain(chain *blockchain.Blockchain) error {
+	if chain.Length() == 0 || len(chain.At(0).ParentHash) != 0 {
+		return ErrInvalidChain
+	}
+	for i := 0; i < chain.Length(); i++ {
+		if !chainbackend.VerifyHash(chain.At(i)) {
+			return ErrInvalidChain
+		}
+		if i > 0 && block.Validate(chain.At(i-1), chain.At(i)) != nil {
+			return ErrInvalidChain
+		}
+	}
 	return nil
 }
 
@@ -95,12 +445,12 @@ func (service *Service) FindBlockByIndex(index int) (*block.Block, error) {
 	service.lock()
 	defer service.unlock()
 
-	block := service.chain.At(index)
-	if block == nil {
+	if index < 0 || index >= service.chain.Length() {
 		return nil, ErrBlockNotFound
 	}
 
-	return block, nil
+	block := *service.chain.At(index)
+	return &block, nil
 }
 
 // FindBlockByHash searches for a block by hash
@@ -142,9 +492,12 @@ func (service *Service) FindBlockByTimestamp(timestamp int64) (*block.Block, err
 	return block, nil
 }
 
+// Chain returns a copy of the chain.
 func (service *Service) Chain() *blockchain.Blockchain {
 	service.lock()
 	defer service.unlock()
 
-	return service.chain
+	return blockchain.Copy(service.chain)
 }
+
+var _ chainbackend.ChainBackend = &Service{}
@@ -0,0 +1,208 @@
+package blockchain
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/govice/golinks/block"
+	"github.com/govice/golinks/blockchain"
+	"github.com/govice/golinksd/pkg/chainbackend"
+)
+
+func TestPersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "golinksd-blockchain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := chainbackend.NewFilesystem(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	service, err := New(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := service.ResetChain(); err != nil {
+		t.Fatal(err)
+	}
+	added, err := service.AddBlock([]byte("blockmap"))
+	if err != nil {
+		t.Fatal("expected successful add of block.", err)
+	}
+
+	reopenedStore, err := chainbackend.NewFilesystem(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := New(reopenedStore)
+	if err != nil {
+		t.Fatal("expected persisted chain to load.", err)
+	}
+
+	if length := reopened.ChainLength(); length != 2 {
+		t.Fatal("expected persisted length 2. got", length)
+	}
+	head, err := reopened.GetBlock(1)
+	if err != nil || !block.Equal(head, added) {
+		t.Error("expected persisted head to equal added block.", err)
+	}
+
+	// a reset keeps the blocks it removes in quarantine
+	if err := reopened.ResetChain(); err != nil {
+		t.Fatal(err)
+	}
+	quarantined, err := filepath.Glob(filepath.Join(reopenedStore.QuarantineDir(), "*-reset", "*.json"))
+	if err != nil || len(quarantined) != 2 {
+		t.Error("expected the 2 blocks of the reset chain in quarantine. got", quarantined, err)
+	}
+}
+
+func TestUninitializedChain(t *testing.T) {
+	service, err := New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := service.AddBlock([]byte("blockmap")); !errors.Is(err, ErrMissingChain) {
+		t.Error("expected ErrMissingChain adding to an empty chain. got", err)
+	}
+	if chain := service.Chain(); chain == nil || chain.Length() != 0 {
+		t.Error("expected an empty chain")
+	}
+	if _, err := service.FindBlockByHash([]byte("hash")); !errors.Is(err, ErrBlockNotFound) {
+		t.Error("expected ErrBlockNotFound in an empty chain. got", err)
+	}
+
+	if err := service.ResetChain(); err != nil {
+		t.Fatal(err)
+	}
+	tampered := block.NewSHA512(1, []byte("blockmap"), service.Chain().At(0).BlockHash)
+	tampered.Data = []byte("tampered")
+	if err := service.Append(tampered); !errors.Is(err, chainbackend.ErrInvalidBlock) {
+		t.Error("expected ErrInvalidBlock for a tampered block. got", err)
+	}
+}
+
+func testChain(blocks ...*block.Block) *blockchain.Blockchain {
+	chain := &blockchain.Blockchain{}
+	for _, blk := range blocks {
+		chain.Blocks = append(chain.Blocks, *blk)
+	}
+	return chain
+}
+
+func TestAdoptPersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "golinksd-blockchain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := chainbackend.NewFilesystem(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	service, err := New(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	genesis := block.NewSHA512Genesis()
+	b1 := block.NewSHA512(1, []byte("shared"), genesis.BlockHash)
+	b2 := block.NewSHA512(2, []byte("a"), b1.BlockHash)
+	if err := service.Adopt(testChain(genesis, b1, b2)); err != nil {
+		t.Fatal("expected adoption of a chain.", err)
+	}
+
+	f2 := block.NewSHA512(2, []byte("b"), b1.BlockHash)
+	f3 := block.NewSHA512(3, []byte("b"), f2.BlockHash)
+	fork := testChain(genesis, b1, f2, f3)
+	if err := service.Adopt(fork); err != nil {
+		t.Fatal("expected adoption of a longer fork.", err)
+	}
+
+	reopenedStore, err := chainbackend.NewFilesystem(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := New(reopenedStore)
+	if err != nil {
+		t.Fatal("expected persisted fork to load.", err)
+	}
+	if reopened.ChainLength() != 4 {
+		t.Fatal("expected persisted length 4. got", reopened.ChainLength())
+	}
+	for i := range fork.Blocks {
+		if blk, err := reopened.GetBlock(i); err != nil || !block.Equal(blk, fork.At(i)) {
+			t.Error("expected persisted block", i, "of the fork.", err)
+		}
+	}
+}
+
+func TestAdoptProtectsOwnBlocks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "golinksd-blockchain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := chainbackend.NewFilesystem(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	service, err := New(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// blocks adopted from peers may be replaced by a fork
+	genesis := block.NewSHA512Genesis()
+	b1 := block.NewSHA512(1, []byte("peer"), genesis.BlockHash)
+	if err := service.Adopt(testChain(genesis, b1)); err != nil {
+		t.Fatal(err)
+	}
+	f1 := block.NewSHA512(1, []byte("fork"), genesis.BlockHash)
+	f2 := block.NewSHA512(2, []byte("fork"), f1.BlockHash)
+	if err := service.Adopt(testChain(genesis, f1, f2)); err != nil {
+		t.Fatal("expected a fork replacing adopted blocks to be adopted.", err)
+	}
+	quarantined, err := filepath.Glob(filepath.Join(store.QuarantineDir(), "*-fork", "1.json"))
+	if err != nil || len(quarantined) != 1 {
+		t.Error("expected the replaced block in quarantine. got", quarantined, err)
+	}
+
+	// blocks added by the daemon may not, even after a restart
+	own, err := service.AddBlock([]byte("own"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reopenedStore, err := chainbackend.NewFilesystem(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := New(reopenedStore)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g3 := block.NewSHA512(3, []byte("fork"), f2.BlockHash)
+	g4 := block.NewSHA512(4, []byte("fork"), g3.BlockHash)
+	if err := reopened.Adopt(testChain(genesis, f1, f2, g3, g4)); !errors.Is(err, ErrProtectedBlocks) {
+		t.Error("expected ErrProtectedBlocks for a fork replacing an own block. got", err)
+	}
+	if head, err := reopened.GetBlock(3); err != nil || !block.Equal(head, own) {
+		t.Error("expected the own block to be kept.", err)
+	}
+
+	extension := reopened.Chain()
+	extension.Blocks = append(extension.Blocks, *block.NewSHA512(4, []byte("peer"), own.BlockHash))
+	if err := reopened.Adopt(extension); err != nil {
+		t.Error("expected a chain extending own blocks to be adopted.", err)
+	}
+}
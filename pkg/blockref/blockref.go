@@ -0,0 +1,80 @@
+// Package blockref describes the blockmap references recorded in chain blocks
+// in place of whole blockmaps.
+package blockref
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+
+	"github.com/govice/golinks/blockmap"
+	"github.com/govice/golinksd/pkg/blobstore"
+)
+
+// Type identifies block data holding a Reference.
+const Type = "blockmap_ref"
+
+// Reference records a blockmap stored in a blob store. The blockmap's root
+// hash proves the tree it was generated from, and the blob hash addresses the
+// full blockmap.
+type Reference struct {
+	Type     string `json:"type"`
+	Version  int    `json:"version"`
+	RootHash []byte `json:"root_hash"`
+	BlobHash string `json:"blob_hash"`
+	Root     string `json:"root"`
+	Files    int    `json:"files"`
+	Size     int    `json:"size"`
+	Worker   string `json:"worker,omitempty"`
+}
+
+// New describes blkmap, stored as blob, for the worker with the given id.
+func New(blkmap *blockmap.BlockMap, blob []byte, worker string) *Reference {
+	return &Reference{
+		Type:     Type,
+		Version:  1,
+		RootHash: blkmap.RootHash,
+		BlobHash: blobstore.Hash(blob),
+		Root:     blkmap.Root,
+		Files:    len(blkmap.Archive),
+		Size:     len(blob),
+		Worker:   worker,
+	}
+}
+
+// ErrNotReference indicates block data holding an inline blockmap or other
+// content rather than a Reference.
+var ErrNotReference = errors.New("blockref: block data is not a blockmap reference")
+
+// Parse reads the Reference held in block data.
+func Parse(data []byte) (*Reference, error) {
+	ref := &Reference{}
+	if err := json.Unmarshal(data, ref); err != nil || ref.Type != Type {
+		return nil, ErrNotReference
+	}
+	return ref, nil
+}
+
+// Bytes returns the block data holding ref.
+func (ref *Reference) Bytes() ([]byte, error) {
+	return json.Marshal(ref)
+}
+
+// ErrBlobMismatch indicates a blob that is not the blockmap ref describes.
+var ErrBlobMismatch = errors.New("blockref: blob does not match the reference")
+
+// Verify checks that blob is the blockmap ref describes and returns it.
+func (ref *Reference) Verify(blob []byte) (*blockmap.BlockMap, error) {
+	if blobstore.Hash(blob) != ref.BlobHash {
+		return nil, ErrBlobMismatch
+	}
+
+	blkmap := &blockmap.BlockMap{}
+	if err := json.Unmarshal(blob, blkmap); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(blkmap.RootHash, ref.RootHash) || blkmap.Root != ref.Root {
+		return nil, ErrBlobMismatch
+	}
+	return blkmap, nil
+}
@@ -0,0 +1,66 @@
+package chainbackend
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"errors"
+
+	"github.com/govice/golinks/block"
+)
+
+// ChainBackend is a chain that blocks can be read from and appended to.
+type ChainBackend interface {
+	// Length returns the number of blocks in the chain.
+	Length() (int, error)
+	// GetBlock returns the block at index.
+	GetBlock(index int) (*block.Block, error)
+	// GetRange returns the blocks from start through end. A backend may
+	// return fewer blocks than requested, starting at start, but at least one.
+	GetRange(start, end int) ([]*block.Block, error)
+	// Append adds blk to the head of the chain.
+	Append(blk *block.Block) error
+}
+
+// BlobBackend is implemented by backends that also store the blobs referenced
+// by blocks, addressed by their hex SHA-256 hash.
+type BlobBackend interface {
+	PutBlob(hash string, data []byte) error
+	GetBlob(hash string) ([]byte, error)
+}
+
+var (
+	ErrBlockNotFound    = errors.New("chainbackend: block not found")
+	ErrInvalidBlock     = errors.New("chainbackend: invalid block")
+	ErrEmptyChain       = errors.New("chainbackend: chain is empty")
+	ErrRangeUnsupported = errors.New("chainbackend: backend does not serve block ranges")
+	ErrBlobsUnsupported = errors.New("chainbackend: backend does not store blobs")
+)
+
+// ErrConflict rejects a block that does not extend the current chain head,
+// typically because another block was appended first.
+var ErrConflict = errors.New("chainbackend: block does not extend the chain head")
+
+// validateAppend checks that blk extends a chain ending in head. A nil head
+// describes an empty chain, which only accepts a genesis block.
+func validateAppend(head, blk *block.Block) error {
+	if head == nil {
+		if blk.Index != 0 || len(blk.ParentHash) != 0 {
+			return ErrInvalidBlock
+		}
+		return nil
+	}
+
+	if err := block.Validate(head, blk); err != nil {
+		return ErrConflict
+	}
+	return nil
+}
+
+// VerifyHash recomputes the SHA512 hash of blk and reports whether it matches
+// blk's BlockHash.
+func VerifyHash(blk *block.Block) bool {
+	unhashed := *blk
+	unhashed.BlockHash = nil
+	hash, err := unhashed.Hash(sha512.New())
+	return err == nil && bytes.Equal(hash, blk.BlockHash)
+}
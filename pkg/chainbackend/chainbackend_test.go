@@ -0,0 +1,460 @@
+package chainbackend
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/govice/golinks/block"
+)
+
+func testBackend(t *testing.T, backend ChainBackend) {
+	genesis := block.NewSHA512Genesis()
+	if err := backend.Append(block.NewSHA512(1, []byte("orphan"), genesis.BlockHash)); !errors.Is(err, ErrInvalidBlock) {
+		t.Error("expected ErrInvalidBlock appending to an empty chain. got", err)
+	}
+	if err := backend.Append(genesis); err != nil {
+		t.Fatal("expected successful append of genesis.", err)
+	}
+
+	head := genesis
+	var b3 *block.Block
+	for i := 1; i < 5; i++ {
+		head = block.NewSHA512(i, []byte("data"), head.BlockHash)
+		if err := backend.Append(head); err != nil {
+			t.Fatal("expected successful append of block", i, err)
+		}
+		if i == 3 {
+			b3 = head
+		}
+	}
+
+	if err := backend.Append(block.NewSHA512(5, []byte("data"), genesis.BlockHash)); !errors.Is(err, ErrConflict) {
+		t.Error("expected ErrConflict for a block not linked to the head. got", err)
+	}
+
+	if err := backend.Append(block.NewSHA512(4, []byte("stale"), b3.BlockHash)); !errors.Is(err, ErrConflict) {
+		t.Error("expected ErrConflict for a block built on a stale head. got", err)
+	}
+
+	if length, err := backend.Length(); err != nil || length != 5 {
+		t.Error("expected length 5. got", length, err)
+	}
+
+	b, err := backend.GetBlock(4)
+	if err != nil {
+		t.Fatal("expected block 4.", err)
+	}
+	if !block.Equal(b, head) {
+		t.Error("expected block 4 to equal the head")
+	}
+
+	if _, err := backend.GetBlock(5); !errors.Is(err, ErrBlockNotFound) {
+		t.Error("expected ErrBlockNotFound for block 5. got", err)
+	}
+
+	blocks, err := backend.GetRange(2, 10)
+	if err != nil {
+		t.Fatal("expected range 2 through 10.", err)
+	}
+	if len(blocks) != 3 || blocks[0].Index != 2 || blocks[2].Index != 4 {
+		t.Error("expected blocks 2 through 4. got", len(blocks), "blocks")
+	}
+}
+
+func TestMemory(t *testing.T) {
+	testBackend(t, NewMemory())
+}
+
+func TestFilesystem(t *testing.T) {
+	dir, err := ioutil.TempDir("", "golinksd-chainbackend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fs, err := NewFilesystem(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testBackend(t, fs)
+
+	reopened, err := NewFilesystem(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err := reopened.Head()
+	if err != nil || head.Index != 4 {
+		t.Error("expected reopened head 4. got", head, err)
+	}
+
+	if err := reopened.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reopened.Head(); !errors.Is(err, ErrEmptyChain) {
+		t.Error("expected ErrEmptyChain after clear. got", err)
+	}
+}
+
+func TestFilesystemMigration(t *testing.T) {
+	dir, err := ioutil.TempDir("", "golinksd-chainbackend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	genesis := block.NewSHA512Genesis()
+	next := block.NewSHA512(1, []byte("data"), genesis.BlockHash)
+	orphan := block.NewSHA512(3, []byte("data"), next.BlockHash)
+
+	genesisBytes, _ := json.Marshal(genesis)
+	nextBytes, _ := json.Marshal(next)
+	nextGzip, _ := gzipBytes(nextBytes)
+	orphanBytes, _ := json.Marshal(orphan)
+	files := map[string][]byte{
+		"0.json":    genesisBytes,
+		"1.json.gz": nextGzip,
+		"3.json":    orphanBytes,
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fs, err := NewFilesystem(dir)
+	if err != nil {
+		t.Fatal("expected block files to be imported.", err)
+	}
+	defer fs.Close()
+
+	if length, err := fs.Length(); err != nil || length != 2 {
+		t.Fatal("expected contiguous blocks to be imported. got", length, err)
+	}
+	blocks, err := fs.GetRange(0, 1)
+	if err != nil || !block.Equal(blocks[0], genesis) || !block.Equal(blocks[1], next) {
+		t.Error("expected imported blocks to round trip.", err)
+	}
+	for name := range files {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Error("expected block file", name, "to be moved out of the chain directory")
+		}
+	}
+	quarantined, _ := filepath.Glob(filepath.Join(fs.QuarantineDir(), "*-migrate", "3.json"))
+	if len(quarantined) != 1 {
+		t.Error("expected the orphaned block file to be quarantined. got", quarantined)
+	}
+}
+
+func TestFilesystemMigrationResumes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "golinksd-chainbackend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	genesis := block.NewSHA512Genesis()
+	next := block.NewSHA512(1, []byte("data"), genesis.BlockHash)
+
+	// a migration interrupted after importing the genesis block leaves it in
+	// the log and every block file in place
+	fs, err := NewFilesystem(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Append(genesis); err != nil {
+		t.Fatal(err)
+	}
+	fs.Close()
+
+	genesisBytes, _ := json.Marshal(genesis)
+	nextBytes, _ := json.Marshal(next)
+	for name, content := range map[string][]byte{"0.json": genesisBytes, "1.json": nextBytes} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resumed, err := NewFilesystem(dir)
+	if err != nil {
+		t.Fatal("expected the import to resume.", err)
+	}
+	defer resumed.Close()
+
+	if length, err := resumed.Length(); err != nil || length != 2 {
+		t.Fatal("expected the remaining block to be imported. got", length, err)
+	}
+	if head, err := resumed.Head(); err != nil || !block.Equal(head, next) {
+		t.Error("expected the imported head to round trip.", err)
+	}
+	for _, name := range []string{"0.json", "1.json"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Error("expected imported block file", name, "to be removed")
+		}
+	}
+	if _, err := os.Stat(resumed.QuarantineDir()); !os.IsNotExist(err) {
+		t.Error("expected no block files to be quarantined")
+	}
+}
+
+func TestFilesystemMixedCompression(t *testing.T) {
+	dir, err := ioutil.TempDir("", "golinksd-chainbackend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fs, err := NewFilesystem(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	genesis := block.NewSHA512Genesis()
+	fs.SetCompression(false)
+	if err := fs.Append(genesis); err != nil {
+		t.Fatal(err)
+	}
+	fs.SetCompression(true)
+	next := block.NewSHA512(1, []byte("data"), genesis.BlockHash)
+	if err := fs.Append(next); err != nil {
+		t.Fatal(err)
+	}
+	fs.Close()
+
+	reopened, err := NewFilesystem(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+
+	seg := reopened.segments[0]
+	for i, wantCompressed := range []bool{false, true} {
+		if _, compressed, err := seg.read(seg.offsets[i]); err != nil || compressed != wantCompressed {
+			t.Error("expected record", i, "compressed", wantCompressed, "got", compressed, err)
+		}
+	}
+
+	blocks, err := reopened.GetRange(0, 1)
+	if err != nil || len(blocks) != 2 {
+		t.Fatal("expected both blocks to be readable.", err)
+	}
+	if !block.Equal(blocks[0], genesis) || !block.Equal(blocks[1], next) {
+		t.Error("expected blocks to round trip through the segment")
+	}
+}
+
+func TestFilesystemRecovery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "golinksd-chainbackend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fs, err := NewFilesystem(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	head := block.NewSHA512Genesis()
+	if err := fs.Append(head); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < 3; i++ {
+		head = block.NewSHA512(i, []byte("data"), head.BlockHash)
+		if err := fs.Append(head); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fs.Close()
+
+	// simulate an append interrupted after part of its record was written
+	logName := filepath.Join(dir, "segments", segmentName(0)+segmentSuffix)
+	f, err := os.OpenFile(logName, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0, 0, 1, 0, 1, 2})
+	f.Close()
+
+	reopened, err := NewFilesystem(dir)
+	if err != nil {
+		t.Fatal("expected torn record to be recovered.", err)
+	}
+	defer reopened.Close()
+
+	recovered, err := reopened.Head()
+	if err != nil || !block.Equal(recovered, head) {
+		t.Fatal("expected head to survive recovery.", err)
+	}
+	next := block.NewSHA512(3, []byte("data"), head.BlockHash)
+	if err := reopened.Append(next); err != nil {
+		t.Fatal("expected append after recovery.", err)
+	}
+	if b, err := reopened.GetBlock(3); err != nil || !block.Equal(b, next) {
+		t.Error("expected appended block after recovery.", err)
+	}
+}
+
+func TestFilesystemPrune(t *testing.T) {
+	dir, err := ioutil.TempDir("", "golinksd-chainbackend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fs, err := NewFilesystem(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	head := block.NewSHA512Genesis()
+	if err := fs.Append(head); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < 10; i++ {
+		data := []byte("data")
+		if i == 2 {
+			data = []byte("own")
+		}
+		head = block.NewSHA512(i, data, head.BlockHash)
+		if err := fs.Append(head); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	key := []byte("key")
+	keep := func(blk *block.Block) bool {
+		return string(blk.Data) == "own"
+	}
+	pruned, err := fs.Prune(6, keep, key)
+	if err != nil || pruned != 5 {
+		t.Fatal("expected 5 pruned blocks.", pruned, err)
+	}
+
+	check := func(fs *Filesystem) {
+		t.Helper()
+		if length, err := fs.Length(); err != nil || length != 10 {
+			t.Error("expected length 10. got", length, err)
+		}
+		if _, err := fs.GetBlock(3); !errors.Is(err, ErrBlockPruned) {
+			t.Error("expected block 3 to be pruned. got", err)
+		}
+		if blk, err := fs.GetBlock(2); err != nil || string(blk.Data) != "own" {
+			t.Error("expected block 2 to be retained.", err)
+		}
+		if blk, err := fs.GetBlock(7); err != nil || blk.Index != 7 {
+			t.Error("expected block 7 to be kept.", err)
+		}
+		cp := fs.Checkpoint()
+		if cp == nil || cp.Base != 6 || !cp.Verify(key) || cp.Verify([]byte("other")) {
+			t.Errorf("expected checkpoint at base 6 signed with key. got %+v", cp)
+		}
+	}
+	check(fs)
+	fs.Close()
+
+	// a copy of the base segment left by a prune that was not committed
+	stale, err := createSegment(filepath.Join(dir, "segments"), 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.close()
+
+	fs, err = NewFilesystem(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(fs)
+	head = block.NewSHA512(10, []byte("data"), head.BlockHash)
+	if err := fs.Append(head); err != nil {
+		t.Error("expected append to pruned chain.", err)
+	}
+	fs.Close()
+}
+
+func TestFilesystemReplace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "golinksd-chainbackend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	chain := func(n int, data string) []*block.Block {
+		blocks := []*block.Block{block.NewSHA512Genesis()}
+		for i := 1; i < n; i++ {
+			blocks = append(blocks, block.NewSHA512(i, []byte(data), blocks[i-1].BlockHash))
+		}
+		return blocks
+	}
+	old := chain(5, "old")
+	replacement := chain(3, "new")
+
+	fs, err := NewFilesystem(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, blk := range old {
+		if err := fs.Append(blk); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := fs.Replace(chain(3, "new")[1:]); !errors.Is(err, ErrInvalidBlock) {
+		t.Error("expected ErrInvalidBlock for a chain without genesis. got", err)
+	}
+	if head, err := fs.Head(); err != nil || !block.Equal(head, old[4]) {
+		t.Error("expected a failed replacement to keep the chain.", err)
+	}
+
+	if err := fs.Replace(replacement); err != nil {
+		t.Fatal("expected successful replacement.", err)
+	}
+	check := func(fs *Filesystem, want []*block.Block) {
+		t.Helper()
+		if length, err := fs.Length(); err != nil || length != len(want) {
+			t.Fatal("expected length", len(want), "got", length, err)
+		}
+		blocks, err := fs.GetRange(0, len(want)-1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range want {
+			if !block.Equal(blocks[i], want[i]) {
+				t.Error("expected block", i, "of the replacement")
+			}
+		}
+	}
+	check(fs, replacement)
+	if err := fs.Append(block.NewSHA512(3, []byte("new"), replacement[2].BlockHash)); err != nil {
+		t.Error("expected append to the replacement.", err)
+	}
+	fs.Close()
+
+	// a replacement interrupted after its segments were complete is completed
+	// when the chain is opened, and an incomplete one is discarded
+	written := &Filesystem{dir: filepath.Join(dir, "written")}
+	if err := written.writeAll(old); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(written.segmentsDir(), filepath.Join(dir, newSegmentsDirName)); err != nil {
+		t.Fatal(err)
+	}
+	os.RemoveAll(written.dir)
+	incomplete := &Filesystem{dir: filepath.Join(dir, replaceDirName)}
+	if err := incomplete.writeAll(replacement[:1]); err != nil {
+		t.Fatal(err)
+	}
+
+	fs, err = NewFilesystem(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	check(fs, old)
+	for _, name := range []string{replaceDirName, newSegmentsDirName, oldSegmentsDirName} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Error("expected", name, "to be removed")
+		}
+	}
+}
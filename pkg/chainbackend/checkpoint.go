@@ -0,0 +1,74 @@
+package chainbackend
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Checkpoint records the head of the prefix pruned from a local chain, so
+// that the first stored block can still be verified against its parent.
+type Checkpoint struct {
+	// Base is the index of the first block that was not pruned.
+	Base          int       `json:"base"`
+	HeadIndex     int       `json:"head_index"`
+	HeadHash      []byte    `json:"head_hash"`
+	HeadTimestamp int64     `json:"head_timestamp"`
+	CreatedAt     time.Time `json:"created_at"`
+	// Signature is the HMAC-SHA256 of the checkpoint without its signature.
+	Signature []byte `json:"signature"`
+}
+
+// ErrBlockPruned indicates a block removed from the local chain by pruning.
+var ErrBlockPruned = errors.New("chainbackend: block was pruned from the local chain")
+
+const checkpointName = "checkpoint.json"
+
+func (cp *Checkpoint) mac(key []byte) ([]byte, error) {
+	unsigned := *cp
+	unsigned.Signature = nil
+	data, err := json.Marshal(&unsigned)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil), nil
+}
+
+// Sign sets the signature of cp with key.
+func (cp *Checkpoint) Sign(key []byte) error {
+	signature, err := cp.mac(key)
+	if err != nil {
+		return err
+	}
+	cp.Signature = signature
+	return nil
+}
+
+// Verify reports whether cp was signed with key.
+func (cp *Checkpoint) Verify(key []byte) bool {
+	signature, err := cp.mac(key)
+	return err == nil && hmac.Equal(signature, cp.Signature)
+}
+
+// readCheckpoint reads the checkpoint of the chain in dir, or nil if the chain
+// was never pruned.
+func readCheckpoint(dir string) (*Checkpoint, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, checkpointName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	cp := &Checkpoint{}
+	if err := json.Unmarshal(data, cp); err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
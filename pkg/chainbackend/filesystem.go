@@ -0,0 +1,566 @@
+package chainbackend
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/govice/golinks/block"
+	"github.com/govice/golinksd/pkg/log"
+)
+
+// Filesystem is a ChainBackend storing its blocks in an append-only log of
+// segments under <dir>/segments. Every segment has an index of the offsets of
+// its records, so the head and any block are read without scanning. Appends
+// are fsync'd before they are acknowledged.
+//
+// A pruned chain keeps the blocks from its base on in the log, the blocks
+// before it that were retained under <dir>/retained, and the head of its
+// pruned prefix in <dir>/checkpoint.json.
+//
+// Block files named <index>.json or <index>.json.gz, written by earlier
+// versions, are imported into the log when the directory is opened.
+type Filesystem struct {
+	dir        string
+	mu         sync.RWMutex
+	segments   []*segment
+	length     int
+	head       *block.Block
+	err        error
+	uncompress bool
+	// base is the index of the first block that was not pruned, and retained
+	// the blocks before it that were kept
+	base       int
+	checkpoint *Checkpoint
+	retained   map[int]bool
+}
+
+// ErrChainGap indicates the stored segments do not hold a contiguous chain
+// from index 0.
+var ErrChainGap = errors.New("chainbackend: gap in local chain")
+
+func NewFilesystem(dir string) (*Filesystem, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	fs := &Filesystem{dir: dir, retained: make(map[int]bool)}
+	if err := fs.open(); err != nil {
+		return nil, err
+	}
+	if err := fs.migrate(); err != nil {
+		log.Errln("failed to import block files of", dir)
+		return nil, err
+	}
+	return fs, nil
+}
+
+// SetCompression selects whether appended blocks are gzip compressed.
+func (fs *Filesystem) SetCompression(enabled bool) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.uncompress = !enabled
+}
+
+// Dir returns the directory the chain is stored in.
+func (fs *Filesystem) Dir() string {
+	return fs.dir
+}
+
+func (fs *Filesystem) segmentsDir() string {
+	return filepath.Join(fs.dir, "segments")
+}
+
+// open loads the segments of the directory, recovering from a write, prune or
+// replacement that was interrupted before it was acknowledged.
+func (fs *Filesystem) open() error {
+	if err := fs.commitReplace(); err != nil {
+		log.Errln("failed to complete replacement of", fs.dir, err)
+		return err
+	}
+
+	cp, err := readCheckpoint(fs.dir)
+	if err != nil {
+		log.Errln("failed to read checkpoint of", fs.dir, err)
+		fs.err = ErrCorruptRecord
+		return nil
+	}
+	if cp != nil {
+		fs.checkpoint = cp
+		fs.base = cp.Base
+	}
+	if err := fs.loadRetained(); err != nil {
+		return err
+	}
+
+	files, err := ioutil.ReadDir(fs.segmentsDir())
+	if os.IsNotExist(err) {
+		if fs.base > 0 {
+			fs.err = ErrChainGap
+		}
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var firsts []int
+	for _, fi := range files {
+		name := fi.Name()
+		if !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+		first, err := strconv.Atoi(strings.TrimSuffix(name, segmentSuffix))
+		if err != nil || first < 0 {
+			continue
+		}
+		firsts = append(firsts, first)
+	}
+	sort.Ints(firsts)
+
+	var opened []*segment
+	startsAtBase := false
+	for _, first := range firsts {
+		seg, err := openSegment(fs.segmentsDir(), first)
+		if err != nil {
+			for _, seg := range opened {
+				seg.close()
+			}
+			return err
+		}
+		opened = append(opened, seg)
+		startsAtBase = startsAtBase || first == fs.base
+	}
+
+	for _, seg := range opened {
+		// segments before the base of a committed prune, and copies made by
+		// a prune that was not committed, are left by an interrupted prune
+		pruned := fs.base > 0 && (seg.end() <= fs.base || (seg.first < fs.base && startsAtBase))
+		overlaps := len(fs.segments) > 0 && seg.first < fs.activeSegment().end()
+		if pruned || overlaps {
+			log.Warnln("removing segment", seg.first, "of", fs.dir, "left by an interrupted prune")
+			if err := seg.remove(); err != nil {
+				fs.closeSegments()
+				return err
+			}
+			continue
+		}
+		fs.segments = append(fs.segments, seg)
+	}
+
+	// the chain starts at index 0, or at or before the base of a pruned chain
+	length := 0
+	if fs.base > 0 && len(fs.segments) > 0 && fs.segments[0].first <= fs.base {
+		length = fs.segments[0].first
+	}
+	for _, seg := range fs.segments {
+		if seg.first != length {
+			log.Errln("segment", seg.first, "of", fs.dir, "does not start at expected index", length)
+			fs.err = ErrChainGap
+			return nil
+		}
+		length += len(seg.offsets)
+	}
+	if fs.base > 0 && length <= fs.base {
+		log.Errln("segments of", fs.dir, "end before the base of its checkpoint", fs.base)
+		fs.err = ErrChainGap
+		return nil
+	}
+	fs.length = length
+
+	if length > 0 {
+		head, err := fs.getBlockLocked(length - 1)
+		if err != nil {
+			log.Errln("failed to read head of", fs.dir, err)
+			fs.err = err
+			return nil
+		}
+		fs.head = head
+	}
+	return nil
+}
+
+func (fs *Filesystem) Length() (int, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	if fs.err != nil {
+		return -1, fs.err
+	}
+	return fs.length, nil
+}
+
+func (fs *Filesystem) GetBlock(index int) (*block.Block, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	if fs.err != nil {
+		return nil, fs.err
+	}
+	return fs.getBlockLocked(index)
+}
+
+func (fs *Filesystem) getBlockLocked(index int) (*block.Block, error) {
+	if index < 0 || index >= fs.length {
+		return nil, ErrBlockNotFound
+	}
+	if index < fs.base {
+		return fs.retainedBlock(index)
+	}
+
+	// the segment holding index is the last one starting at or before it
+	i := sort.Search(len(fs.segments), func(i int) bool {
+		return fs.segments[i].first > index
+	}) - 1
+	seg := fs.segments[i]
+
+	payload, compressed, err := seg.read(seg.offsets[index-seg.first])
+	if err != nil {
+		log.Errln("failed to read block", index, "from segment", seg.first, err)
+		return nil, err
+	}
+	if compressed {
+		if payload, err = gunzip(payload); err != nil {
+			return nil, err
+		}
+	}
+
+	b := &block.Block{}
+	if err := json.Unmarshal(payload, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (fs *Filesystem) GetRange(start, end int) ([]*block.Block, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	if fs.err != nil {
+		return nil, fs.err
+	}
+	if start < 0 || start > end || start >= fs.length {
+		return nil, ErrBlockNotFound
+	}
+	if start < fs.base {
+		return nil, ErrBlockPruned
+	}
+	if end >= fs.length {
+		end = fs.length - 1
+	}
+
+	var blocks []*block.Block
+	for index := start; index <= end; index++ {
+		b, err := fs.getBlockLocked(index)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, b)
+	}
+	return blocks, nil
+}
+
+// Head returns the last block of the chain.
+func (fs *Filesystem) Head() (*block.Block, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	if fs.err != nil {
+		return nil, fs.err
+	}
+	if fs.head == nil {
+		return nil, ErrEmptyChain
+	}
+	head := *fs.head
+	return &head, nil
+}
+
+func (fs *Filesystem) Append(blk *block.Block) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.err != nil {
+		return fs.err
+	}
+	if err := validateAppend(fs.head, blk); err != nil {
+		return err
+	}
+	return fs.appendLocked(blk)
+}
+
+func (fs *Filesystem) appendLocked(blk *block.Block) error {
+	payload, err := json.Marshal(blk)
+	if err != nil {
+		log.Errln("failed to marshal block", blk.Index)
+		return err
+	}
+	if !fs.uncompress {
+		if payload, err = gzipBytes(payload); err != nil {
+			return err
+		}
+	}
+
+	seg := fs.activeSegment()
+	if seg == nil || seg.size+recordHeaderSize+int64(len(payload)) > segmentMaxBytes {
+		if err := os.MkdirAll(fs.segmentsDir(), os.ModePerm); err != nil {
+			return err
+		}
+		seg, err = createSegment(fs.segmentsDir(), fs.length)
+		if err != nil {
+			log.Errln("failed to create segment", fs.length, "of", fs.dir)
+			return err
+		}
+		fs.segments = append(fs.segments, seg)
+	}
+
+	if err := seg.append(payload, !fs.uncompress); err != nil {
+		log.Errln("failed to append block", blk.Index, "to segment", seg.first, err)
+		return err
+	}
+
+	head := *blk
+	fs.head = &head
+	fs.length++
+	return nil
+}
+
+func (fs *Filesystem) activeSegment() *segment {
+	if len(fs.segments) == 0 {
+		return nil
+	}
+	return fs.segments[len(fs.segments)-1]
+}
+
+// QuarantineDir returns the directory blocks removed from the chain are kept
+// in. It is left in place when the chain is cleared.
+func (fs *Filesystem) QuarantineDir() string {
+	return filepath.Join(fs.dir, quarantineDirName)
+}
+
+const quarantineDirName = "quarantine"
+
+// Truncate removes the blocks at and after index length from the chain.
+func (fs *Filesystem) Truncate(length int) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.err != nil {
+		return fs.err
+	}
+	if length < 0 {
+		return ErrBlockNotFound
+	}
+	// the head of a pruned chain is never removed
+	if fs.base > 0 && length <= fs.base {
+		return ErrBlockPruned
+	}
+	if length >= fs.length {
+		return nil
+	}
+
+	for len(fs.segments) > 0 {
+		seg := fs.activeSegment()
+		if seg.first < length {
+			break
+		}
+		if err := seg.remove(); err != nil {
+			log.Errln("failed to remove segment", seg.first, "of", fs.dir)
+			fs.err = err
+			return err
+		}
+		fs.segments = fs.segments[:len(fs.segments)-1]
+	}
+	if seg := fs.activeSegment(); seg != nil {
+		if err := seg.truncate(length - seg.first); err != nil {
+			log.Errln("failed to truncate segment", seg.first, "of", fs.dir)
+			fs.err = err
+			return err
+		}
+	}
+
+	fs.length = length
+	fs.head = nil
+	if length > 0 {
+		head, err := fs.getBlockLocked(length - 1)
+		if err != nil {
+			fs.err = err
+			return err
+		}
+		fs.head = head
+	}
+	return nil
+}
+
+// Clear removes every block from the chain, along with its checkpoint and
+// retained blocks.
+func (fs *Filesystem) Clear() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.closeSegments()
+	fs.segments = nil
+	fs.length = 0
+	fs.head = nil
+	fs.err = nil
+	fs.base = 0
+	fs.checkpoint = nil
+	fs.retained = make(map[int]bool)
+
+	fis, err := ioutil.ReadDir(fs.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range fis {
+		if f.Name() == quarantineDirName {
+			continue
+		}
+		fn := path.Join(fs.dir, f.Name())
+		if err := os.RemoveAll(fn); err != nil {
+			log.Errln("failed to remove file:", fn)
+			return err
+		}
+	}
+
+	return nil
+}
+
+const (
+	// replaceDirName holds the segments written by Replace until they are
+	// complete, newSegmentsDirName the complete segments until they are
+	// renamed into place, and oldSegmentsDirName the replaced segments until
+	// they are removed
+	replaceDirName     = "replace.tmp"
+	newSegmentsDirName = "segments.new"
+	oldSegmentsDirName = "segments.old"
+)
+
+// Replace replaces every block of the chain with blocks. The blocks are
+// written to a temporary directory that is renamed into place, so a crash
+// leaves either the old or the new chain. A pruned chain is not replaced.
+func (fs *Filesystem) Replace(blocks []*block.Block) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.base > 0 {
+		return ErrBlockPruned
+	}
+
+	tmp := &Filesystem{dir: filepath.Join(fs.dir, replaceDirName), uncompress: fs.uncompress}
+	if err := os.RemoveAll(tmp.dir); err != nil {
+		return err
+	}
+	if err := tmp.writeAll(blocks); err != nil {
+		log.Errln("failed to write replacement chain of", fs.dir)
+		os.RemoveAll(tmp.dir)
+		return err
+	}
+	if err := os.Rename(tmp.segmentsDir(), filepath.Join(fs.dir, newSegmentsDirName)); err != nil {
+		os.RemoveAll(tmp.dir)
+		return err
+	}
+	syncDir(fs.dir)
+
+	fs.closeSegments()
+	fs.segments = nil
+	fs.length = 0
+	fs.head = nil
+	fs.err = nil
+	if err := fs.open(); err != nil {
+		fs.err = err
+		return err
+	}
+	return fs.err
+}
+
+// writeAll appends blocks to an empty chain and closes its segments.
+func (fs *Filesystem) writeAll(blocks []*block.Block) error {
+	if err := os.MkdirAll(fs.segmentsDir(), os.ModePerm); err != nil {
+		return err
+	}
+	for _, blk := range blocks {
+		if err := validateAppend(fs.head, blk); err != nil {
+			fs.closeSegments()
+			return err
+		}
+		if err := fs.appendLocked(blk); err != nil {
+			fs.closeSegments()
+			return err
+		}
+	}
+	return fs.closeSegments()
+}
+
+// commitReplace moves the segments of a Replace into place. A Replace that
+// was interrupted before its segments were complete is discarded, and one
+// that was interrupted after is completed.
+func (fs *Filesystem) commitReplace() error {
+	if err := os.RemoveAll(filepath.Join(fs.dir, replaceDirName)); err != nil {
+		return err
+	}
+	newDir := filepath.Join(fs.dir, newSegmentsDirName)
+	if _, err := os.Stat(newDir); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	oldDir := filepath.Join(fs.dir, oldSegmentsDirName)
+	if err := os.RemoveAll(oldDir); err != nil {
+		return err
+	}
+	if err := os.Rename(fs.segmentsDir(), oldDir); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Rename(newDir, fs.segmentsDir()); err != nil {
+		return err
+	}
+	syncDir(fs.dir)
+	return os.RemoveAll(oldDir)
+}
+
+// syncDir makes the directory entries of dir durable.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
+
+// Close releases the segment files. The Filesystem must not be used after.
+func (fs *Filesystem) Close() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.closeSegments()
+}
+
+func (fs *Filesystem) closeSegments() error {
+	var closeErr error
+	for _, seg := range fs.segments {
+		if err := seg.close(); err != nil && closeErr == nil {
+			closeErr = err
+		}
+	}
+	return closeErr
+}
+
+func gzipBytes(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func gunzip(data []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return ioutil.ReadAll(zr)
+}
@@ -0,0 +1,61 @@
+package chainbackend
+
+import (
+	"sync"
+
+	"github.com/govice/golinks/block"
+)
+
+// Memory is a ChainBackend holding its blocks in memory.
+type Memory struct {
+	mu     sync.RWMutex
+	blocks []*block.Block
+}
+
+// NewMemory returns a Memory backend initialized with blocks.
+func NewMemory(blocks ...*block.Block) *Memory {
+	return &Memory{
+		blocks: append([]*block.Block{}, blocks...),
+	}
+}
+
+func (m *Memory) Length() (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.blocks), nil
+}
+
+func (m *Memory) GetBlock(index int) (*block.Block, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if index < 0 || index >= len(m.blocks) {
+		return nil, ErrBlockNotFound
+	}
+	return m.blocks[index], nil
+}
+
+func (m *Memory) GetRange(start, end int) ([]*block.Block, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if start < 0 || start > end || start >= len(m.blocks) {
+		return nil, ErrBlockNotFound
+	}
+	if end >= len(m.blocks) {
+		end = len(m.blocks) - 1
+	}
+	return append([]*block.Block{}, m.blocks[start:end+1]...), nil
+}
+
+func (m *Memory) Append(blk *block.Block) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var head *block.Block
+	if len(m.blocks) > 0 {
+		head = m.blocks[len(m.blocks)-1]
+	}
+	if err := validateAppend(head, blk); err != nil {
+		return err
+	}
+	m.blocks = append(m.blocks, blk)
+	return nil
+}
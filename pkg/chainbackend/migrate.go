@@ -0,0 +1,196 @@
+package chainbackend
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/govice/golinks/block"
+	"github.com/govice/golinksd/pkg/log"
+)
+
+// migrate imports the <index>.json and <index>.json.gz block files of earlier
+// versions into the log and removes them. Import resumes at the length of the
+// log, so an import interrupted by a crash is completed on the next open, and
+// stops at the first missing or invalid block. Block files that were not
+// imported are moved to the quarantine directory.
+func (fs *Filesystem) migrate() error {
+	files, err := fs.readLegacyDir()
+	if err != nil || len(files) == 0 {
+		return err
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.err != nil {
+		log.Warnln("not importing block files of", fs.dir, "into an unreadable chain")
+		return nil
+	}
+
+	resumed := fs.length
+	var imported, skipped []string
+	for i, file := range files {
+		name := filepath.Join(fs.dir, file.Name())
+		index, _ := blockIndex(file.Name())
+		if index < fs.length {
+			// imported before an interrupted migration, or a compressed and
+			// uncompressed copy of the same block
+			if fs.legacyBlockStored(name, index) {
+				imported = append(imported, file.Name())
+			} else {
+				skipped = append(skipped, file.Name())
+			}
+			continue
+		}
+		if index != fs.length {
+			log.Errln("block file", file.Name(), "does not have expected index", fs.length)
+			skipped = append(skipped, legacyNames(files[i:])...)
+			break
+		}
+
+		b, err := readLegacyBlock(name)
+		if err != nil {
+			log.Errln("failed to read block file", file.Name(), err)
+			skipped = append(skipped, legacyNames(files[i:])...)
+			break
+		}
+		if err := validateAppend(fs.head, b); err != nil {
+			log.Errln("block file", file.Name(), "does not extend the chain")
+			skipped = append(skipped, legacyNames(files[i:])...)
+			break
+		}
+		if err := fs.appendLocked(b); err != nil {
+			return err
+		}
+		imported = append(imported, file.Name())
+	}
+
+	for _, name := range imported {
+		if err := os.Remove(filepath.Join(fs.dir, name)); err != nil {
+			return err
+		}
+	}
+	if len(skipped) > 0 {
+		if err := fs.quarantineLegacy(skipped); err != nil {
+			log.Errln("failed to quarantine block files of", fs.dir)
+			return err
+		}
+	}
+
+	log.Logln("imported", fs.length-resumed, "block files of", fs.dir, "into the chain log,", len(skipped), "quarantined")
+	return nil
+}
+
+// legacyBlockStored reports whether the block file name holds the block
+// stored at index.
+func (fs *Filesystem) legacyBlockStored(name string, index int) bool {
+	b, err := readLegacyBlock(name)
+	if err != nil {
+		return false
+	}
+	stored, err := fs.getBlockLocked(index)
+	return err == nil && block.Equal(b, stored)
+}
+
+// quarantineLegacy moves block files that were not imported to a new
+// directory under the quarantine directory.
+func (fs *Filesystem) quarantineLegacy(names []string) error {
+	dir := filepath.Join(fs.QuarantineDir(), time.Now().UTC().Format("20060102T150405.000000000Z")+"-migrate")
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := os.Rename(filepath.Join(fs.dir, name), filepath.Join(dir, name)); err != nil {
+			return err
+		}
+	}
+	log.Warnln("moved", len(names), "block files of", fs.dir, "that were not imported to", dir)
+	return nil
+}
+
+func legacyNames(files []os.FileInfo) []string {
+	names := make([]string, 0, len(files))
+	for _, fi := range files {
+		names = append(names, fi.Name())
+	}
+	return names
+}
+
+func (fs *Filesystem) readLegacyDir() ([]os.FileInfo, error) {
+	files, err := ioutil.ReadDir(fs.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var filesOut []os.FileInfo
+	// omit any OS generated hidden files/folders
+	for _, fi := range files {
+		if _, ok := blockIndex(fi.Name()); ok && !fi.IsDir() {
+			filesOut = append(filesOut, fi)
+		}
+	}
+
+	sort.Sort(NumericalFileInfos(filesOut))
+
+	return filesOut, nil
+}
+
+func readLegacyBlock(name string) (*block.Block, error) {
+	blockBytes, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasSuffix(name, ".gz") {
+		if blockBytes, err = gunzip(blockBytes); err != nil {
+			return nil, err
+		}
+	}
+
+	b := &block.Block{}
+	if err := json.Unmarshal(blockBytes, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+type NumericalFileInfos []os.FileInfo
+
+func (nfi NumericalFileInfos) Len() int {
+	return len(nfi)
+}
+
+func (nfi NumericalFileInfos) Swap(i, j int) {
+	nfi[i], nfi[j] = nfi[j], nfi[i]
+}
+
+func (nfi NumericalFileInfos) Less(i, j int) bool {
+	pathA := nfi[i].Name()
+	pathB := nfi[j].Name()
+
+	a, okA := blockIndex(pathA)
+	b, okB := blockIndex(pathB)
+	if !okA || !okB || a == b {
+		return pathA < pathB
+	}
+
+	return a < b
+}
+
+// blockIndex parses the index of a block file named <index>.json or
+// <index>.json.gz.
+func blockIndex(name string) (int, bool) {
+	name = strings.TrimSuffix(name, ".gz")
+	if !strings.HasSuffix(name, ".json") {
+		return -1, false
+	}
+	index, err := strconv.Atoi(strings.TrimSuffix(name, ".json"))
+	if err != nil || index < 0 {
+		return -1, false
+	}
+	return index, true
+}
@@ -0,0 +1,180 @@
+package chainbackend
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/govice/golinks/block"
+	"github.com/govice/golinksd/pkg/atomicfile"
+	"github.com/govice/golinksd/pkg/log"
+)
+
+const retainedDirName = "retained"
+
+func (fs *Filesystem) retainedDir() string {
+	return filepath.Join(fs.dir, retainedDirName)
+}
+
+// Base returns the index of the first block that was not pruned.
+func (fs *Filesystem) Base() int {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	return fs.base
+}
+
+// Checkpoint returns the checkpoint of the pruned prefix of the chain, or nil
+// if the chain was never pruned.
+func (fs *Filesystem) Checkpoint() *Checkpoint {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	if fs.checkpoint == nil {
+		return nil
+	}
+	cp := *fs.checkpoint
+	return &cp
+}
+
+// Prune removes the blocks before index base from the chain and records the
+// head of the removed prefix in a checkpoint signed with key. Blocks for which
+// keep returns true are retained and can still be read. The head of the chain
+// is never pruned. Prune returns the number of blocks removed.
+//
+// The segment holding base is copied to a segment starting at base before the
+// checkpoint is written, and the segments before base are removed after, so a
+// prune interrupted at any point is completed or undone when the chain is
+// next opened.
+func (fs *Filesystem) Prune(base int, keep func(*block.Block) bool, key []byte) (int, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.err != nil {
+		return 0, fs.err
+	}
+	if base > fs.length-1 {
+		base = fs.length - 1
+	}
+	if base <= fs.base {
+		return 0, nil
+	}
+
+	kept := 0
+	for index := fs.base; index < base; index++ {
+		blk, err := fs.getBlockLocked(index)
+		if err != nil {
+			return 0, err
+		}
+		if keep != nil && keep(blk) {
+			if err := fs.retain(blk); err != nil {
+				log.Errln("failed to retain block", index, "of", fs.dir)
+				return 0, err
+			}
+			kept++
+		}
+	}
+
+	head, err := fs.getBlockLocked(base - 1)
+	if err != nil {
+		return 0, err
+	}
+	cp := &Checkpoint{
+		Base:          base,
+		HeadIndex:     head.Index,
+		HeadHash:      head.BlockHash,
+		HeadTimestamp: head.Timestamp,
+		CreatedAt:     time.Now(),
+	}
+	if err := cp.Sign(key); err != nil {
+		return 0, err
+	}
+
+	var baseSegment *segment
+	for _, seg := range fs.segments {
+		if seg.first < base && seg.end() > base {
+			baseSegment, err = createSegment(fs.segmentsDir(), base)
+			if err != nil {
+				return 0, err
+			}
+			if err := baseSegment.copyRecords(seg, base-seg.first); err != nil {
+				log.Errln("failed to copy segment", seg.first, "of", fs.dir, "to", base)
+				baseSegment.remove()
+				return 0, err
+			}
+		}
+	}
+
+	// the checkpoint commits the prune
+	cpBytes, err := json.MarshalIndent(cp, "", "  ")
+	if err == nil {
+		err = atomicfile.WriteFile(filepath.Join(fs.dir, checkpointName), cpBytes, 0644)
+	}
+	if err != nil {
+		log.Errln("failed to write checkpoint of", fs.dir)
+		if baseSegment != nil {
+			baseSegment.remove()
+		}
+		return 0, err
+	}
+
+	var segments []*segment
+	if baseSegment != nil {
+		segments = append(segments, baseSegment)
+	}
+	for _, seg := range fs.segments {
+		if seg.first >= base {
+			segments = append(segments, seg)
+		} else if err := seg.remove(); err != nil {
+			log.Warnln("failed to remove pruned segment", seg.first, "of", fs.dir, err)
+		}
+	}
+
+	pruned := base - fs.base - kept
+	fs.segments = segments
+	fs.base = base
+	fs.checkpoint = cp
+	return pruned, nil
+}
+
+// retain copies blk out of the log so that it is kept when it is pruned.
+func (fs *Filesystem) retain(blk *block.Block) error {
+	if err := os.MkdirAll(fs.retainedDir(), os.ModePerm); err != nil {
+		return err
+	}
+	blockBytes, err := json.Marshal(blk)
+	if err != nil {
+		return err
+	}
+	name := filepath.Join(fs.retainedDir(), strconv.Itoa(blk.Index)+".json")
+	if err := atomicfile.WriteFile(name, blockBytes, 0644); err != nil {
+		return err
+	}
+	fs.retained[blk.Index] = true
+	return nil
+}
+
+// loadRetained lists the blocks retained from pruned prefixes of the chain.
+func (fs *Filesystem) loadRetained() error {
+	fs.retained = make(map[int]bool)
+	files, err := ioutil.ReadDir(fs.retainedDir())
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, fi := range files {
+		if index, ok := blockIndex(fi.Name()); ok {
+			fs.retained[index] = true
+		}
+	}
+	return nil
+}
+
+// retainedBlock reads a block before the base of the chain.
+func (fs *Filesystem) retainedBlock(index int) (*block.Block, error) {
+	if !fs.retained[index] {
+		return nil, ErrBlockPruned
+	}
+	return readLegacyBlock(filepath.Join(fs.retainedDir(), strconv.Itoa(index)+".json"))
+}
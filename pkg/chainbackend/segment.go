@@ -0,0 +1,285 @@
+package chainbackend
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/govice/golinksd/pkg/log"
+)
+
+const (
+	segmentSuffix = ".log"
+	indexSuffix   = ".idx"
+	// segmentMaxBytes is the size after which appends start a new segment.
+	segmentMaxBytes = 64 << 20
+	// a record is its payload length, the CRC-32 of its payload and a flags
+	// byte, followed by the payload
+	recordHeaderSize = 9
+	flagGzip         = 1
+	offsetSize       = 8
+)
+
+// ErrCorruptRecord indicates a record whose payload does not match its
+// checksum.
+var ErrCorruptRecord = errors.New("chainbackend: corrupt record in segment")
+
+// segment is a log file of records holding consecutive blocks starting at
+// first, along with an index file of the offset of every record.
+type segment struct {
+	first   int
+	log     *os.File
+	idx     *os.File
+	offsets []int64
+	size    int64
+}
+
+func segmentName(first int) string {
+	return fmt.Sprintf("%020d", first)
+}
+
+func createSegment(dir string, first int) (*segment, error) {
+	seg, err := openSegment(dir, first)
+	if err != nil {
+		return nil, err
+	}
+	// make the new segment's directory entries durable
+	syncDir(dir)
+	return seg, nil
+}
+
+// openSegment opens or creates the segment starting at first. Records
+// following the last complete record, left by an interrupted append, are
+// truncated, and records missing from the index are indexed.
+func openSegment(dir string, first int) (*segment, error) {
+	base := filepath.Join(dir, segmentName(first))
+	logFile, err := os.OpenFile(base+segmentSuffix, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	idxFile, err := os.OpenFile(base+indexSuffix, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		logFile.Close()
+		return nil, err
+	}
+
+	seg := &segment{first: first, log: logFile, idx: idxFile}
+	if err := seg.recover(); err != nil {
+		seg.close()
+		return nil, err
+	}
+	return seg, nil
+}
+
+func (seg *segment) recover() error {
+	logInfo, err := seg.log.Stat()
+	if err != nil {
+		return err
+	}
+	logSize := logInfo.Size()
+
+	idxBytes, err := ioutil.ReadAll(seg.idx)
+	if err != nil {
+		return err
+	}
+	var offsets []int64
+	for i := 0; i+offsetSize <= len(idxBytes); i += offsetSize {
+		offset := int64(binary.BigEndian.Uint64(idxBytes[i:]))
+		if offset >= logSize || (len(offsets) > 0 && offset <= offsets[len(offsets)-1]) {
+			break
+		}
+		offsets = append(offsets, offset)
+	}
+	indexed := len(offsets)
+
+	// the last indexed record is checked again along with any that follow it
+	pos := int64(0)
+	if len(offsets) > 0 {
+		pos = offsets[len(offsets)-1]
+		offsets = offsets[:len(offsets)-1]
+	}
+	for pos < logSize {
+		n, err := seg.recordSize(pos, logSize)
+		if err != nil {
+			break
+		}
+		offsets = append(offsets, pos)
+		pos += n
+	}
+
+	if pos < logSize {
+		log.Warnln("truncating incomplete record at offset", pos, "of segment", seg.first)
+		if err := seg.log.Truncate(pos); err != nil {
+			return err
+		}
+		if err := seg.log.Sync(); err != nil {
+			return err
+		}
+	}
+	seg.size = pos
+	seg.offsets = offsets
+
+	if len(offsets) != indexed || len(idxBytes) != indexed*offsetSize {
+		return seg.rewriteIndex()
+	}
+	return nil
+}
+
+// recordSize returns the size of the complete, intact record at pos.
+func (seg *segment) recordSize(pos, logSize int64) (int64, error) {
+	header := make([]byte, recordHeaderSize)
+	if pos+recordHeaderSize > logSize {
+		return 0, io.ErrUnexpectedEOF
+	}
+	if _, err := seg.log.ReadAt(header, pos); err != nil {
+		return 0, err
+	}
+	if pos+recordHeaderSize+int64(binary.BigEndian.Uint32(header[0:4])) > logSize {
+		return 0, io.ErrUnexpectedEOF
+	}
+
+	payload, _, err := seg.read(pos)
+	if err != nil {
+		return 0, err
+	}
+	return recordHeaderSize + int64(len(payload)), nil
+}
+
+func (seg *segment) rewriteIndex() error {
+	buf := make([]byte, len(seg.offsets)*offsetSize)
+	for i, offset := range seg.offsets {
+		binary.BigEndian.PutUint64(buf[i*offsetSize:], uint64(offset))
+	}
+	if err := seg.idx.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := seg.idx.WriteAt(buf, 0); err != nil {
+		return err
+	}
+	return seg.idx.Sync()
+}
+
+// read returns the payload of the record at offset.
+func (seg *segment) read(offset int64) ([]byte, bool, error) {
+	header := make([]byte, recordHeaderSize)
+	if _, err := seg.log.ReadAt(header, offset); err != nil {
+		return nil, false, err
+	}
+	length := binary.BigEndian.Uint32(header[0:4])
+	checksum := binary.BigEndian.Uint32(header[4:8])
+	flags := header[8]
+
+	payload := make([]byte, length)
+	if _, err := seg.log.ReadAt(payload, offset+recordHeaderSize); err != nil {
+		return nil, false, err
+	}
+	if crc32.ChecksumIEEE(payload) != checksum {
+		return nil, false, ErrCorruptRecord
+	}
+	return payload, flags&flagGzip != 0, nil
+}
+
+func encodeRecord(payload []byte, compressed bool) []byte {
+	record := make([]byte, recordHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(payload))
+	if compressed {
+		record[8] = flagGzip
+	}
+	copy(record[recordHeaderSize:], payload)
+	return record
+}
+
+// append writes a record and its offset, syncing both before returning.
+func (seg *segment) append(payload []byte, compressed bool) error {
+	record := encodeRecord(payload, compressed)
+
+	if _, err := seg.log.WriteAt(record, seg.size); err != nil {
+		seg.log.Truncate(seg.size)
+		return err
+	}
+	if err := seg.log.Sync(); err != nil {
+		return err
+	}
+
+	offset := make([]byte, offsetSize)
+	binary.BigEndian.PutUint64(offset, uint64(seg.size))
+	if _, err := seg.idx.WriteAt(offset, int64(len(seg.offsets)*offsetSize)); err != nil {
+		return err
+	}
+	if err := seg.idx.Sync(); err != nil {
+		return err
+	}
+
+	seg.offsets = append(seg.offsets, seg.size)
+	seg.size += int64(len(record))
+	return nil
+}
+
+// end returns the index following the last record of the segment.
+func (seg *segment) end() int {
+	return seg.first + len(seg.offsets)
+}
+
+// copyRecords appends the records of src from its n-th record on, syncing
+// once after all of them are written.
+func (seg *segment) copyRecords(src *segment, n int) error {
+	for _, offset := range src.offsets[n:] {
+		payload, compressed, err := src.read(offset)
+		if err != nil {
+			return err
+		}
+		record := encodeRecord(payload, compressed)
+		if _, err := seg.log.WriteAt(record, seg.size); err != nil {
+			return err
+		}
+		seg.offsets = append(seg.offsets, seg.size)
+		seg.size += int64(len(record))
+	}
+	if err := seg.log.Sync(); err != nil {
+		return err
+	}
+	return seg.rewriteIndex()
+}
+
+// truncate drops the records following the first n records of the segment.
+func (seg *segment) truncate(n int) error {
+	if n >= len(seg.offsets) {
+		return nil
+	}
+	size := seg.offsets[n]
+	if err := seg.log.Truncate(size); err != nil {
+		return err
+	}
+	if err := seg.log.Sync(); err != nil {
+		return err
+	}
+	seg.offsets = seg.offsets[:n]
+	seg.size = size
+	return seg.rewriteIndex()
+}
+
+// remove closes the segment and deletes its files.
+func (seg *segment) remove() error {
+	seg.close()
+	if err := os.Remove(seg.log.Name()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Remove(seg.idx.Name()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (seg *segment) close() error {
+	idxErr := seg.idx.Close()
+	if err := seg.log.Close(); err != nil {
+		return err
+	}
+	return idxErr
+}
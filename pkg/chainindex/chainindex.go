@@ -0,0 +1,380 @@
+// Package chainindex maintains a persistent index of the blocks of a local
+// chain by hash, parent hash, timestamp, and the worker and root that
+// produced them.
+package chainindex
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/govice/golinks/block"
+	"github.com/govice/golinksd/pkg/atomicfile"
+	"github.com/govice/golinksd/pkg/blockref"
+	"github.com/govice/golinksd/pkg/chainbackend"
+	"github.com/govice/golinksd/pkg/log"
+)
+
+// Entry is the indexed description of a block.
+type Entry struct {
+	Index      int    `json:"index"`
+	Timestamp  int64  `json:"timestamp"`
+	BlockHash  []byte `json:"block_hash"`
+	ParentHash []byte `json:"parent_hash"`
+	Worker     string `json:"worker,omitempty"`
+	Root       string `json:"root,omitempty"`
+}
+
+// Index is a persistent index of the blocks of a chain. Entries are appended
+// to a file of JSON lines, one per block, and loaded into memory when the
+// index is opened. Update brings the index in line with the chain.
+type Index struct {
+	path     string
+	store    chainbackend.ChainBackend
+	mu       sync.RWMutex
+	entries  []Entry
+	byHash   map[string]int
+	byParent map[string]int
+	byWorker map[string][]int
+	byRoot   map[string][]int
+}
+
+// updateBatchSize is the number of blocks read from the chain at once while
+// indexing it.
+const updateBatchSize = 500
+
+// Open loads the index stored at path for the chain held by store. Entries
+// following a line that cannot be read are dropped and indexed again by the
+// next Update.
+func Open(path string, store chainbackend.ChainBackend) (*Index, error) {
+	idx := &Index{path: path, store: store}
+	idx.reset(nil)
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return idx, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []Entry
+	complete := true
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil || entry.Index != len(entries) {
+			complete = false
+			break
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		complete = false
+	}
+
+	idx.reset(entries)
+	if !complete {
+		log.Warnln("dropping unreadable entries of chain index", path, "after block", len(entries))
+		if err := idx.rewrite(); err != nil {
+			return nil, err
+		}
+	}
+	return idx, nil
+}
+
+// reset replaces the entries of the index and rebuilds its lookup tables.
+func (idx *Index) reset(entries []Entry) {
+	idx.entries = nil
+	idx.byHash = make(map[string]int)
+	idx.byParent = make(map[string]int)
+	idx.byWorker = make(map[string][]int)
+	idx.byRoot = make(map[string][]int)
+	for _, entry := range entries {
+		idx.add(entry)
+	}
+}
+
+func (idx *Index) add(entry Entry) {
+	idx.entries = append(idx.entries, entry)
+	if len(entry.BlockHash) == 0 {
+		// a pruned block that was indexed after it was pruned
+		return
+	}
+	idx.byHash[string(entry.BlockHash)] = entry.Index
+	idx.byParent[string(entry.ParentHash)] = entry.Index
+	if entry.Worker != "" {
+		idx.byWorker[entry.Worker] = append(idx.byWorker[entry.Worker], entry.Index)
+	}
+	if entry.Root != "" {
+		idx.byRoot[entry.Root] = append(idx.byRoot[entry.Root], entry.Index)
+	}
+}
+
+// rewrite replaces the index file with the entries held in memory.
+func (idx *Index) rewrite() error {
+	var buf bytes.Buffer
+	for _, entry := range idx.entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	return atomicfile.WriteFile(idx.path, buf.Bytes(), 0644)
+}
+
+// Length returns the number of indexed blocks.
+func (idx *Index) Length() int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return len(idx.entries)
+}
+
+// Update indexes the blocks appended to the chain since the last update.
+// Entries of blocks that were removed from the chain, or replaced after a
+// fork, are dropped first.
+func (idx *Index) Update() error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	length, err := idx.store.Length()
+	if err != nil {
+		return err
+	}
+
+	common := len(idx.entries)
+	if length < common {
+		common = length
+	}
+	// entries and blocks are linked by hash, so once they differ they differ
+	// at every later index and only the last common entry is usually read
+	if common > 0 {
+		last, err := idx.matchesLocked(common - 1)
+		if err != nil {
+			return err
+		}
+		if !last {
+			var readErr error
+			common = sort.Search(common, func(i int) bool {
+				matches, err := idx.matchesLocked(i)
+				if err != nil {
+					readErr = err
+					return true
+				}
+				return !matches
+			})
+			if readErr != nil {
+				return readErr
+			}
+		}
+	}
+
+	if common < len(idx.entries) {
+		log.Logln("dropping", len(idx.entries)-common, "entries of chain index", idx.path, "from block", common)
+		idx.reset(idx.entries[:common])
+		if err := idx.rewrite(); err != nil {
+			return err
+		}
+	}
+
+	for start := common; start < length; start += updateBatchSize {
+		end := start + updateBatchSize - 1
+		if end >= length {
+			end = length - 1
+		}
+		entries, err := idx.readEntries(start, end)
+		if err != nil {
+			return err
+		}
+		if err := idx.append(entries); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readEntries describes the blocks from start to end, inclusive. Blocks pruned
+// from the chain are described by their index only.
+func (idx *Index) readEntries(start, end int) ([]Entry, error) {
+	var entries []Entry
+	blocks, err := idx.store.GetRange(start, end)
+	if err == nil {
+		for _, blk := range blocks {
+			entries = append(entries, newEntry(blk))
+		}
+		return entries, nil
+	} else if !errors.Is(err, chainbackend.ErrBlockPruned) {
+		return nil, err
+	}
+
+	for index := start; index <= end; index++ {
+		blk, err := idx.store.GetBlock(index)
+		if errors.Is(err, chainbackend.ErrBlockPruned) {
+			entries = append(entries, Entry{Index: index})
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		entries = append(entries, newEntry(blk))
+	}
+	return entries, nil
+}
+
+// matchesLocked reports whether the entry at index describes the block of the
+// chain at index. A pruned block is covered by the chain's checkpoint and
+// still matches its entry.
+func (idx *Index) matchesLocked(index int) (bool, error) {
+	blk, err := idx.store.GetBlock(index)
+	if errors.Is(err, chainbackend.ErrBlockPruned) {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+	return bytes.Equal(blk.BlockHash, idx.entries[index].BlockHash), nil
+}
+
+// append indexes entries and appends them to the index file.
+func (idx *Index) append(entries []Entry) error {
+	var buf bytes.Buffer
+	for _, entry := range entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	f, err := os.OpenFile(idx.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		idx.add(entry)
+	}
+	return nil
+}
+
+// newEntry describes blk, reading the worker and root from the blockmap
+// reference or inline blockmap it holds.
+func newEntry(blk *block.Block) Entry {
+	entry := Entry{
+		Index:      blk.Index,
+		Timestamp:  blk.Timestamp,
+		BlockHash:  blk.BlockHash,
+		ParentHash: blk.ParentHash,
+	}
+	if ref, err := blockref.Parse(blk.Data); err == nil {
+		entry.Worker = ref.Worker
+		entry.Root = ref.Root
+		return entry
+	}
+	var inline struct {
+		Root string `json:"root"`
+	}
+	if err := json.Unmarshal(blk.Data, &inline); err == nil {
+		entry.Root = inline.Root
+	}
+	return entry
+}
+
+// ErrStaleIndex indicates an indexed block that no longer matches the chain.
+// It is resolved by the next Update.
+var ErrStaleIndex = errors.New("chainindex: index does not match the chain")
+
+// Block returns the block at index.
+func (idx *Index) Block(index int) (*block.Block, error) {
+	return idx.store.GetBlock(index)
+}
+
+// ByHash returns the block with the given hash.
+func (idx *Index) ByHash(hash []byte) (*block.Block, error) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	index, ok := idx.byHash[string(hash)]
+	if !ok {
+		return nil, chainbackend.ErrBlockNotFound
+	}
+	return idx.blockLocked(index)
+}
+
+// ByParentHash returns the block with the given parent hash.
+func (idx *Index) ByParentHash(hash []byte) (*block.Block, error) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	index, ok := idx.byParent[string(hash)]
+	if !ok {
+		return nil, chainbackend.ErrBlockNotFound
+	}
+	return idx.blockLocked(index)
+}
+
+// ByTimestamp returns the blocks with a timestamp from from to to, inclusive,
+// in chain order.
+func (idx *Index) ByTimestamp(from, to int64) ([]*block.Block, error) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	var indexes []int
+	for _, entry := range idx.entries {
+		if entry.Timestamp >= from && entry.Timestamp <= to {
+			indexes = append(indexes, entry.Index)
+		}
+	}
+	return idx.blocksLocked(indexes)
+}
+
+// ByWorker returns the blocks appended by the worker with the given id, in
+// chain order.
+func (idx *Index) ByWorker(worker string) ([]*block.Block, error) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.blocksLocked(idx.byWorker[worker])
+}
+
+// ByRoot returns the blocks holding blockmaps of the given root path, in
+// chain order.
+func (idx *Index) ByRoot(root string) ([]*block.Block, error) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.blocksLocked(idx.byRoot[root])
+}
+
+func (idx *Index) blockLocked(index int) (*block.Block, error) {
+	blk, err := idx.store.GetBlock(index)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(blk.BlockHash, idx.entries[index].BlockHash) {
+		return nil, ErrStaleIndex
+	}
+	return blk, nil
+}
+
+// blocksLocked returns the blocks at indexes, skipping pruned blocks.
+func (idx *Index) blocksLocked(indexes []int) ([]*block.Block, error) {
+	blocks := []*block.Block{}
+	for _, index := range indexes {
+		blk, err := idx.blockLocked(index)
+		if errors.Is(err, chainbackend.ErrBlockPruned) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, blk)
+	}
+	return blocks, nil
+}
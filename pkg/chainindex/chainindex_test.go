@@ -0,0 +1,103 @@
+package chainindex
+
+import (
+	"crypto/sha512"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/govice/golinks/block"
+	"github.com/govice/golinksd/pkg/blockref"
+	"github.com/govice/golinksd/pkg/chainbackend"
+)
+
+func TestIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "golinksd-chainindex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	genesis := block.NewSHA512Genesis()
+	store := chainbackend.NewMemory(genesis)
+	head := genesis
+	for i := 1; i < 7; i++ {
+		ref := &blockref.Reference{Type: blockref.Type, Version: 1, Root: "/srv/a", Worker: "a"}
+		if i%2 == 0 {
+			ref.Root, ref.Worker = "/srv/b", "b"
+		}
+		data, err := ref.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		head = block.NewSHA512(i, data, head.BlockHash)
+		head.Timestamp = int64(i * 100)
+		head.BlockHash = nil
+		if _, err := head.Hash(sha512.New()); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Append(head); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	path := filepath.Join(dir, "index.jsonl")
+	idx, err := Open(path, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.Update(); err != nil {
+		t.Fatal("expected successful update.", err)
+	}
+	if idx.Length() != 7 {
+		t.Error("expected 7 indexed blocks. got", idx.Length())
+	}
+
+	if blk, err := idx.ByHash(head.BlockHash); err != nil || blk.Index != 6 {
+		t.Error("expected head by hash.", err)
+	}
+	if blk, err := idx.ByParentHash(genesis.BlockHash); err != nil || blk.Index != 1 {
+		t.Error("expected block 1 by parent hash.", err)
+	}
+	if blocks, err := idx.ByTimestamp(200, 400); err != nil || len(blocks) != 3 {
+		t.Error("expected 3 blocks by timestamp. got", len(blocks), err)
+	}
+	if blocks, err := idx.ByWorker("a"); err != nil || len(blocks) != 3 || blocks[0].Index != 1 {
+		t.Error("expected blocks 1, 3 and 5 by worker. got", len(blocks), err)
+	}
+	if blocks, err := idx.ByRoot("/srv/b"); err != nil || len(blocks) != 3 {
+		t.Error("expected 3 blocks by root. got", len(blocks), err)
+	}
+
+	// the index is reloaded from disk and follows a chain that forked
+	reopened, err := Open(path, store)
+	if err != nil || reopened.Length() != 7 {
+		t.Fatal("expected 7 entries after reopening.", err)
+	}
+	blocks, err := store.GetRange(0, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forked := chainbackend.NewMemory(blocks...)
+	forkHead := block.NewSHA512(4, []byte("fork"), blocks[3].BlockHash)
+	if err := forked.Append(forkHead); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err = Open(path, forked)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reopened.Update(); err != nil {
+		t.Fatal("expected successful update after fork.", err)
+	}
+	if reopened.Length() != 5 {
+		t.Error("expected 5 indexed blocks after fork. got", reopened.Length())
+	}
+	if _, err := reopened.ByHash(head.BlockHash); err != chainbackend.ErrBlockNotFound {
+		t.Error("expected replaced head to be dropped. got", err)
+	}
+	if blk, err := reopened.ByHash(forkHead.BlockHash); err != nil || blk.Index != 4 {
+		t.Error("expected fork head by hash.", err)
+	}
+}
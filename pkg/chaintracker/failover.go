@@ -0,0 +1,110 @@
+package chaintracker
+
+import (
+	"errors"
+	"net"
+
+	"github.com/govice/golinksd/pkg/chainbackend"
+	"github.com/govice/golinksd/pkg/golinks"
+	"github.com/govice/golinksd/pkg/httpclient"
+	"github.com/govice/golinksd/pkg/log"
+	"github.com/govice/golinksd/pkg/metrics"
+)
+
+// FailoverSource provides the backends a tracker syncs from while its remote
+// is unavailable.
+type FailoverSource interface {
+	// FailoverBackends returns the backends to try, in order.
+	FailoverBackends() []chainbackend.ChainBackend
+	// ReportInvalidBackend records that backend served blocks failing
+	// validation.
+	ReportInvalidBackend(backend chainbackend.ChainBackend)
+}
+
+// SetFailover sets the source of the backends the tracker syncs from while its
+// remote is unavailable. A nil source disables failover.
+func (t *Tracker) SetFailover(source FailoverSource) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.failover = source
+}
+
+// syncWithFailover syncs with the remote, or with the first failover backend
+// that serves a chain extending the local chain if the remote is unavailable.
+// A failover backend never causes local blocks to be quarantined.
+func (t *Tracker) syncWithFailover() (SyncResult, error) {
+	result, err := t.checkAndSync()
+	t.mu.Lock()
+	source := t.failover
+	t.mu.Unlock()
+	if err == nil || source == nil || !remoteUnavailable(err) {
+		return result, err
+	}
+	localLength, lengthErr := t.store.Length()
+	if lengthErr != nil {
+		// the local chain is recovered from the remote only
+		return result, err
+	}
+
+	primary := t.remoteBackend()
+	defer t.setBackend(primary, false)
+	for _, backend := range source.FailoverBackends() {
+		// a backend behind the local chain is lagging, not diverging
+		if length, err := backend.Length(); err != nil || length < localLength {
+			continue
+		}
+		log.Warnln(t.remote, "remote unavailable, syncing from", backendName(backend))
+		t.setBackend(backend, true)
+		failoverResult, failoverErr := t.checkAndSync()
+		if failoverErr == nil {
+			metrics.PeerFailovers.Add(metrics.RemoteKey(t.remote), 1)
+			return failoverResult, nil
+		}
+		// a backend diverging from the local chain may serve another chain, so
+		// only blocks that fail validation are reported
+		if errors.Is(failoverErr, chainbackend.ErrInvalidBlock) || errors.Is(failoverErr, chainbackend.ErrConflict) {
+			source.ReportInvalidBackend(backend)
+		}
+		log.Warnln(t.remote, "failed to sync from", backendName(backend), failoverErr)
+	}
+	return result, err
+}
+
+// remoteBackend returns the backend the tracker syncs from, which is a
+// failover backend while failing over.
+func (t *Tracker) remoteBackend() chainbackend.ChainBackend {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.backend
+}
+
+// failingOverNow reports whether the tracker syncs from a failover backend.
+func (t *Tracker) failingOverNow() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.failingOver
+}
+
+func (t *Tracker) setBackend(backend chainbackend.ChainBackend, failingOver bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.backend = backend
+	t.failingOver = failingOver
+}
+
+// remoteUnavailable reports whether err means the remote could not serve a
+// request, as opposed to rejecting it.
+func remoteUnavailable(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, httpclient.ErrCircuitOpen) ||
+		errors.Is(err, golinks.ErrServerError) ||
+		errors.Is(err, golinks.ErrRateLimited) ||
+		errors.As(err, &netErr)
+}
+
+func backendName(backend chainbackend.ChainBackend) string {
+	if named, ok := backend.(interface{ RemoteName() string }); ok {
+		return named.RemoteName()
+	}
+	return "failover backend"
+}
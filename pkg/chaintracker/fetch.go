@@ -0,0 +1,126 @@
+package chaintracker
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/govice/golinks/block"
+	"github.com/govice/golinksd/pkg/chainbackend"
+	"github.com/govice/golinksd/pkg/golinks"
+	"github.com/govice/golinksd/pkg/log"
+	"github.com/spf13/viper"
+)
+
+// fetchBlockRange passes the remote blocks from startIndex through endIndex to
+// sink in index order as they arrive. Ranges are requested in batches from the
+// backend, falling back to parallel requests for single blocks when the
+// backend does not serve ranges. Blocks passed to sink before an error
+// are kept by the caller.
+func (t *Tracker) fetchBlockRange(startIndex, endIndex int, sink func(*block.Block) error) error {
+	batchSize := viper.GetInt("sync_batch_size")
+	if batchSize < 1 {
+		batchSize = 1
+	}
+
+	backend := t.remoteBackend()
+	next := startIndex
+	for next <= endIndex {
+		batchEnd := next + batchSize - 1
+		if batchEnd > endIndex {
+			batchEnd = endIndex
+		}
+
+		blocks, err := backend.GetRange(next, batchEnd)
+		if errors.Is(err, chainbackend.ErrRangeUnsupported) {
+			log.Logln(t.remote, "remote does not serve block ranges, fetching blocks individually")
+			break
+		} else if err != nil {
+			log.Errln("failed to get block range:", next, batchEnd, err)
+			return err
+		}
+		// a backend may paginate a range but must make progress
+		if len(blocks) == 0 {
+			log.Errln("remote returned no blocks for range:", next, batchEnd)
+			return fmt.Errorf("%w: empty range %d-%d", golinks.ErrMalformedPayload, next, batchEnd)
+		}
+
+		for _, b := range blocks {
+			if err := sink(b); err != nil {
+				return err
+			}
+			next++
+		}
+	}
+
+	if next > endIndex {
+		return nil
+	}
+	return t.fetchParallel(next, endIndex, sink)
+}
+
+type fetchResult struct {
+	index int
+	block *block.Block
+	err   error
+}
+
+// fetchParallel requests single blocks with up to sync_parallelism requests in
+// flight. Requests are kept within a window ahead of the next block to pass to
+// sink so that a slow block does not buffer the whole range in memory.
+func (t *Tracker) fetchParallel(startIndex, endIndex int, sink func(*block.Block) error) error {
+	parallelism := viper.GetInt("sync_parallelism")
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	window := parallelism * 4
+
+	backend := t.remoteBackend()
+	results := make(chan *fetchResult)
+	pending := make(map[int]*block.Block)
+	next, dispatched, inFlight := startIndex, startIndex, 0
+	var fetchErr, sinkErr error
+
+	for {
+		for fetchErr == nil && sinkErr == nil && inFlight < parallelism && dispatched <= endIndex && dispatched < next+window {
+			index := dispatched
+			go func() {
+				b, err := backend.GetBlock(index)
+				results <- &fetchResult{index: index, block: b, err: err}
+			}()
+			dispatched++
+			inFlight++
+		}
+
+		if inFlight == 0 {
+			break
+		}
+
+		result := <-results
+		inFlight--
+		if result.err != nil {
+			if fetchErr == nil {
+				log.Errln("failed to get block:", result.index, result.err)
+				fetchErr = result.err
+			}
+			continue
+		}
+
+		pending[result.index] = result.block
+		for sinkErr == nil {
+			b, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			sinkErr = sink(b)
+			if sinkErr == nil {
+				next++
+			}
+		}
+	}
+
+	if sinkErr != nil {
+		return sinkErr
+	}
+	return fetchErr
+}
@@ -0,0 +1,136 @@
+package chaintracker
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/govice/golinks/block"
+	"github.com/govice/golinksd/pkg/chainbackend"
+	"github.com/govice/golinksd/pkg/golinks"
+	"github.com/spf13/viper"
+)
+
+// scriptedBackend serves a memory chain with a limited range page size, or
+// without ranges when pageSize is zero.
+type scriptedBackend struct {
+	*chainbackend.Memory
+	pageSize  int
+	emptyPage bool
+	failIndex int
+}
+
+func (b *scriptedBackend) GetBlock(index int) (*block.Block, error) {
+	// later blocks arrive first
+	time.Sleep(time.Duration(20-index%20) * time.Millisecond / 10)
+	if index == b.failIndex {
+		return nil, golinks.ErrServerError
+	}
+	return b.Memory.GetBlock(index)
+}
+
+func (b *scriptedBackend) GetRange(start, end int) ([]*block.Block, error) {
+	if b.emptyPage {
+		return []*block.Block{}, nil
+	}
+	if b.pageSize == 0 {
+		return nil, chainbackend.ErrRangeUnsupported
+	}
+	if end >= start+b.pageSize {
+		end = start + b.pageSize - 1
+	}
+	return b.Memory.GetRange(start, end)
+}
+
+func newScriptedBackend(length int) *scriptedBackend {
+	head := block.NewSHA512Genesis()
+	memory := chainbackend.NewMemory(head)
+	for i := 1; i < length; i++ {
+		head = block.NewSHA512(i, []byte("data"), head.BlockHash)
+		memory.Append(head)
+	}
+	return &scriptedBackend{Memory: memory, failIndex: -1}
+}
+
+func TestFetchBlockRange(t *testing.T) {
+	viper.Set("sync_batch_size", 10)
+	viper.Set("sync_parallelism", 3)
+	defer viper.Set("sync_batch_size", nil)
+	defer viper.Set("sync_parallelism", nil)
+
+	collect := func(backend chainbackend.ChainBackend, start, end int) ([]int, error) {
+		var indexes []int
+		tracker := newTracker("test", nil, backend)
+		done := make(chan error, 1)
+		go func() {
+			done <- tracker.fetchBlockRange(start, end, func(b *block.Block) error {
+				indexes = append(indexes, b.Index)
+				return nil
+			})
+		}()
+		select {
+		case err := <-done:
+			return indexes, err
+		case <-time.After(5 * time.Second):
+			t.Fatal("fetch did not return")
+			return nil, nil
+		}
+	}
+	inOrder := func(indexes []int, start, end int) bool {
+		if len(indexes) != end-start+1 {
+			return false
+		}
+		for i, index := range indexes {
+			if index != start+i {
+				return false
+			}
+		}
+		return true
+	}
+
+	// paginated ranges
+	backend := newScriptedBackend(30)
+	backend.pageSize = 4
+	if indexes, err := collect(backend, 2, 29); err != nil || !inOrder(indexes, 2, 29) {
+		t.Error("expected blocks 2 through 29 in order from paginated ranges. got", indexes, err)
+	}
+
+	// single blocks requested in parallel are passed in order
+	backend = newScriptedBackend(30)
+	if indexes, err := collect(backend, 0, 29); err != nil || !inOrder(indexes, 0, 29) {
+		t.Error("expected blocks 0 through 29 in order from parallel requests. got", indexes, err)
+	}
+
+	// blocks before a failed request are kept
+	backend = newScriptedBackend(30)
+	backend.failIndex = 12
+	if indexes, err := collect(backend, 0, 29); !errors.Is(err, golinks.ErrServerError) || !inOrder(indexes, 0, 11) {
+		t.Error("expected blocks 0 through 11 and a server error. got", indexes, err)
+	}
+
+	// an empty page is malformed instead of retried forever
+	backend = newScriptedBackend(30)
+	backend.emptyPage = true
+	if indexes, err := collect(backend, 0, 29); !errors.Is(err, golinks.ErrMalformedPayload) || len(indexes) != 0 {
+		t.Error("expected ErrMalformedPayload for an empty range. got", indexes, err)
+	}
+}
+
+func TestFetchParallelSinkError(t *testing.T) {
+	viper.Set("sync_parallelism", 4)
+	defer viper.Set("sync_parallelism", nil)
+
+	sinkErr := errors.New("sink failed")
+	tracker := newTracker("test", nil, newScriptedBackend(20))
+	var passed int
+	err := tracker.fetchParallel(0, 19, func(b *block.Block) error {
+		if b.Index == 5 {
+			return sinkErr
+		}
+		passed++
+		return nil
+	})
+	if !errors.Is(err, sinkErr) || passed != 5 {
+		t.Error("expected sink error after 5 blocks. got", passed, err)
+	}
+}
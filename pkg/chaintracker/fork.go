@@ -0,0 +1,179 @@
+package chaintracker
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/govice/golinks/block"
+	"github.com/govice/golinksd/pkg/atomicfile"
+	"github.com/govice/golinksd/pkg/chainbackend"
+	"github.com/govice/golinksd/pkg/log"
+)
+
+// DesyncReport describes the local blocks that diverged from a remote's chain
+// and were moved to quarantine.
+type DesyncReport struct {
+	Remote        string           `json:"remote"`
+	DetectedAt    time.Time        `json:"detected_at"`
+	ForkIndex     int              `json:"fork_index"`
+	LocalLength   int              `json:"local_length"`
+	RemoteLength  int              `json:"remote_length"`
+	QuarantineDir string           `json:"quarantine_dir"`
+	Blocks        []DivergentBlock `json:"blocks"`
+}
+
+// DivergentBlock identifies a quarantined block.
+type DivergentBlock struct {
+	Index      int    `json:"index"`
+	Timestamp  int64  `json:"timestamp"`
+	BlockHash  []byte `json:"block_hash"`
+	ParentHash []byte `json:"parent_hash"`
+}
+
+const desyncReportName = "report.json"
+
+// LastDesync returns the report of the tracker's latest desync recovery, or
+// nil if the local chain has not diverged from the remote.
+func (t *Tracker) LastDesync() *DesyncReport {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.lastDesync
+}
+
+// recoverFork finds where the local chain diverged from the remote, moves the
+// blocks after that point to a timestamped quarantine directory and truncates
+// the local chain so that the sync resumes from the fork point. A local chain
+// that cannot be read is cleared instead, without a report.
+func (t *Tracker) recoverFork() (*DesyncReport, error) {
+	localLength, err := t.store.Length()
+	if errors.Is(err, chainbackend.ErrChainGap) || errors.Is(err, chainbackend.ErrCorruptRecord) {
+		log.Errln(t.remote, "local chain is unreadable, clearing it", err)
+		return nil, t.store.Clear()
+	} else if err != nil {
+		return nil, err
+	}
+
+	remoteLength, err := t.remoteBackend().Length()
+	if err != nil {
+		log.Errln("failed to get remote length")
+		return nil, err
+	}
+
+	base := t.store.Base()
+	fork, err := t.greatestCommonIndex(base, localLength, remoteLength)
+	if err != nil {
+		log.Errln(t.remote, "failed to find fork point with remote", err)
+		return nil, err
+	}
+	if fork >= localLength {
+		return nil, nil
+	}
+
+	blocks, err := t.store.GetRange(fork, localLength-1)
+	if err != nil {
+		log.Errln(t.remote, "failed to read divergent blocks", fork, "to", localLength-1, err)
+		return nil, err
+	}
+
+	report := &DesyncReport{
+		Remote:       t.remote,
+		DetectedAt:   time.Now(),
+		ForkIndex:    fork,
+		LocalLength:  localLength,
+		RemoteLength: remoteLength,
+	}
+	for _, blk := range blocks {
+		report.Blocks = append(report.Blocks, DivergentBlock{
+			Index:      blk.Index,
+			Timestamp:  blk.Timestamp,
+			BlockHash:  blk.BlockHash,
+			ParentHash: blk.ParentHash,
+		})
+	}
+	if err := t.quarantine(report, blocks); err != nil {
+		log.Errln(t.remote, "failed to quarantine divergent blocks", err)
+		return nil, err
+	}
+
+	if base > 0 && fork <= base {
+		// the chain cannot be truncated into its pruned prefix
+		log.Errln(t.remote, "local chain diverged from remote at its pruned base", base, "- clearing it")
+		if err := t.store.Clear(); err != nil {
+			return nil, err
+		}
+	} else if err := t.store.Truncate(fork); err != nil {
+		log.Errln(t.remote, "failed to truncate local chain to fork point", fork, err)
+		return nil, err
+	}
+
+	t.mu.Lock()
+	t.lastDesync = report
+	t.mu.Unlock()
+
+	log.Errln(t.remote, "local chain diverged from remote at block", fork, "-", len(blocks), "blocks moved to", report.QuarantineDir)
+	for _, blk := range report.Blocks {
+		log.Errln(t.remote, "divergent block", blk.Index, "hash", base64.StdEncoding.EncodeToString(blk.BlockHash))
+	}
+	return report, nil
+}
+
+// greatestCommonIndex returns the number of blocks the local chain shares with
+// the remote, which is the index of the first divergent block. Blocks are
+// linked by hash, so once the chains differ they differ at every later index
+// and the fork point is found by binary search. The search starts at base, the
+// first block of a pruned chain.
+func (t *Tracker) greatestCommonIndex(base, localLength, remoteLength int) (int, error) {
+	backend := t.remoteBackend()
+	lo, hi := base, localLength
+	if remoteLength < hi {
+		hi = remoteLength
+	}
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		local, err := t.store.GetBlock(mid)
+		if err != nil {
+			return -1, err
+		}
+		remote, err := backend.GetBlock(mid)
+		if err != nil {
+			return -1, err
+		}
+		if block.Equal(local, remote) {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	return lo, nil
+}
+
+// quarantine writes blocks and their report to a new directory under the
+// local chain's quarantine directory.
+func (t *Tracker) quarantine(report *DesyncReport, blocks []*block.Block) error {
+	dir := filepath.Join(t.store.QuarantineDir(), report.DetectedAt.UTC().Format("20060102T150405.000000000Z"))
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	report.QuarantineDir = dir
+
+	for _, blk := range blocks {
+		blockBytes, err := json.Marshal(blk)
+		if err != nil {
+			return err
+		}
+		if err := atomicfile.WriteFile(filepath.Join(dir, strconv.Itoa(blk.Index)+".json"), blockBytes, 0644); err != nil {
+			return err
+		}
+	}
+
+	reportBytes, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(filepath.Join(dir, desyncReportName), reportBytes, 0644)
+}
@@ -0,0 +1,138 @@
+package chaintracker
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/govice/golinksd/pkg/atomicfile"
+	"github.com/govice/golinksd/pkg/log"
+	"github.com/spf13/viper"
+)
+
+// Progress describes a running or finished sync of a local chain.
+type Progress struct {
+	Remote string `json:"remote"`
+	// StartIndex is the local length the sync started from, and Synced the
+	// current local length.
+	StartIndex int `json:"start_index"`
+	Synced     int `json:"synced"`
+	// Target is the remote length the sync catches up to.
+	Target          int           `json:"target"`
+	BlocksPerSecond float64       `json:"blocks_per_second"`
+	ETA             time.Duration `json:"eta"`
+	StartedAt       time.Time     `json:"started_at"`
+	// Resumed is set when the sync continues one interrupted by a restart.
+	Resumed bool `json:"resumed"`
+	Done    bool `json:"done"`
+}
+
+// syncCheckpoint records a sync in progress in the chain directory, so that a
+// sync interrupted by a restart is known to be resumed.
+type syncCheckpoint struct {
+	Target    int       `json:"target"`
+	Synced    int       `json:"synced"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+const syncCheckpointName = "sync.json"
+
+func (t *Tracker) checkpointPath() string {
+	return filepath.Join(t.store.Dir(), syncCheckpointName)
+}
+
+// OnProgress registers handler to be called with the progress of every sync
+// of the tracker, at least every sync_progress_interval milliseconds and
+// when a sync ends.
+func (t *Tracker) OnProgress(handler func(Progress)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.progressHandlers = append(t.progressHandlers, handler)
+}
+
+// Progress returns the progress of the tracker's running or latest sync, or
+// nil if the tracker has not synced any blocks.
+func (t *Tracker) Progress() *Progress {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.progress == nil {
+		return nil
+	}
+	progress := *t.progress
+	return &progress
+}
+
+// startProgress begins tracking the progress of a sync from the local length
+// to the remote length of syncInfo.
+func (t *Tracker) startProgress(syncInfo *SyncInfo) *Progress {
+	progress := &Progress{
+		Remote:     t.remote,
+		StartIndex: syncInfo.LocalLength,
+		Synced:     syncInfo.LocalLength,
+		Target:     syncInfo.RemoteLength,
+		StartedAt:  time.Now(),
+	}
+
+	if checkpointBytes, err := ioutil.ReadFile(t.checkpointPath()); err == nil {
+		checkpoint := &syncCheckpoint{}
+		if err := json.Unmarshal(checkpointBytes, checkpoint); err == nil && checkpoint.Target > syncInfo.LocalLength {
+			progress.Resumed = true
+			log.Logf("%s resuming sync started %s at block %d of %d\n", t.remote, checkpoint.StartedAt.Format(time.RFC3339), syncInfo.LocalLength, syncInfo.RemoteLength)
+		}
+	}
+
+	t.reportProgress(progress)
+	return progress
+}
+
+// reportProgress updates the rate and ETA of progress, checkpoints it and
+// passes it to the progress handlers.
+func (t *Tracker) reportProgress(progress *Progress) {
+	elapsed := time.Since(progress.StartedAt)
+	if synced := progress.Synced - progress.StartIndex; synced > 0 && elapsed > 0 {
+		progress.BlocksPerSecond = float64(synced) / elapsed.Seconds()
+		remaining := float64(progress.Target - progress.Synced)
+		progress.ETA = time.Duration(remaining / progress.BlocksPerSecond * float64(time.Second)).Round(time.Second)
+	}
+
+	if progress.Done {
+		if err := os.Remove(t.checkpointPath()); err != nil && !os.IsNotExist(err) {
+			log.Warnln(t.remote, "failed to remove sync checkpoint", err)
+		}
+	} else {
+		checkpointBytes, err := json.Marshal(&syncCheckpoint{
+			Target:    progress.Target,
+			Synced:    progress.Synced,
+			StartedAt: progress.StartedAt,
+		})
+		if err == nil {
+			err = atomicfile.WriteFile(t.checkpointPath(), checkpointBytes, 0644)
+		}
+		if err != nil {
+			log.Warnln(t.remote, "failed to write sync checkpoint", err)
+		}
+	}
+
+	if progress.Done {
+		log.Logf("%s synchronized %d blocks (%.1f blocks/s)\n", t.remote, progress.Synced-progress.StartIndex, progress.BlocksPerSecond)
+	} else if progress.Synced > progress.StartIndex {
+		log.Logf("%s synchronized %d of %d blocks (%.1f blocks/s, ETA %s)\n", t.remote, progress.Synced, progress.Target, progress.BlocksPerSecond, progress.ETA)
+	}
+
+	t.mu.Lock()
+	snapshot := *progress
+	t.progress = &snapshot
+	handlers := append([]func(Progress){}, t.progressHandlers...)
+	t.mu.Unlock()
+
+	for _, handler := range handlers {
+		handler(snapshot)
+	}
+}
+
+// progressInterval returns the minimum time between progress reports.
+func progressInterval() time.Duration {
+	return time.Millisecond * time.Duration(viper.GetInt("sync_progress_interval"))
+}
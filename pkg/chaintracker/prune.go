@@ -0,0 +1,140 @@
+package chaintracker
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/govice/golinks/block"
+	"github.com/govice/golinksd/pkg/atomicfile"
+	"github.com/govice/golinksd/pkg/log"
+	"github.com/spf13/viper"
+)
+
+// ErrInvalidCheckpoint indicates a checkpoint that was not signed with the
+// daemon's checkpoint key.
+var ErrInvalidCheckpoint = errors.New("chaintracker: checkpoint signature is invalid")
+
+// loadCheckpointKey reads the key checkpoints are signed with from path,
+// generating it if it does not exist.
+func loadCheckpointKey(path string) ([]byte, error) {
+	keyBytes, err := ioutil.ReadFile(path)
+	if err == nil {
+		return hex.DecodeString(strings.TrimSpace(string(keyBytes)))
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, err
+	}
+	if err := atomicfile.WriteFile(path, []byte(hex.EncodeToString(key)), 0600); err != nil {
+		return nil, err
+	}
+	log.Logln("generated checkpoint key", path)
+	return key, nil
+}
+
+// SetKeepFilter sets the filter selecting the blocks kept when the local chain
+// is pruned.
+func (t *Tracker) SetKeepFilter(keep func(*block.Block) bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.keep = keep
+}
+
+// retentionBase returns the index of the first block to keep under the
+// retention policy, which keeps the last retention_blocks blocks or the blocks
+// of the last retention_period milliseconds. A block is kept if either
+// setting keeps it, and a zero setting keeps every block.
+func (t *Tracker) retentionBase(length int) (int, error) {
+	retentionBlocks := viper.GetInt("retention_blocks")
+	retentionPeriod := time.Millisecond * time.Duration(viper.GetInt("retention_period"))
+	if retentionBlocks <= 0 && retentionPeriod <= 0 {
+		return 0, nil
+	}
+
+	base := length
+	if retentionBlocks > 0 && length-retentionBlocks < base {
+		base = length - retentionBlocks
+	}
+	if retentionPeriod > 0 {
+		cutoff := time.Now().Add(-retentionPeriod).UnixNano()
+		storeBase := t.store.Base()
+		var readErr error
+		periodBase := storeBase + sort.Search(length-storeBase, func(i int) bool {
+			blk, err := t.store.GetBlock(storeBase + i)
+			if err != nil {
+				readErr = err
+				return true
+			}
+			return blk.Timestamp >= cutoff
+		})
+		if readErr != nil {
+			return 0, readErr
+		}
+		if periodBase < base {
+			base = periodBase
+		}
+	}
+	if base < 0 {
+		base = 0
+	}
+	return base, nil
+}
+
+// Prune removes the blocks the retention policy no longer keeps from the local
+// chain, except those selected by the keep filter, and returns the number of
+// blocks removed.
+func (t *Tracker) Prune() (int, error) {
+	length, err := t.store.Length()
+	if err != nil {
+		return 0, err
+	}
+	base, err := t.retentionBase(length)
+	if err != nil {
+		log.Errln(t.remote, "failed to apply retention policy", err)
+		return 0, err
+	}
+	if base <= t.store.Base() {
+		return 0, nil
+	}
+
+	t.mu.Lock()
+	keep := t.keep
+	t.mu.Unlock()
+
+	pruned, err := t.store.Prune(base, keep, t.checkpointKey)
+	if err != nil {
+		log.Errln(t.remote, "failed to prune local chain", err)
+		return 0, err
+	}
+	log.Logln(t.remote, "pruned", pruned, "blocks before block", base, "from local chain")
+	t.updateIndex()
+	return pruned, nil
+}
+
+// resetPrunePeriod applies a new prune period to a running tracker. A period
+// of zero disables pruning.
+func (t *Tracker) resetPrunePeriod(prunePeriod int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.pruneTicker == nil {
+		return
+	}
+	if prunePeriod <= 0 {
+		t.pruneTicker.Stop()
+		return
+	}
+	t.pruneTicker.Reset(time.Millisecond * time.Duration(prunePeriod))
+}
@@ -2,322 +2,187 @@ package chaintracker
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
-	"io/ioutil"
-	"os"
-	"path"
 	"path/filepath"
-	"sort"
-	"strconv"
-	"strings"
-	"sync"
-	"time"
 
 	"github.com/govice/golinks/block"
+	"github.com/govice/golinksd/pkg/chainbackend"
+	"github.com/govice/golinksd/pkg/chainindex"
 	"github.com/govice/golinksd/pkg/config"
-	"github.com/govice/golinksd/pkg/golinks"
 	"github.com/govice/golinksd/pkg/log"
 	"github.com/spf13/viper"
+	"golang.org/x/sync/errgroup"
 )
 
+// indexFileName is the name of a local chain's index in its chain_dir.
+const indexFileName = "index.jsonl"
+
 type Service struct {
-	servicer      Servicer
-	forceSyncChan chan *sync.WaitGroup
+	servicer Servicer
+	trackers map[string]*Tracker
 }
 
 type ConfigServicer interface {
 	ConfigService() *config.Service
 }
 
-type GolinksServicer interface {
-	GolinksService() *golinks.Service
+type ChainBackendServicer interface {
+	ChainBackend(remote string) (chainbackend.ChainBackend, error)
 }
 
 type Servicer interface {
 	ConfigServicer
-	GolinksServicer
+	ChainBackendServicer
 }
 
 func New(servicer Servicer) (*Service, error) {
-	return &Service{
-		servicer:      servicer,
-		forceSyncChan: make(chan *sync.WaitGroup),
-	}, nil
-}
-
-func (ct *Service) Execute(ctx context.Context) error {
-	log.Logln("starting chain tracker")
-	if err := ct.initialize(); err != nil {
-		return err
-	}
-	trackingPeriod := viper.GetInt("tracking_period")
-	log.Logln("tracking period:", trackingPeriod)
-	syncTicker := time.NewTicker(time.Millisecond * time.Duration(trackingPeriod))
-	for {
-		select {
-		case <-syncTicker.C:
-			log.Logln("running periodic sync...")
-			if err := ct.checkAndSync(); err != nil {
-				log.Errln("check and sync failed", err)
-			}
-		case wg := <-ct.forceSyncChan:
-			log.Logln("received force sync...")
-			if err := ct.checkAndSync(); err != nil {
-				log.Errln("force sync failed", err)
-			}
-			wg.Done()
-		case <-ctx.Done():
-			log.Logln("received termination on chain tracker context")
-			return nil
-		}
+	ct := &Service{
+		servicer: servicer,
+		trackers: make(map[string]*Tracker),
 	}
-}
-
-func (ct *Service) initialize() error {
-	os.Mkdir(ct.chainDir(), os.ModePerm)
-	return nil
-}
 
-func (ct *Service) chainDir() string {
-	return filepath.Join(ct.servicer.ConfigService().HomeDir(), "chain")
-}
+	checkpointKey, err := loadCheckpointKey(viper.GetString("checkpoint_key_file"))
+	if err != nil {
+		log.Errln("failed to load checkpoint key")
+		return nil, err
+	}
 
-func (ct *Service) checkAndSync() error {
-	syncInfo, err := ct.getSyncInfo()
-	if errors.Is(err, ErrChainDesync) {
-		if err := ct.clearLocalChain(); err != nil {
-			log.Errln("failed to clear local chain", err)
-			return err
+	for _, remote := range servicer.ConfigService().Remotes() {
+		backend, err := servicer.ChainBackend(remote.Name())
+		if err != nil {
+			log.Errln("failed to get chain backend for remote", remote.Name())
+			return nil, err
 		}
-		syncInfo, err = ct.getSyncInfo()
+		store, err := chainbackend.NewFilesystem(remote.ChainDir())
 		if err != nil {
-			log.Errln("failed to get sync info:", err)
-			return err
+			log.Errln("failed to open local chain of remote", remote.Name())
+			return nil, err
 		}
-	} else if err != nil {
-		log.Errln("failed to get sync info:", err)
-		return err
-	}
-
-	if syncInfo.NeedsSync {
-		log.Logf("synchronizing local chain (%d) with remote (%d)\n", syncInfo.LocalLength, syncInfo.RemoteLength)
-		if err := ct.synchronize(syncInfo); err != nil {
-			log.Errln("failed to synchronize chain", err)
-			return err
+		store.SetCompression(viper.GetBool("chain_compression"))
+		index, err := chainindex.Open(filepath.Join(remote.ChainDir(), indexFileName), store)
+		if err != nil {
+			log.Errln("failed to open local chain index of remote", remote.Name())
+			return nil, err
 		}
+		tracker := newTracker(remote.Name(), store, backend)
+		tracker.index = index
+		tracker.checkpointKey = checkpointKey
+		ct.trackers[remote.Name()] = tracker
 	}
 
-	return nil
-}
-
-func (ct *Service) clearLocalChain() error {
-	fis, err := ioutil.ReadDir(ct.chainDir())
-	if err != nil {
-		return err
-	}
-
-	for _, f := range fis {
-		fn := path.Join(ct.chainDir(), f.Name())
-		if err := os.RemoveAll(fn); err != nil {
-			log.Errln("failed to remove file:", fn)
-			return nil
+	servicer.ConfigService().OnChange("tracking_period", func() error {
+		trackingPeriod := viper.GetInt("tracking_period")
+		if trackingPeriod <= 0 {
+			return ErrInvalidTrackingPeriod
 		}
-	}
-
-	return nil
-}
-
-func (ct *Service) synchronize(syncInfo *SyncInfo) error {
-	blocks, err := ct.requestBlockRange(syncInfo.LocalLength, syncInfo.RemoteLength-1)
-	if err != nil {
-		log.Errln("failed to get block range:", syncInfo.LocalLength, syncInfo.RemoteLength-1)
-		return err
-	}
-
-	for _, b := range blocks {
-		blockBytes, err := json.Marshal(b)
-		if err != nil {
-			log.Errln("failed to marshal block", b.Index)
-			return err
+		for _, tracker := range ct.Trackers() {
+			tracker.resetTrackingPeriod(trackingPeriod)
 		}
+		return nil
+	})
 
-		fileName := filepath.Join(ct.chainDir(), strconv.Itoa(b.Index)+".json")
-		if err := ioutil.WriteFile(fileName, blockBytes, os.ModePerm); err != nil {
-			log.Errln("failed to write block file", fileName)
-			return err
+	servicer.ConfigService().OnChange("verify_period", func() error {
+		verifyPeriod := viper.GetInt("verify_period")
+		if verifyPeriod < 0 {
+			return ErrInvalidVerifyPeriod
 		}
-	}
-
-	return nil
-}
-
-func (ct *Service) localChainFileLength() (int, error) {
-	files, err := ct.readChainDir()
-	if err != nil {
-		return -1, err
-	}
-
-	if len(files) == 0 {
-		return 0, nil
-	}
-
-	//files should already be sorted alphanumerically
-	length := 0
-	for index, file := range files {
-		if !strings.HasSuffix(file.Name(), ".json") {
-			log.Errln("found non-json file in chainDir")
-			continue
+		for _, tracker := range ct.Trackers() {
+			tracker.resetVerifyPeriod(verifyPeriod)
 		}
+		return nil
+	})
 
-		if strings.HasPrefix(file.Name(), strconv.Itoa(index)) {
-			length++
-		} else {
-			log.Errln("file name", file.Name(), "does not have expected prefix", strconv.Itoa(index))
-			return -1, ErrChainDesync
+	servicer.ConfigService().OnChange("prune_period", func() error {
+		prunePeriod := viper.GetInt("prune_period")
+		if prunePeriod < 0 {
+			return ErrInvalidPrunePeriod
 		}
-	}
+		for _, tracker := range ct.Trackers() {
+			tracker.resetPrunePeriod(prunePeriod)
+		}
+		return nil
+	})
 
-	return length, nil
+	return ct, nil
 }
 
-// ErrChainDesync indicates the local chain head is out of sync with a remote block of the same index.
-var ErrChainDesync = errors.New("chaintracker: desync in local chain with remote")
-
-func (ct *Service) getSyncInfo() (*SyncInfo, error) {
-	remoteLength, err := ct.servicer.GolinksService().GetLength()
-	if err != nil {
-		log.Errln("failed to get remote length")
-		return nil, err
+// Execute runs a tracker loop for every configured remote until ctx is done.
+func (ct *Service) Execute(ctx context.Context) error {
+	var errorGroup errgroup.Group
+	for _, tracker := range ct.Trackers() {
+		tracker := tracker
+		errorGroup.Go(func() error {
+			return tracker.Execute(ctx)
+		})
 	}
+	return errorGroup.Wait()
+}
 
-	localLength, err := ct.localChainFileLength()
-	if err != nil {
-		log.Errln("failed to get local chain length")
-		return nil, err
-	}
+var ErrInvalidTrackingPeriod = errors.New("chaintracker: tracking period must be positive")
 
-	localHead, err := ct.LocalHead()
-	if !errors.Is(err, ErrMissingLocalHead) {
-		// enforce the daemon population by a single remote chain
-		remoteLocalHead, err := ct.servicer.GolinksService().GetBlock(localHead.Index)
-		if err != nil {
-			log.Errln("failed to get local head from remote")
-			return nil, err
-		}
+var ErrInvalidVerifyPeriod = errors.New("chaintracker: verify period must not be negative")
 
-		if !block.Equal(localHead, remoteLocalHead) || (localLength > remoteLength) {
-			log.Errln(ErrChainDesync)
-			return nil, ErrChainDesync
-		}
-	}
+var ErrInvalidPrunePeriod = errors.New("chaintracker: prune period must not be negative")
 
-	syncInfo := &SyncInfo{
-		RemoteLength: remoteLength,
-		LocalLength:  localLength,
-		NeedsSync:    false,
-	}
+var ErrUnknownTracker = errors.New("chaintracker: no tracker for remote")
 
-	if remoteLength > localLength {
-		syncInfo.NeedsSync = true
+// Tracker returns the tracker of the named remote. An empty name refers to the
+// default remote.
+func (ct *Service) Tracker(remote string) (*Tracker, error) {
+	if remote == "" {
+		remote = config.DefaultRemote
 	}
-
-	return syncInfo, nil
+	tracker, ok := ct.trackers[remote]
+	if !ok {
+		return nil, ErrUnknownTracker
+	}
+	return tracker, nil
 }
 
-func (ct *Service) requestBlockRange(startIndex, endIndex int) ([]*block.Block, error) {
-	var blocks []*block.Block
-	for index := startIndex; index <= endIndex; index++ {
-		block, err := ct.servicer.GolinksService().GetBlock(index)
-		if err != nil {
-			log.Errln("failed to get block:", index, err)
-			return nil, err
+// Trackers returns the trackers of every configured remote.
+func (ct *Service) Trackers() []*Tracker {
+	var trackers []*Tracker
+	for _, remote := range ct.servicer.ConfigService().Remotes() {
+		if tracker, ok := ct.trackers[remote.Name()]; ok {
+			trackers = append(trackers, tracker)
 		}
-		blocks = append(blocks, block)
 	}
-	return blocks, nil
+	return trackers
 }
 
-var ErrMissingLocalHead = errors.New("chaintracker: missing local head")
-
-func (ct *Service) LocalHead() (*block.Block, error) {
-
-	files, err := ct.readChainDir()
-	if err != nil {
-		log.Errln("failed to read chain directory")
-		return nil, err
-	}
-
-	if files == nil {
-		return nil, ErrMissingLocalHead
+// SetKeepFilter sets the filter selecting the blocks kept when the local chains
+// are pruned.
+func (ct *Service) SetKeepFilter(keep func(*block.Block) bool) {
+	for _, tracker := range ct.trackers {
+		tracker.SetKeepFilter(keep)
 	}
+}
 
-	fileAbs := filepath.Join(ct.chainDir(), files[len(files)-1].Name())
-
-	blockBytes, err := ioutil.ReadFile(fileAbs)
+// SetFailover sets the source of the backends the named remote's tracker
+// syncs from while the remote is unavailable.
+func (ct *Service) SetFailover(remote string, source FailoverSource) error {
+	tracker, err := ct.Tracker(remote)
 	if err != nil {
-		return nil, err
-	}
-
-	b := &block.Block{}
-	if err := json.Unmarshal(blockBytes, b); err != nil {
-		return nil, err
+		return err
 	}
-
-	return b, nil
+	tracker.SetFailover(source)
+	return nil
 }
 
-func (ct *Service) readChainDir() ([]os.FileInfo, error) {
-	files, err := ioutil.ReadDir(ct.chainDir())
+// LocalHead returns the local head of the default remote's chain.
+func (ct *Service) LocalHead() (*block.Block, error) {
+	tracker, err := ct.Tracker(config.DefaultRemote)
 	if err != nil {
 		return nil, err
 	}
-
-	var filesOut []os.FileInfo
-	// omit any OS generated hidden files/folders
-	for _, fi := range files {
-		if strings.HasSuffix(fi.Name(), ".json") {
-			filesOut = append(filesOut, fi)
-		}
-	}
-
-	sort.Sort(NumericalFileInfos(filesOut))
-
-	return filesOut, nil
+	return tracker.LocalHead()
 }
 
-type SyncInfo struct {
-	NeedsSync    bool
-	LocalLength  int
-	RemoteLength int
-}
-
-type NumericalFileInfos []os.FileInfo
-
-func (nfi NumericalFileInfos) Len() int {
-	return len(nfi)
-}
-
-func (nfi NumericalFileInfos) Swap(i, j int) {
-	nfi[i], nfi[j] = nfi[j], nfi[i]
-}
-
-func (nfi NumericalFileInfos) Less(i, j int) bool {
-	pathA := nfi[i].Name()
-	pathB := nfi[j].Name()
-
-	a, err := strconv.Atoi(pathA[0:strings.LastIndex(pathA, ".")])
-	if err != nil {
-		return pathA < pathB
-	}
-	b, err := strconv.Atoi(pathB[0:strings.LastIndex(pathB, ".")])
+// Sync syncs the default remote's chain. See Tracker.Sync.
+func (ct *Service) Sync(ctx context.Context) (SyncResult, error) {
+	tracker, err := ct.Tracker(config.DefaultRemote)
 	if err != nil {
-		return pathA < pathB
+		return SyncResult{}, err
 	}
-
-	return a < b
-}
-
-func (ct *Service) ForceSync(wg *sync.WaitGroup) {
-	ct.forceSyncChan <- wg
+	return tracker.Sync(ctx)
 }
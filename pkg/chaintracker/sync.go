@@ -0,0 +1,89 @@
+package chaintracker
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// SyncResult is the outcome of a sync of a local chain with its remote.
+type SyncResult struct {
+	Remote       string
+	LocalLength  int
+	RemoteLength int
+	// Synced is the number of blocks appended to the local chain.
+	Synced int
+	// Desync reports the blocks moved to quarantine when the local chain
+	// had diverged from the remote, or is nil.
+	Desync      *DesyncReport
+	CompletedAt time.Time
+}
+
+// syncCall is a sync requested by one or more callers of Sync.
+type syncCall struct {
+	done   chan struct{}
+	result SyncResult
+	err    error
+}
+
+// ErrTrackerStopped indicates a sync requested from a tracker that stopped
+// before running it.
+var ErrTrackerStopped = errors.New("chaintracker: tracker stopped")
+
+// Sync syncs the local chain with the remote and returns the outcome. The sync
+// starts after the call, so its result reflects the remote at or after the
+// time of the call. Calls made while a sync is pending share a single sync.
+// Sync returns ctx's error if ctx is done before the sync finishes; the sync
+// itself keeps running for the other callers.
+func (t *Tracker) Sync(ctx context.Context) (SyncResult, error) {
+	t.mu.Lock()
+	call := t.nextSync
+	if call == nil {
+		call = &syncCall{done: make(chan struct{})}
+		t.nextSync = call
+	}
+	t.mu.Unlock()
+
+	// the request is picked up by the tracker loop, a pending signal already
+	// covers it
+	select {
+	case t.syncRequests <- struct{}{}:
+	default:
+	}
+
+	select {
+	case <-call.done:
+		return call.result, call.err
+	case <-ctx.Done():
+		return SyncResult{}, ctx.Err()
+	}
+}
+
+// takeSyncCall returns the pending sync call, if any, so that later calls to
+// Sync wait for the next sync.
+func (t *Tracker) takeSyncCall() *syncCall {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	call := t.nextSync
+	t.nextSync = nil
+	return call
+}
+
+// runSync runs a sync and passes its outcome to the pending callers of Sync.
+func (t *Tracker) runSync() {
+	call := t.takeSyncCall()
+	result, err := t.syncWithFailover()
+	t.recordSync(err)
+	if call != nil {
+		call.result, call.err = result, err
+		close(call.done)
+	}
+}
+
+// failPendingSync releases the pending callers of Sync with err.
+func (t *Tracker) failPendingSync(err error) {
+	if call := t.takeSyncCall(); call != nil {
+		call.err = err
+		close(call.done)
+	}
+}
@@ -0,0 +1,370 @@
+package chaintracker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/govice/golinks/block"
+	"github.com/govice/golinksd/pkg/chainbackend"
+	"github.com/govice/golinksd/pkg/chainindex"
+	"github.com/govice/golinksd/pkg/golinks"
+	"github.com/govice/golinksd/pkg/httpclient"
+	"github.com/govice/golinksd/pkg/log"
+	"github.com/spf13/viper"
+)
+
+// Tracker synchronizes the local copy of a single remote's chain.
+type Tracker struct {
+	remote       string
+	store        *chainbackend.Filesystem
+	backend      chainbackend.ChainBackend
+	syncRequests chan struct{}
+	nextSync     *syncCall
+	mu           sync.Mutex
+	syncTicker   *time.Ticker
+	period       time.Duration
+	failures     int
+	verifyTicker *time.Ticker
+	lastVerify   *VerifyResult
+	lastDesync   *DesyncReport
+	index        *chainindex.Index
+
+	progress         *Progress
+	progressHandlers []func(Progress)
+
+	checkpointKey []byte
+	keep          func(*block.Block) bool
+	pruneTicker   *time.Ticker
+
+	failover    FailoverSource
+	failingOver bool
+}
+
+func newTracker(remote string, store *chainbackend.Filesystem, backend chainbackend.ChainBackend) *Tracker {
+	return &Tracker{
+		remote:       remote,
+		store:        store,
+		backend:      backend,
+		syncRequests: make(chan struct{}, 1),
+	}
+}
+
+// Remote returns the name of the tracked remote.
+func (t *Tracker) Remote() string {
+	return t.remote
+}
+
+func (t *Tracker) Execute(ctx context.Context) error {
+	log.Logln("starting chain tracker for remote", t.remote)
+	trackingPeriod := viper.GetInt("tracking_period")
+	log.Logln(t.remote, "tracking period:", trackingPeriod)
+	syncTicker := time.NewTicker(time.Millisecond * time.Duration(trackingPeriod))
+	t.mu.Lock()
+	t.syncTicker = syncTicker
+	t.period = time.Millisecond * time.Duration(trackingPeriod)
+	t.mu.Unlock()
+	// a stopped ticker stands in for verification while it is disabled
+	verifyTicker := time.NewTicker(time.Hour)
+	verifyTicker.Stop()
+	t.mu.Lock()
+	t.verifyTicker = verifyTicker
+	t.mu.Unlock()
+	t.resetVerifyPeriod(viper.GetInt("verify_period"))
+	pruneTicker := time.NewTicker(time.Hour)
+	pruneTicker.Stop()
+	t.mu.Lock()
+	t.pruneTicker = pruneTicker
+	t.mu.Unlock()
+	t.resetPrunePeriod(viper.GetInt("prune_period"))
+	defer func() {
+		t.mu.Lock()
+		t.syncTicker.Stop()
+		t.syncTicker = nil
+		t.verifyTicker.Stop()
+		t.verifyTicker = nil
+		t.pruneTicker.Stop()
+		t.pruneTicker = nil
+		t.mu.Unlock()
+	}()
+
+	log.Logln(t.remote, "verifying local chain...")
+	if _, err := t.Verify(); err != nil {
+		log.Errln(t.remote, "local chain verification failed", err)
+	}
+	t.updateIndex()
+
+	for {
+		select {
+		case <-verifyTicker.C:
+			log.Logln(t.remote, "running periodic local chain verification...")
+			if _, err := t.Verify(); err != nil {
+				log.Errln(t.remote, "local chain verification failed", err)
+			}
+		case <-pruneTicker.C:
+			log.Logln(t.remote, "running periodic local chain pruning...")
+			if _, err := t.Prune(); err != nil {
+				log.Errln(t.remote, "local chain pruning failed", err)
+			}
+		case <-syncTicker.C:
+			log.Logln(t.remote, "running periodic sync...")
+			t.runSync()
+		case <-t.syncRequests:
+			log.Logln(t.remote, "received sync request...")
+			t.runSync()
+		case <-ctx.Done():
+			log.Logln(t.remote, "received termination on chain tracker context")
+			t.failPendingSync(ErrTrackerStopped)
+			return nil
+		}
+	}
+}
+
+// resetTrackingPeriod applies a new tracking period to a running tracker.
+func (t *Tracker) resetTrackingPeriod(trackingPeriod int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.period = time.Millisecond * time.Duration(trackingPeriod)
+	if t.syncTicker != nil {
+		t.syncTicker.Reset(t.interval())
+	}
+}
+
+// resetVerifyPeriod applies a new verification period to a running tracker.
+// A period of zero disables periodic verification.
+func (t *Tracker) resetVerifyPeriod(verifyPeriod int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.verifyTicker == nil {
+		return
+	}
+	if verifyPeriod <= 0 {
+		t.verifyTicker.Stop()
+		return
+	}
+	t.verifyTicker.Reset(time.Millisecond * time.Duration(verifyPeriod))
+}
+
+// recordSync backs off polling while syncs with the remote fail and restores
+// the tracking period once a sync succeeds.
+func (t *Tracker) recordSync(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err == nil {
+		if t.failures > 0 {
+			log.Logln(t.remote, "remote recovered, resuming tracking period")
+		}
+		t.failures = 0
+	} else {
+		t.failures++
+		if errors.Is(err, httpclient.ErrCircuitOpen) {
+			log.Warnln(t.remote, "remote unavailable")
+		}
+	}
+
+	interval := t.interval()
+	var remoteErr *golinks.RemoteError
+	if errors.As(err, &remoteErr) && remoteErr.RetryAfter > interval {
+		interval = remoteErr.RetryAfter
+	}
+	if t.failures > 0 {
+		log.Logln(t.remote, "backing off next sync by", interval)
+	}
+	if t.syncTicker != nil {
+		t.syncTicker.Reset(interval)
+	}
+}
+
+// logRemoteError explains failures that need attention beyond a retry.
+func (t *Tracker) logRemoteError(err error) {
+	switch {
+	case errors.Is(err, golinks.ErrUnauthorized):
+		log.Errln(t.remote, "credentials were rejected by the remote, remove the cached credentials to log in again")
+	case errors.Is(err, golinks.ErrRateLimited):
+		log.Warnln(t.remote, "requests are rate limited by the remote")
+	case errors.Is(err, golinks.ErrMalformedPayload):
+		log.Errln(t.remote, "remote responded with a malformed payload, nothing was written")
+	case errors.Is(err, golinks.ErrServerError):
+		log.Warnln(t.remote, "remote responded with a server error")
+	}
+}
+
+// interval returns the tracking period doubled for every consecutive failed
+// sync, capped at tracking_max_backoff.
+func (t *Tracker) interval() time.Duration {
+	interval := t.period
+	maxBackoff := time.Millisecond * time.Duration(viper.GetInt("tracking_max_backoff"))
+	for i := 0; i < t.failures && interval < maxBackoff; i++ {
+		interval *= 2
+	}
+	if t.failures > 0 && interval > maxBackoff && maxBackoff > t.period {
+		interval = maxBackoff
+	}
+	return interval
+}
+
+// Index returns the index of the local chain, or nil if it is not indexed.
+func (t *Tracker) Index() *chainindex.Index {
+	return t.index
+}
+
+// updateIndex indexes the blocks synced since the last update.
+func (t *Tracker) updateIndex() {
+	if t.index == nil {
+		return
+	}
+	if err := t.index.Update(); err != nil {
+		log.Errln(t.remote, "failed to update local chain index", err)
+	}
+}
+
+// Store returns the local copy of the remote's chain.
+func (t *Tracker) Store() *chainbackend.Filesystem {
+	return t.store
+}
+
+func (t *Tracker) checkAndSync() (SyncResult, error) {
+	result := SyncResult{Remote: t.remote}
+	syncInfo, err := t.getSyncInfo()
+	if errors.Is(err, ErrChainDesync) && t.failingOverNow() {
+		return result, err
+	} else if errors.Is(err, ErrChainDesync) {
+		report, err := t.recoverFork()
+		if err != nil {
+			log.Errln("failed to recover from desync", err)
+			t.logRemoteError(err)
+			return result, err
+		}
+		result.Desync = report
+		t.updateIndex()
+		syncInfo, err = t.getSyncInfo()
+		if err != nil {
+			log.Errln("failed to get sync info:", err)
+			return result, err
+		}
+	} else if err != nil {
+		log.Errln("failed to get sync info:", err)
+		t.logRemoteError(err)
+		return result, err
+	}
+
+	result.LocalLength = syncInfo.LocalLength
+	result.RemoteLength = syncInfo.RemoteLength
+	if syncInfo.NeedsSync {
+		log.Logf("synchronizing local chain (%d) with remote %s (%d)\n", syncInfo.LocalLength, t.remote, syncInfo.RemoteLength)
+		err := t.synchronize(syncInfo)
+		t.updateIndex()
+		if length, lengthErr := t.store.Length(); lengthErr == nil {
+			result.LocalLength = length
+			result.Synced = length - syncInfo.LocalLength
+		}
+		if err != nil {
+			log.Errln("failed to synchronize chain", err)
+			t.logRemoteError(err)
+			return result, err
+		}
+	}
+
+	result.CompletedAt = time.Now()
+	return result, nil
+}
+
+func (t *Tracker) synchronize(syncInfo *SyncInfo) error {
+	progress := t.startProgress(syncInfo)
+	interval := progressInterval()
+	lastReport := time.Now()
+
+	// blocks are appended as they arrive so that a partial sync is kept and
+	// resumed from the local head
+	err := t.fetchBlockRange(syncInfo.LocalLength, syncInfo.RemoteLength-1, func(b *block.Block) error {
+		if err := t.store.Append(b); err != nil {
+			log.Errln("failed to append remote block", b.Index, "to local chain")
+			return err
+		}
+		progress.Synced = b.Index + 1
+		if time.Since(lastReport) >= interval {
+			lastReport = time.Now()
+			t.reportProgress(progress)
+		}
+		return nil
+	})
+
+	progress.Done = err == nil
+	t.reportProgress(progress)
+	return err
+}
+
+// ErrChainDesync indicates the local chain head is out of sync with a remote block of the same index.
+var ErrChainDesync = errors.New("chaintracker: desync in local chain with remote")
+
+func (t *Tracker) getSyncInfo() (*SyncInfo, error) {
+	backend := t.remoteBackend()
+	remoteLength, err := backend.Length()
+	if err != nil {
+		log.Errln("failed to get remote length")
+		return nil, err
+	}
+
+	localLength, err := t.store.Length()
+	if errors.Is(err, chainbackend.ErrChainGap) || errors.Is(err, chainbackend.ErrCorruptRecord) {
+		return nil, ErrChainDesync
+	} else if err != nil {
+		log.Errln("failed to get local chain length")
+		return nil, err
+	}
+
+	localHead, err := t.LocalHead()
+	if err != nil && !errors.Is(err, ErrMissingLocalHead) {
+		log.Errln("failed to read local head")
+		return nil, err
+	}
+
+	if err == nil {
+		// enforce the daemon population by a single remote chain
+		remoteLocalHead, err := backend.GetBlock(localHead.Index)
+		if errors.Is(err, chainbackend.ErrBlockNotFound) {
+			log.Errln("local head", localHead.Index, "not found on remote")
+			return nil, ErrChainDesync
+		} else if err != nil {
+			log.Errln("failed to get local head from remote")
+			return nil, err
+		}
+
+		if !block.Equal(localHead, remoteLocalHead) || (localLength > remoteLength) {
+			log.Errln(ErrChainDesync)
+			return nil, ErrChainDesync
+		}
+	}
+
+	syncInfo := &SyncInfo{
+		RemoteLength: remoteLength,
+		LocalLength:  localLength,
+		NeedsSync:    false,
+	}
+
+	if remoteLength > localLength {
+		syncInfo.NeedsSync = true
+	}
+
+	return syncInfo, nil
+}
+
+var ErrMissingLocalHead = errors.New("chaintracker: missing local head")
+
+func (t *Tracker) LocalHead() (*block.Block, error) {
+	b, err := t.store.Head()
+	if errors.Is(err, chainbackend.ErrEmptyChain) {
+		return nil, ErrMissingLocalHead
+	} else if err != nil {
+		log.Errln("failed to read local head")
+		return nil, err
+	}
+	return b, nil
+}
+
+type SyncInfo struct {
+	NeedsSync    bool
+	LocalLength  int
+	RemoteLength int
+}
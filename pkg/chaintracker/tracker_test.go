@@ -0,0 +1,429 @@
+package chaintracker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/govice/golinks/block"
+	"github.com/govice/golinksd/pkg/chainbackend"
+	"github.com/govice/golinksd/pkg/httpclient"
+	"github.com/spf13/viper"
+)
+
+func TestCheckAndSync(t *testing.T) {
+	dir, err := ioutil.TempDir("", "golinksd-chaintracker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := chainbackend.NewFilesystem(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	genesis := block.NewSHA512Genesis()
+	remote := chainbackend.NewMemory(genesis)
+	head := genesis
+	for i := 1; i < 10; i++ {
+		head = block.NewSHA512(i, []byte("data"), head.BlockHash)
+		if err := remote.Append(head); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tracker := newTracker("test", store, remote)
+	if _, err := tracker.checkAndSync(); err != nil {
+		t.Fatal("expected successful sync.", err)
+	}
+
+	localHead, err := tracker.LocalHead()
+	if err != nil {
+		t.Fatal("expected local head after sync.", err)
+	}
+	if !block.Equal(localHead, head) {
+		t.Error("expected local head to equal remote head. got index", localHead.Index)
+	}
+
+	// a local chain diverging from the remote is replaced
+	diverged := chainbackend.NewMemory(block.NewSHA512Genesis())
+	tracker = newTracker("test", store, diverged)
+	if _, err := tracker.checkAndSync(); err != nil {
+		t.Fatal("expected successful resync.", err)
+	}
+	if length, err := store.Length(); err != nil || length != 1 {
+		t.Error("expected local length 1 after resync. got", length, err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "golinksd-chaintracker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := chainbackend.NewFilesystem(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	head := block.NewSHA512Genesis()
+	if err := store.Append(head); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < 10; i++ {
+		head = block.NewSHA512(i, []byte("data"), head.BlockHash)
+		if i == 5 {
+			head.Data = []byte("tampered")
+		}
+		if err := store.Append(head); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tracker := newTracker("test", store, chainbackend.NewMemory())
+	result, err := tracker.Verify()
+	if err != nil {
+		t.Fatal("expected verification to read the chain.", err)
+	}
+	if result.BadIndex != 5 || !errors.Is(result.Err, ErrTamperedBlock) {
+		t.Error("expected tampered block 5. got", result.BadIndex, result.Err)
+	}
+	if tracker.LastVerification() != result {
+		t.Error("expected last verification to be recorded")
+	}
+}
+
+func TestRecoverFork(t *testing.T) {
+	dir, err := ioutil.TempDir("", "golinksd-chaintracker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := chainbackend.NewFilesystem(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	genesis := block.NewSHA512Genesis()
+	remote := chainbackend.NewMemory(genesis)
+	if err := store.Append(genesis); err != nil {
+		t.Fatal(err)
+	}
+	localHead, remoteHead := genesis, genesis
+	for i := 1; i < 8; i++ {
+		remoteHead = block.NewSHA512(i, []byte("data"), remoteHead.BlockHash)
+		if i < 5 {
+			localHead = remoteHead
+		} else {
+			localHead = block.NewSHA512(i, []byte("fork"), localHead.BlockHash)
+		}
+		if err := store.Append(localHead); err != nil {
+			t.Fatal(err)
+		}
+		if err := remote.Append(remoteHead); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tracker := newTracker("test", store, remote)
+	if _, err := tracker.checkAndSync(); err != nil {
+		t.Fatal("expected successful sync after fork.", err)
+	}
+
+	head, err := tracker.LocalHead()
+	if err != nil || !block.Equal(head, remoteHead) {
+		t.Error("expected local head to equal remote head after fork recovery.", err)
+	}
+
+	report := tracker.LastDesync()
+	if report == nil {
+		t.Fatal("expected desync report")
+	}
+	if report.ForkIndex != 5 || len(report.Blocks) != 3 {
+		t.Error("expected 3 divergent blocks from index 5. got", report.ForkIndex, len(report.Blocks))
+	}
+	for _, name := range []string{"5.json", "6.json", "7.json", desyncReportName} {
+		if _, err := os.Stat(filepath.Join(report.QuarantineDir, name)); err != nil {
+			t.Error("expected quarantined file", name, err)
+		}
+	}
+}
+
+func TestSyncProgress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "golinksd-chaintracker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := chainbackend.NewFilesystem(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	head := block.NewSHA512Genesis()
+	remote := chainbackend.NewMemory(head)
+	for i := 1; i < 10; i++ {
+		head = block.NewSHA512(i, []byte("data"), head.BlockHash)
+		if err := remote.Append(head); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a checkpoint left by a sync interrupted by a restart
+	if err := ioutil.WriteFile(filepath.Join(dir, syncCheckpointName), []byte(`{"target": 10, "synced": 0}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	viper.Set("sync_progress_interval", 0)
+	defer viper.Set("sync_progress_interval", nil)
+
+	tracker := newTracker("test", store, remote)
+	var events []Progress
+	tracker.OnProgress(func(progress Progress) {
+		events = append(events, progress)
+	})
+	if _, err := tracker.checkAndSync(); err != nil {
+		t.Fatal("expected successful sync.", err)
+	}
+
+	if len(events) != 12 {
+		t.Fatal("expected a progress event at the start, per block and at the end. got", len(events))
+	}
+	last := events[len(events)-1]
+	if !last.Done || !last.Resumed || last.Synced != 10 || last.Target != 10 {
+		t.Errorf("expected resumed sync of 10 blocks to be done. got %+v", last)
+	}
+	if _, err := os.Stat(filepath.Join(dir, syncCheckpointName)); !os.IsNotExist(err) {
+		t.Error("expected checkpoint to be removed after sync.", err)
+	}
+}
+
+func TestSync(t *testing.T) {
+	dir, err := ioutil.TempDir("", "golinksd-chaintracker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := chainbackend.NewFilesystem(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	head := block.NewSHA512Genesis()
+	remote := chainbackend.NewMemory(head)
+	for i := 1; i < 5; i++ {
+		head = block.NewSHA512(i, []byte("data"), head.BlockHash)
+		if err := remote.Append(head); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tracker := newTracker("test", store, remote)
+
+	// without a running tracker loop the caller's deadline applies
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := tracker.Sync(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Error("expected deadline to be exceeded. got", err)
+	}
+
+	viper.Set("tracking_period", 60000)
+	defer viper.Set("tracking_period", nil)
+	trackerCtx, stopTracker := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		tracker.Execute(trackerCtx)
+		close(stopped)
+	}()
+
+	results := make(chan error)
+	for i := 0; i < 5; i++ {
+		go func() {
+			result, err := tracker.Sync(context.Background())
+			if err == nil && (result.LocalLength != 5 || result.RemoteLength != 5) {
+				err = fmt.Errorf("expected local and remote length 5. got %d and %d", result.LocalLength, result.RemoteLength)
+			}
+			results <- err
+		}()
+	}
+	for i := 0; i < 5; i++ {
+		if err := <-results; err != nil {
+			t.Error("expected successful sync.", err)
+		}
+	}
+
+	stopTracker()
+	<-stopped
+}
+
+func TestPrune(t *testing.T) {
+	dir, err := ioutil.TempDir("", "golinksd-chaintracker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := chainbackend.NewFilesystem(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	head := block.NewSHA512Genesis()
+	if err := store.Append(head); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < 10; i++ {
+		head = block.NewSHA512(i, []byte("data"), head.BlockHash)
+		if err := store.Append(head); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	viper.Set("retention_blocks", 4)
+	defer viper.Set("retention_blocks", nil)
+
+	tracker := newTracker("test", store, chainbackend.NewMemory())
+	tracker.checkpointKey = []byte("key")
+	pruned, err := tracker.Prune()
+	if err != nil {
+		t.Fatal("expected prune to succeed.", err)
+	}
+	if pruned != 6 || store.Base() != 6 {
+		t.Error("expected 6 blocks pruned before block 6. got", pruned, store.Base())
+	}
+
+	result, err := tracker.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Intact() {
+		t.Error("expected pruned chain to verify. got", result.BadIndex, result.Err)
+	}
+
+	tracker.checkpointKey = []byte("other key")
+	result, err = tracker.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !errors.Is(result.Err, ErrInvalidCheckpoint) {
+		t.Error("expected checkpoint signed with another key to fail. got", result.Err)
+	}
+}
+
+type unavailableBackend struct {
+	chainbackend.ChainBackend
+}
+
+func (b *unavailableBackend) Length() (int, error) {
+	return -1, httpclient.ErrCircuitOpen
+}
+
+type testFailoverSource struct {
+	backends []chainbackend.ChainBackend
+	invalid  []chainbackend.ChainBackend
+}
+
+func (s *testFailoverSource) FailoverBackends() []chainbackend.ChainBackend {
+	return s.backends
+}
+
+func (s *testFailoverSource) ReportInvalidBackend(backend chainbackend.ChainBackend) {
+	s.invalid = append(s.invalid, backend)
+}
+
+func TestFailover(t *testing.T) {
+	dir, err := ioutil.TempDir("", "golinksd-chaintracker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := chainbackend.NewFilesystem(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	genesis := block.NewSHA512Genesis()
+	remote := chainbackend.NewMemory(genesis)
+	peer := chainbackend.NewMemory(genesis)
+	head := genesis
+	for i := 1; i < 10; i++ {
+		head = block.NewSHA512(i, []byte("data"), head.BlockHash)
+		if i < 5 {
+			if err := remote.Append(head); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := peer.Append(head); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tracker := newTracker("test", store, remote)
+	if _, err := tracker.checkAndSync(); err != nil {
+		t.Fatal("expected successful sync.", err)
+	}
+
+	// a peer serving another chain must neither replace the local chain nor
+	// count toward its ban
+	divergedHead := block.NewSHA512Genesis()
+	diverged := chainbackend.NewMemory(divergedHead)
+	for i := 1; i < 6; i++ {
+		divergedHead = block.NewSHA512(i, []byte("other"), divergedHead.BlockHash)
+		if err := diverged.Append(divergedHead); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	primary := &unavailableBackend{remote}
+	source := &testFailoverSource{backends: []chainbackend.ChainBackend{diverged, peer}}
+	tracker = newTracker("test", store, primary)
+	if _, err := tracker.syncWithFailover(); !errors.Is(err, httpclient.ErrCircuitOpen) {
+		t.Error("expected remote error without a failover source. got", err)
+	}
+
+	// the backend is read concurrently while the tracker fails over
+	done := make(chan struct{})
+	readerDone := make(chan struct{})
+	go func() {
+		defer close(readerDone)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				tracker.remoteBackend()
+				tracker.failingOverNow()
+			}
+		}
+	}()
+
+	tracker.SetFailover(source)
+	_, err = tracker.syncWithFailover()
+	close(done)
+	<-readerDone
+	if err != nil {
+		t.Fatal("expected successful sync from failover peer.", err)
+	}
+	localHead, err := tracker.LocalHead()
+	if err != nil || !block.Equal(localHead, head) {
+		t.Error("expected local head to equal the peer's head.", err)
+	}
+	if len(source.invalid) != 0 {
+		t.Error("expected a peer serving another chain not to be reported invalid. got", source.invalid)
+	}
+	if tracker.remoteBackend() != chainbackend.ChainBackend(primary) || tracker.failingOverNow() {
+		t.Error("expected the tracker to return to its remote after failing over")
+	}
+}
@@ -0,0 +1,131 @@
+package chaintracker
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/govice/golinks/block"
+	"github.com/govice/golinksd/pkg/chainbackend"
+	"github.com/govice/golinksd/pkg/log"
+	"github.com/govice/golinksd/pkg/metrics"
+)
+
+var (
+	// ErrTamperedBlock indicates a block whose hash does not match its contents.
+	ErrTamperedBlock = errors.New("chaintracker: block hash does not match its contents")
+	// ErrBrokenLink indicates a block that does not extend the block before it.
+	ErrBrokenLink = errors.New("chaintracker: block does not link to its parent")
+)
+
+// verifyBatchSize is the number of blocks read from the local chain at once
+// while verifying it.
+const verifyBatchSize = 500
+
+// VerifyResult is the outcome of a verification of a local chain.
+type VerifyResult struct {
+	Remote string
+	Length int
+	// BadIndex is the index of the first block that failed verification, or
+	// -1 if the chain is intact.
+	BadIndex   int
+	Err        error
+	VerifiedAt time.Time
+}
+
+// Intact reports whether every block of the chain passed verification.
+func (r *VerifyResult) Intact() bool {
+	return r.BadIndex < 0
+}
+
+// Verify walks the whole local chain and checks every block's hash and its
+// link to the previous block. A pruned chain is walked from its base, and its
+// checkpoint's signature is checked. A failed check raises a tamper alert and
+// is reported in the result; the error is only set when the chain could not
+// be read.
+func (t *Tracker) Verify() (*VerifyResult, error) {
+	length, err := t.store.Length()
+	if err != nil {
+		log.Errln(t.remote, "failed to read local chain length for verification", err)
+		return nil, err
+	}
+
+	result := &VerifyResult{
+		Remote:   t.remote,
+		Length:   length,
+		BadIndex: -1,
+	}
+
+	// a pruned chain is verified from its checkpoint
+	var prev *block.Block
+	base := t.store.Base()
+	if cp := t.store.Checkpoint(); cp != nil {
+		if !cp.Verify(t.checkpointKey) {
+			result.BadIndex = cp.HeadIndex
+			result.Err = ErrInvalidCheckpoint
+		}
+		prev = &block.Block{Index: cp.HeadIndex, BlockHash: cp.HeadHash}
+	}
+	for start := base; start < length && result.Intact(); start += verifyBatchSize {
+		end := start + verifyBatchSize - 1
+		if end >= length {
+			end = length - 1
+		}
+		blocks, err := t.store.GetRange(start, end)
+		if err != nil {
+			log.Errln(t.remote, "failed to read local blocks", start, "to", end, "for verification", err)
+			return nil, err
+		}
+		for i, blk := range blocks {
+			if err := verifyBlock(prev, blk, start+i); err != nil {
+				result.BadIndex = start + i
+				result.Err = err
+				break
+			}
+			prev = blk
+		}
+	}
+	result.VerifiedAt = time.Now()
+
+	t.mu.Lock()
+	t.lastVerify = result
+	t.mu.Unlock()
+
+	if !result.Intact() {
+		metrics.TamperAlerts.Add(metrics.RemoteKey(t.remote), 1)
+		log.Errln("TAMPER ALERT:", t.remote, "local chain failed verification at block", result.BadIndex, result.Err)
+	} else {
+		log.Logln(t.remote, "verified", length, "local blocks")
+	}
+	return result, nil
+}
+
+// LastVerification returns the result of the tracker's latest verification,
+// or nil if the local chain has not been verified yet.
+func (t *Tracker) LastVerification() *VerifyResult {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.lastVerify
+}
+
+// verifyBlock checks that blk sits at index, that its hash matches its
+// contents and that it extends prev.
+func verifyBlock(prev, blk *block.Block, index int) error {
+	if blk.Index != index {
+		return fmt.Errorf("%w: found index %d", ErrBrokenLink, blk.Index)
+	}
+	if !chainbackend.VerifyHash(blk) {
+		return ErrTamperedBlock
+	}
+	if prev == nil {
+		if len(blk.ParentHash) != 0 {
+			return fmt.Errorf("%w: genesis block has a parent", ErrBrokenLink)
+		}
+		return nil
+	}
+	if !bytes.Equal(blk.ParentHash, prev.BlockHash) {
+		return ErrBrokenLink
+	}
+	return nil
+}
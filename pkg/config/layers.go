@@ -0,0 +1,183 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/govice/golinksd/pkg/log"
+	"github.com/spf13/viper"
+)
+
+// SystemDir holds the system wide config file and drop-in directories.
+const SystemDir = "/etc/golinksd"
+
+// configTypes lists the supported config file extensions in lookup order.
+var configTypes = []string{"json", "yaml", "yml", "toml"}
+
+// configLayers returns the config files that exist, ordered from lowest to
+// highest precedence: the system config, the user config and the file named
+// by --config. Environment variables take precedence over every file.
+func (cs *Service) configLayers() ([]string, error) {
+	var layers []string
+	for _, dir := range []string{SystemDir, cs.HomeDir()} {
+		if path := findConfigFile(dir); path != "" {
+			layers = append(layers, path)
+		}
+	}
+
+	if explicit := viper.GetString("config"); explicit != "" {
+		if _, err := os.Stat(explicit); err != nil {
+			log.Errln("failed to find config file", explicit)
+			return nil, err
+		}
+		layers = append(layers, explicit)
+	}
+
+	return layers, nil
+}
+
+// findConfigFile returns the first config file found in dir.
+func findConfigFile(dir string) string {
+	var found []string
+	for _, ext := range configTypes {
+		path := filepath.Join(dir, "config."+ext)
+		if fi, err := os.Stat(path); err == nil && !fi.IsDir() {
+			found = append(found, path)
+		}
+	}
+
+	if len(found) == 0 {
+		return ""
+	}
+	if len(found) > 1 {
+		log.Warnln("found several config files in", dir, "using", found[0])
+	}
+	return found[0]
+}
+
+// readLayers replaces the settings read from config files with the merged
+// content of layers, and returns the merged settings. Nested settings are
+// merged key by key. Every layer is parsed before the settings in effect are
+// replaced.
+func readLayers(layers []string) (map[string]interface{}, error) {
+	merged, err := mergeLayers(layers)
+	if err != nil {
+		return nil, err
+	}
+	if err := setConfig(merged); err != nil {
+		return nil, err
+	}
+	return merged, nil
+}
+
+// mergeLayers parses layers and merges their settings.
+func mergeLayers(layers []string) (map[string]interface{}, error) {
+	merged := make(map[string]interface{})
+	for _, path := range layers {
+		v := viper.New()
+		v.SetConfigFile(path)
+		if err := v.ReadInConfig(); err != nil {
+			log.Errln("failed to read config file", path)
+			return nil, err
+		}
+		mergeSettings(merged, v.AllSettings())
+	}
+	return merged, nil
+}
+
+// setConfig replaces the settings read from config files with config.
+func setConfig(config map[string]interface{}) error {
+	configBytes, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+
+	viper.SetConfigType("json")
+	return viper.ReadConfig(bytes.NewReader(configBytes))
+}
+
+func mergeSettings(dst, src map[string]interface{}) {
+	for key, value := range src {
+		srcMap, srcOk := value.(map[string]interface{})
+		dstMap, dstOk := dst[key].(map[string]interface{})
+		if srcOk && dstOk {
+			mergeSettings(dstMap, srcMap)
+			continue
+		}
+		dst[key] = value
+	}
+}
+
+// lookupSetting returns the value of the dotted key in settings.
+func lookupSetting(settings map[string]interface{}, key string) (interface{}, bool) {
+	parts := strings.Split(key, ".")
+	for _, part := range parts[:len(parts)-1] {
+		nested, ok := settings[part].(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		settings = nested
+	}
+	value, ok := settings[parts[len(parts)-1]]
+	return value, ok
+}
+
+// restoreSetting sets the dotted key in dst to its value in src, or removes
+// it from dst if src does not hold it.
+func restoreSetting(dst, src map[string]interface{}, key string) {
+	value, ok := lookupSetting(src, key)
+	parts := strings.Split(key, ".")
+	for _, part := range parts[:len(parts)-1] {
+		nested, isMap := dst[part].(map[string]interface{})
+		if !isMap {
+			if !ok {
+				return
+			}
+			nested = make(map[string]interface{})
+			dst[part] = nested
+		}
+		dst = nested
+	}
+	if ok {
+		dst[parts[len(parts)-1]] = value
+	} else {
+		delete(dst, parts[len(parts)-1])
+	}
+}
+
+// changedSettings returns the dotted keys of the leaf settings that differ
+// between a and b, sorted.
+func changedSettings(a, b map[string]interface{}) []string {
+	leavesA, leavesB := make(map[string]interface{}), make(map[string]interface{})
+	flattenSettings(leavesA, "", a)
+	flattenSettings(leavesB, "", b)
+
+	var changed []string
+	for key, value := range leavesA {
+		if other, ok := leavesB[key]; !ok || !reflect.DeepEqual(value, other) {
+			changed = append(changed, key)
+		}
+	}
+	for key := range leavesB {
+		if _, ok := leavesA[key]; !ok {
+			changed = append(changed, key)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+func flattenSettings(dst map[string]interface{}, prefix string, settings map[string]interface{}) {
+	for key, value := range settings {
+		if nested, ok := value.(map[string]interface{}); ok {
+			flattenSettings(dst, prefix+key+".", nested)
+			continue
+		}
+		dst[prefix+key] = value
+	}
+}
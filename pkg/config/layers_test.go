@@ -0,0 +1,53 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestReadLayers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "golinksd-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	system := filepath.Join(dir, "system.yaml")
+	user := filepath.Join(dir, "user.toml")
+	explicit := filepath.Join(dir, "explicit.json")
+
+	layers := map[string]string{
+		system:   "port: 9000\ntracking_period: 5000\nremotes:\n  compliance:\n    chain_length_endpoint: https://system/length\n",
+		user:     "port = 9100\n[remotes.compliance]\nchain_block_endpoint = \"https://user/block\"\n",
+		explicit: `{"tracking_period": 7000}`,
+	}
+	for path, content := range layers {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := readLayers([]string{system, user, explicit}); err != nil {
+		t.Fatal("expected successful read of layers.", err)
+	}
+
+	if port := viper.GetInt("port"); port != 9100 {
+		t.Error("expected user port 9100. got", port)
+	}
+
+	if period := viper.GetInt("tracking_period"); period != 7000 {
+		t.Error("expected explicit tracking period 7000. got", period)
+	}
+
+	if endpoint := viper.GetString("remotes.compliance.chain_length_endpoint"); endpoint != "https://system/length" {
+		t.Error("expected system length endpoint. got", endpoint)
+	}
+
+	if endpoint := viper.GetString("remotes.compliance.chain_block_endpoint"); endpoint != "https://user/block" {
+		t.Error("expected user block endpoint. got", endpoint)
+	}
+}
@@ -0,0 +1,268 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+// Backends a remote chain can be served by.
+const (
+	BackendHTTP       = "http"
+	BackendFilesystem = "filesystem"
+	BackendMemory     = "memory"
+	// BackendAuthority is the chain the daemon serves itself in authority
+	// mode.
+	BackendAuthority = "authority"
+)
+
+// DefaultRemote is the name of the remote configured through the top level
+// authorization_endpoint, chain_length_endpoint and chain_block_endpoint settings.
+const DefaultRemote = "default"
+
+// Remote describes a remote chain along with the credentials and local chain
+// directory used to track it.
+type Remote struct {
+	name     string
+	mu       sync.RWMutex
+	settings remoteSettings
+	token    *JWT
+}
+
+type remoteSettings struct {
+	AuthorizationEndpoint string   `mapstructure:"authorization_endpoint"`
+	ChainLengthEndpoint   string   `mapstructure:"chain_length_endpoint"`
+	ChainBlockEndpoint    string   `mapstructure:"chain_block_endpoint"`
+	ChainRangeEndpoint    string   `mapstructure:"chain_range_endpoint"`
+	BlobEndpoint          string   `mapstructure:"blob_endpoint"`
+	ChainDir              string   `mapstructure:"chain_dir"`
+	Backend               string   `mapstructure:"backend"`
+	BackendPath           string   `mapstructure:"backend_path"`
+	MirrorPeers           []string `mapstructure:"mirror_peers"`
+}
+
+func (r *Remote) Name() string {
+	return r.name
+}
+
+func (r *Remote) AuthorizationEndpoint() string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.settings.AuthorizationEndpoint
+}
+
+func (r *Remote) ChainLengthEndpoint() string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.settings.ChainLengthEndpoint
+}
+
+func (r *Remote) ChainBlockEndpoint() string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.settings.ChainBlockEndpoint
+}
+
+// ChainRangeEndpoint returns the optional endpoint serving ranges of blocks.
+func (r *Remote) ChainRangeEndpoint() string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.settings.ChainRangeEndpoint
+}
+
+// BlobEndpoint returns the optional endpoint storing blockmap blobs.
+func (r *Remote) BlobEndpoint() string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.settings.BlobEndpoint
+}
+
+// ChainDir is the directory the remote's chain is synchronized to.
+func (r *Remote) ChainDir() string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.settings.ChainDir
+}
+
+// Backend returns the kind of backend serving the remote chain.
+func (r *Remote) Backend() string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.settings.Backend == "" {
+		return BackendHTTP
+	}
+	return r.settings.Backend
+}
+
+// BackendPath is the directory of a filesystem backend.
+func (r *Remote) BackendPath() string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.settings.BackendPath
+}
+
+// MirrorPeers returns the addresses of the peers serving a copy of the
+// remote's chain, which its tracker may fail over to.
+func (r *Remote) MirrorPeers() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]string{}, r.settings.MirrorPeers...)
+}
+
+func (r *Remote) Token() string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.token == nil {
+		return ""
+	}
+	return r.token.Token
+}
+
+// setEndpoint swaps one of the remote's endpoints at runtime.
+func (r *Remote) setEndpoint(setting, endpoint string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch setting {
+	case "authorization_endpoint":
+		r.settings.AuthorizationEndpoint = endpoint
+	case "chain_length_endpoint":
+		r.settings.ChainLengthEndpoint = endpoint
+	case "chain_block_endpoint":
+		r.settings.ChainBlockEndpoint = endpoint
+	case "chain_range_endpoint":
+		r.settings.ChainRangeEndpoint = endpoint
+	case "blob_endpoint":
+		r.settings.BlobEndpoint = endpoint
+	default:
+		return ErrRestartRequired
+	}
+	return nil
+}
+
+// credentialsFile returns the name of the file the remote's token is cached in.
+func (r *Remote) credentialsFile() string {
+	if r.name == DefaultRemote {
+		return "credentials.json"
+	}
+	return "credentials." + r.name + ".json"
+}
+
+// envPrefix returns the prefix of the environment variables holding the
+// remote's login credentials. Characters a shell variable name cannot hold are
+// mapped to underscores.
+func (r *Remote) envPrefix() string {
+	if r.name == DefaultRemote {
+		return "GOLINKSD_"
+	}
+	name := strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, r.name)
+	return "GOLINKSD_" + strings.ToUpper(name) + "_"
+}
+
+var ErrUnknownRemote = errors.New("config: unknown remote")
+
+// loadRemotes builds the remote definitions from the top level endpoint
+// settings and the named entries of the remotes setting.
+func (cs *Service) loadRemotes() error {
+	named := map[string]remoteSettings{}
+	if err := viper.UnmarshalKey("remotes", &named); err != nil {
+		return err
+	}
+
+	remotes := make(map[string]*Remote)
+	for name, settings := range named {
+		if name == DefaultRemote {
+			return errors.New("config: remote name " + DefaultRemote + " is reserved")
+		}
+		if settings.ChainDir == "" {
+			settings.ChainDir = filepath.Join(cs.HomeDir(), "chains", name)
+		}
+		remotes[name] = &Remote{name: name, settings: settings}
+	}
+
+	// the default remote is kept for configurations predating named remotes
+	if viper.IsSet("chain_length_endpoint") || viper.IsSet("backend") || viper.GetBool("genesis") || len(remotes) == 0 {
+		remotes[DefaultRemote] = &Remote{
+			name: DefaultRemote,
+			settings: remoteSettings{
+				AuthorizationEndpoint: viper.GetString("authorization_endpoint"),
+				ChainLengthEndpoint:   viper.GetString("chain_length_endpoint"),
+				ChainBlockEndpoint:    viper.GetString("chain_block_endpoint"),
+				ChainRangeEndpoint:    viper.GetString("chain_range_endpoint"),
+				BlobEndpoint:          viper.GetString("blob_endpoint"),
+				ChainDir:              filepath.Join(cs.HomeDir(), "chain"),
+				Backend:               viper.GetString("backend"),
+				BackendPath:           viper.GetString("backend_path"),
+				MirrorPeers:           viper.GetStringSlice("mirror_peers"),
+			},
+		}
+		// in authority mode the default remote is the daemon's own chain
+		if viper.GetBool("genesis") {
+			remotes[DefaultRemote].settings.Backend = BackendAuthority
+		}
+	}
+
+	for name, remote := range remotes {
+		switch remote.Backend() {
+		case BackendHTTP, BackendMemory:
+		case BackendAuthority:
+			if name != DefaultRemote || !viper.GetBool("genesis") {
+				return errors.New("config: the authority backend requires genesis and is reserved for the default remote")
+			}
+		case BackendFilesystem:
+			if remote.settings.BackendPath == "" {
+				remote.settings.BackendPath = filepath.Join(cs.HomeDir(), "backends", name)
+			}
+		default:
+			return errors.New("config: unknown backend " + remote.Backend() + " for remote " + name)
+		}
+		os.MkdirAll(remote.ChainDir(), os.ModePerm)
+	}
+
+	cs.remotes = remotes
+	return nil
+}
+
+// NewMemory returns a config service without a config file, holding memory
+// backend remotes with the given names.
+func NewMemory(names ...string) *Service {
+	remotes := make(map[string]*Remote)
+	for _, name := range names {
+		remotes[name] = &Remote{name: name, settings: remoteSettings{Backend: BackendMemory}}
+	}
+	return &Service{remotes: remotes}
+}
+
+// Remote returns the remote with the given name. An empty name refers to the
+// default remote.
+func (cs *Service) Remote(name string) (*Remote, error) {
+	if name == "" {
+		name = DefaultRemote
+	}
+	remote, ok := cs.remotes[name]
+	if !ok {
+		return nil, ErrUnknownRemote
+	}
+	return remote, nil
+}
+
+// Remotes returns every configured remote sorted by name.
+func (cs *Service) Remotes() []*Remote {
+	var remotes []*Remote
+	for _, remote := range cs.remotes {
+		remotes = append(remotes, remote)
+	}
+	sort.Slice(remotes, func(i, j int) bool {
+		return remotes[i].name < remotes[j].name
+	})
+	return remotes
+}
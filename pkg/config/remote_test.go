@@ -0,0 +1,15 @@
+package config
+
+import "testing"
+
+func TestEnvPrefix(t *testing.T) {
+	for name, prefix := range map[string]string{
+		DefaultRemote:     "GOLINKSD_",
+		"compliance":      "GOLINKSD_COMPLIANCE_",
+		"eu-west.backup2": "GOLINKSD_EU_WEST_BACKUP2_",
+	} {
+		if got := (&Remote{name: name}).envPrefix(); got != prefix {
+			t.Error("expected prefix", prefix, "for remote", name, "got", got)
+		}
+	}
+}
@@ -8,15 +8,25 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 
 	"github.com/gin-gonic/gin"
+	"github.com/govice/golinksd/pkg/atomicfile"
+	"github.com/govice/golinksd/pkg/httpclient"
 	"github.com/govice/golinksd/pkg/log"
 	"github.com/manifoldco/promptui"
 	"github.com/spf13/viper"
 )
 
 type Service struct {
-	token *JWT
+	client         *httpclient.Client
+	remotes        map[string]*Remote
+	layers         []string
+	watchMu        sync.Mutex
+	config         map[string]interface{} // merged config file settings in effect
+	settings       map[string]interface{}
+	changeHandlers map[string][]func() error
 }
 
 type JWT struct {
@@ -29,10 +39,27 @@ func New() (*Service, error) {
 		return nil, err
 	}
 
-	if err := cs.checkLogin(); err != nil {
+	if err := cs.loadRemotes(); err != nil {
 		return nil, err
 	}
 
+	client, err := httpclient.New(httpclient.OptionsFromConfig())
+	if err != nil {
+		return nil, err
+	}
+	cs.client = client
+
+	for _, remote := range cs.Remotes() {
+		if remote.Backend() != BackendHTTP {
+			continue
+		}
+		if err := cs.checkLogin(remote); err != nil {
+			log.Errln("failed to login to remote", remote.Name())
+			return nil, err
+		}
+	}
+
+	cs.watchConfig()
 	return cs, nil
 }
 
@@ -41,40 +68,98 @@ func (cs *Service) setupConfig() error {
 
 	os.Mkdir(daemonHome, os.ModePerm)
 
-	viper.SetConfigName("config")
-	viper.SetConfigType("json")
 	viper.SetEnvPrefix("golinksd")
 	viper.AutomaticEnv()
 	viper.SetDefault("peer_port", 7777)
+	viper.SetDefault("gossip", false)
+	viper.SetDefault("peers", []string{})
+	viper.SetDefault("peer_address", "")
+	viper.SetDefault("gossip_period", 30000)
+	viper.SetDefault("peer_probe_period", 10000)
+	viper.SetDefault("peer_ban_threshold", 3)
+	viper.SetDefault("peer_ban_period", 3600000)
+	viper.SetDefault("peer_failover", false)
+	viper.SetDefault("announced_peer_limit", 64)
+	viper.SetDefault("announced_peer_expiry", 600000)
 	viper.SetDefault("auth_server", "https://govice.org")
 	viper.SetDefault("port", 8080)
 	viper.SetDefault("genesis", false)
+	viper.SetDefault("authority_dir", filepath.Join(daemonHome, "authority"))
+	viper.SetDefault("authority_token", "")
 	viper.SetDefault("delay_startup", 0)
 	viper.SetDefault("templates_home", "./templates")
 	viper.SetDefault("tracking_period", 30000)
 	viper.SetDefault("concurrent_task_limit", 3)
-
-	viper.AddConfigPath(daemonHome)
+	viper.SetDefault("workers_dir", filepath.Join(SystemDir, "workers.d"))
+	viper.SetDefault("workers_reconcile_period", 30000)
+	viper.SetDefault("tracking_max_backoff", 600000)
+	viper.SetDefault("verify_period", 3600000)
+	viper.SetDefault("retention_blocks", 0)
+	viper.SetDefault("retention_period", 0)
+	viper.SetDefault("prune_period", 86400000)
+	viper.SetDefault("checkpoint_key_file", filepath.Join(daemonHome, "checkpoint.key"))
+	viper.SetDefault("sync_batch_size", 100)
+	viper.SetDefault("sync_parallelism", 4)
+	viper.SetDefault("sync_progress_interval", 5000)
+	viper.SetDefault("start_workers_before_sync", false)
+	viper.SetDefault("append_max_retries", 3)
+	viper.SetDefault("chain_compression", true)
+	viper.SetDefault("blobs_dir", filepath.Join(daemonHome, "blobs"))
+	viper.SetDefault("blockmap_storage", "blob")
+	viper.SetDefault("http_compression", "gzip")
+	viper.SetDefault("http_connect_timeout", 5000)
+	viper.SetDefault("http_timeout", 30000)
+	viper.SetDefault("http_max_retries", 3)
+	viper.SetDefault("http_retry_base_delay", 500)
+	viper.SetDefault("http_retry_max_delay", 10000)
+	viper.SetDefault("http_breaker_threshold", 5)
+	viper.SetDefault("http_breaker_cooldown", 60000)
+	viper.SetDefault("tls_ca_file", "")
+	viper.SetDefault("tls_cert_file", "")
+	viper.SetDefault("tls_key_file", "")
+	viper.SetDefault("tls_pins", []string{})
+	viper.SetDefault("https_proxy", "")
+	viper.SetDefault("no_proxy", "")
 
 	log.Logln("reading config")
 
-	err := viper.ReadInConfig()
-	if _, ok := err.(viper.ConfigFileNotFoundError); ok {
+	layers, err := cs.configLayers()
+	if err != nil {
+		return err
+	}
+
+	if len(layers) == 0 {
 		configFile := filepath.Join(daemonHome, "config.json")
 		if _, err := os.Create(configFile); err != nil {
 			return err
 		}
 		log.Logln("creating new config file")
-		if err := viper.WriteConfig(); err != nil {
+		if err := viper.WriteConfigAs(configFile); err != nil {
 			log.Logln("failed to write new config file")
 			return err
 		}
+		layers = append(layers, configFile)
+	}
+
+	config, err := readLayers(layers)
+	if err != nil {
+		return err
 	}
+	log.Logln("config files:", strings.Join(layers, ", "))
+	cs.layers = layers
+	cs.config = config
 
-	viper.WatchConfig()
 	return nil
 }
 
+// ConfigFiles returns the config files in effect, ordered from lowest to
+// highest precedence.
+func (cs *Service) ConfigFiles() []string {
+	cs.watchMu.Lock()
+	defer cs.watchMu.Unlock()
+	return append([]string{}, cs.layers...)
+}
+
 func (cs *Service) HomeDir() string {
 	homeDir, _ := os.UserHomeDir()
 	return filepath.Join(homeDir, ".golinksd")
@@ -82,22 +167,22 @@ func (cs *Service) HomeDir() string {
 
 var ErrNotAuthorized = errors.New("Not Authorized.")
 
-func (cs *Service) checkLogin() error {
-	tokenPath := filepath.Join(cs.HomeDir(), "credentials.json")
+func (cs *Service) checkLogin(remote *Remote) error {
+	tokenPath := filepath.Join(cs.HomeDir(), remote.credentialsFile())
 	if _, err := os.Stat(tokenPath); errors.Is(err, os.ErrNotExist) {
-		email, eok := os.LookupEnv("GOLINKSD_USER")
-		password, pok := os.LookupEnv("GOLINKSD_PASSWORD")
+		email, eok := os.LookupEnv(remote.envPrefix() + "USER")
+		password, pok := os.LookupEnv(remote.envPrefix() + "PASSWORD")
 		var token *JWT
 		//validate environment defined credentials
 		if eok && pok {
-			token, err = cs.authenticate(email, password)
+			token, err = cs.authenticate(remote.AuthorizationEndpoint(), email, password)
 			if err != nil {
 				log.Errln(err)
 				return ErrNotAuthorized
 			}
 
 		} else {
-			token, err = cs.promptLogin()
+			token, err = cs.promptLogin(remote)
 			if err != nil {
 				log.Errln("failed to prompt login:", err)
 				return ErrNotAuthorized
@@ -109,11 +194,11 @@ func (cs *Service) checkLogin() error {
 			return err
 		}
 
-		if err := ioutil.WriteFile(tokenPath, tokenBytes, os.ModePerm); err != nil {
+		if err := atomicfile.WriteFile(tokenPath, tokenBytes, 0600); err != nil {
 			log.Errln("failed to write credentials file:", err)
 			return err
 		}
-		cs.token = token
+		remote.token = token
 	} else {
 		tokenBytes, err := ioutil.ReadFile(tokenPath)
 		if err != nil {
@@ -123,20 +208,27 @@ func (cs *Service) checkLogin() error {
 
 		token := &JWT{}
 		if err := json.Unmarshal(tokenBytes, token); err != nil {
-			log.Errln("failed to unmarshal token", err)
-			return err
+			log.Errln("credentials file", tokenPath, "is unreadable, logging in again:", err)
+			if _, err := atomicfile.Quarantine(tokenPath); err != nil {
+				return err
+			}
+			return cs.checkLogin(remote)
 		}
-		cs.token = token
+		remote.token = token
 	}
 	return nil
 }
 
-func (cs *Service) promptLogin() (*JWT, error) {
+func (cs *Service) promptLogin(remote *Remote) (*JWT, error) {
+	labelPrefix := ""
+	if remote.Name() != DefaultRemote {
+		labelPrefix = remote.Name() + " "
+	}
 	promptEmail := promptui.Prompt{
-		Label: "Email",
+		Label: labelPrefix + "Email",
 	}
 	promptPassword := promptui.Prompt{
-		Label: "Password",
+		Label: labelPrefix + "Password",
 		Mask:  '*',
 	}
 
@@ -152,12 +244,12 @@ func (cs *Service) promptLogin() (*JWT, error) {
 		return nil, err
 	}
 
-	return cs.authenticate(email, password)
+	return cs.authenticate(remote.AuthorizationEndpoint(), email, password)
 }
 
 var ErrFailedAuthentication = errors.New(("failed to authenticate"))
 
-func (cs *Service) authenticate(email, password string) (*JWT, error) {
+func (cs *Service) authenticate(endpoint, email, password string) (*JWT, error) {
 	loginPayload, err := json.Marshal(gin.H{
 		"email":    email,
 		"password": password,
@@ -165,12 +257,12 @@ func (cs *Service) authenticate(email, password string) (*JWT, error) {
 	if err != nil {
 		return nil, err
 	}
-	req, err := http.NewRequest("POST", viper.GetString("authorization_endpoint"), bytes.NewBuffer(loginPayload))
+	req, err := http.NewRequest("POST", endpoint, bytes.NewBuffer(loginPayload))
 	if err != nil {
 		return nil, err
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := cs.client.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -194,6 +286,11 @@ func (cs *Service) authenticate(email, password string) (*JWT, error) {
 	return token, nil
 }
 
+// Token returns the token of the default remote.
 func (cs *Service) Token() string {
-	return cs.token.Token
+	remote, err := cs.Remote(DefaultRemote)
+	if err != nil {
+		return ""
+	}
+	return remote.Token()
 }
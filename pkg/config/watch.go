@@ -0,0 +1,297 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/govice/golinksd/pkg/log"
+	"github.com/spf13/viper"
+)
+
+// restartRequired lists the settings that are only read during startup.
+var restartRequired = map[string]bool{
+	"port":                      true,
+	"peer_port":                 true,
+	"gossip":                    true,
+	"peers":                     true,
+	"peer_address":              true,
+	"peer_failover":             true,
+	"mirror_peers":              true,
+	"genesis":                   true,
+	"authority_dir":             true,
+	"authority_token":           true,
+	"delay_startup":             true,
+	"templates_home":            true,
+	"development":               true,
+	"backend":                   true,
+	"backend_path":              true,
+	"chain_compression":         true,
+	"blobs_dir":                 true,
+	"checkpoint_key_file":       true,
+	"http_compression":          true,
+	"workers_dir":               true,
+	"workers_reconcile_period":  true,
+	"start_workers_before_sync": true,
+	"http_connect_timeout":      true,
+	"http_timeout":              true,
+	"http_max_retries":          true,
+	"http_retry_base_delay":     true,
+	"http_retry_max_delay":      true,
+	"http_breaker_threshold":    true,
+	"http_breaker_cooldown":     true,
+	"tls_ca_file":               true,
+	"tls_cert_file":             true,
+	"tls_key_file":              true,
+	"tls_pins":                  true,
+	"https_proxy":               true,
+	"no_proxy":                  true,
+}
+
+// ErrRestartRequired rejects a runtime change of a setting that is only read
+// during startup.
+var ErrRestartRequired = errors.New("config: setting requires a restart")
+
+// OnChange registers handler to apply runtime changes of key. The new value is
+// available through viper when handler is called. Returning an error rejects
+// the change, and the previous value is restored in viper.
+func (cs *Service) OnChange(key string, handler func() error) {
+	cs.watchMu.Lock()
+	defer cs.watchMu.Unlock()
+	if cs.changeHandlers == nil {
+		cs.changeHandlers = make(map[string][]func() error)
+	}
+	cs.changeHandlers[key] = append(cs.changeHandlers[key], handler)
+}
+
+func (cs *Service) watchConfig() {
+	cs.watchMu.Lock()
+	cs.settings = settingsSnapshot()
+	cs.watchMu.Unlock()
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Errln("failed to watch config files", err)
+		return
+	}
+
+	for _, dir := range cs.watchedDirs() {
+		if err := watcher.Add(dir); err != nil {
+			log.Warnln("failed to watch config directory", dir, err)
+		}
+	}
+
+	go func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if !cs.isConfigFile(event.Name) {
+					continue
+				}
+				if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Remove|fsnotify.Rename) == 0 {
+					continue
+				}
+				log.Logln("config file changed:", event.Name)
+				cs.reload()
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Errln("config watcher failed", err)
+			}
+		}
+	}()
+}
+
+// watchedDirs returns the directories config layers are looked up in.
+func (cs *Service) watchedDirs() []string {
+	dirs := []string{cs.HomeDir()}
+	if fi, err := os.Stat(SystemDir); err == nil && fi.IsDir() {
+		dirs = append(dirs, SystemDir)
+	}
+	if explicit := viper.GetString("config"); explicit != "" {
+		dirs = append(dirs, filepath.Dir(explicit))
+	}
+	return dirs
+}
+
+func (cs *Service) isConfigFile(name string) bool {
+	name = filepath.Clean(name)
+	if explicit := viper.GetString("config"); explicit != "" && name == filepath.Clean(explicit) {
+		return true
+	}
+
+	dir := filepath.Dir(name)
+	if dir != SystemDir && dir != cs.HomeDir() {
+		return false
+	}
+	for _, ext := range configTypes {
+		if filepath.Base(name) == "config."+ext {
+			return true
+		}
+	}
+	return false
+}
+
+// reload rereads every config layer and applies the resulting changes. A
+// layer that fails to parse leaves the settings in effect untouched. Changes
+// of settings requiring a restart never reach viper, and rejected changes are
+// reverted.
+func (cs *Service) reload() {
+	layers, err := cs.configLayers()
+	if err != nil {
+		log.Errln("failed to reload config", err)
+		return
+	}
+	config, err := mergeLayers(layers)
+	if err != nil {
+		log.Errln("failed to reload config", err)
+		return
+	}
+
+	cs.watchMu.Lock()
+	defer cs.watchMu.Unlock()
+	previous := cs.config
+	for _, key := range changedSettings(previous, config) {
+		if cs.requiresRestart(key) {
+			oldValue, _ := lookupSetting(previous, key)
+			newValue, _ := lookupSetting(config, key)
+			log.Errln(fmt.Sprintf("rejected change of %s from %v to %v:", key, oldValue, newValue), ErrRestartRequired)
+			restoreSetting(config, previous, key)
+		}
+	}
+	if err := setConfig(config); err != nil {
+		log.Errln("failed to reload config", err)
+		return
+	}
+	cs.layers = layers
+	cs.config = config
+
+	rejected := cs.applyChanges()
+	if len(rejected) == 0 {
+		return
+	}
+	for _, key := range rejected {
+		restoreSetting(config, previous, key)
+	}
+	if err := setConfig(config); err != nil {
+		log.Errln("failed to revert rejected config changes", err)
+	}
+}
+
+// applyChanges compares the current settings with the ones in effect and
+// applies or rejects every setting that differs. It returns the rejected
+// settings, which the caller reverts. The caller holds cs.watchMu.
+func (cs *Service) applyChanges() []string {
+	current := settingsSnapshot()
+	keys := make(map[string]bool)
+	for key := range current {
+		keys[key] = true
+	}
+	for key := range cs.settings {
+		keys[key] = true
+	}
+
+	var sortedKeys []string
+	for key := range keys {
+		sortedKeys = append(sortedKeys, key)
+	}
+	sort.Strings(sortedKeys)
+
+	var rejected []string
+	for _, key := range sortedKeys {
+		oldValue, newValue := cs.settings[key], current[key]
+		if reflect.DeepEqual(oldValue, newValue) {
+			continue
+		}
+
+		if err := cs.applyChange(key); err != nil {
+			log.Errln(fmt.Sprintf("rejected change of %s from %v to %v:", key, oldValue, newValue), err)
+			rejected = append(rejected, key)
+			// keep comparing against the value in effect
+			if oldValue == nil {
+				delete(current, key)
+			} else {
+				current[key] = oldValue
+			}
+			continue
+		}
+		log.Logf("applied change of %s from %v to %v\n", key, oldValue, newValue)
+	}
+
+	cs.settings = current
+	return rejected
+}
+
+func (cs *Service) applyChange(key string) error {
+	if cs.requiresRestart(key) {
+		return ErrRestartRequired
+	}
+
+	if remote, setting, ok := cs.remoteSetting(key); ok {
+		if err := remote.setEndpoint(setting, viper.GetString(key)); err != nil {
+			return err
+		}
+	}
+
+	for _, handler := range cs.changeHandlers[key] {
+		if err := handler(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// requiresRestart reports whether key is only read during startup.
+func (cs *Service) requiresRestart(key string) bool {
+	if restartRequired[key] {
+		return true
+	}
+	remote, setting, ok := cs.remoteSetting(key)
+	if !ok {
+		return false
+	}
+	if remote == nil {
+		return true
+	}
+	switch setting {
+	case "authorization_endpoint", "chain_length_endpoint", "chain_block_endpoint", "chain_range_endpoint", "blob_endpoint":
+		return false
+	}
+	return true
+}
+
+// remoteSetting resolves a key describing a remote to the remote and the
+// remote's setting. The returned remote is nil when the remote was not
+// configured during startup.
+func (cs *Service) remoteSetting(key string) (*Remote, string, bool) {
+	switch key {
+	case "authorization_endpoint", "chain_length_endpoint", "chain_block_endpoint", "chain_range_endpoint", "blob_endpoint":
+		return cs.remotes[DefaultRemote], key, true
+	}
+
+	parts := strings.SplitN(key, ".", 3)
+	if len(parts) < 2 || parts[0] != "remotes" {
+		return nil, "", false
+	}
+	if len(parts) == 2 {
+		return nil, "", true
+	}
+	return cs.remotes[parts[1]], parts[2], true
+}
+
+func settingsSnapshot() map[string]interface{} {
+	settings := make(map[string]interface{})
+	for _, key := range viper.AllKeys() {
+		settings[key] = viper.Get(key)
+	}
+	return settings
+}
@@ -0,0 +1,85 @@
+package config
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "golinksd-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// keep the user's own config files out of the layers
+	home := os.Getenv("HOME")
+	os.Setenv("HOME", dir)
+	defer os.Setenv("HOME", home)
+
+	explicit := filepath.Join(dir, "explicit.json")
+	write := func(content string) {
+		if err := ioutil.WriteFile(explicit, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`{"tracking_period": 5000, "authority_token": "secret"}`)
+	viper.Set("config", explicit)
+	defer viper.Set("config", nil)
+	defer setConfig(map[string]interface{}{})
+
+	cs := &Service{remotes: map[string]*Remote{}}
+	layers, err := cs.configLayers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cs.config, err = readLayers(layers); err != nil {
+		t.Fatal(err)
+	}
+	cs.settings = settingsSnapshot()
+
+	var applied []int
+	cs.OnChange("tracking_period", func() error {
+		period := viper.GetInt("tracking_period")
+		if period <= 0 {
+			return errors.New("tracking period must be positive")
+		}
+		applied = append(applied, period)
+		return nil
+	})
+
+	// an invalid value is rejected by its handler and reverted
+	write(`{"tracking_period": -1, "authority_token": "secret"}`)
+	cs.reload()
+	if period := viper.GetInt("tracking_period"); period != 5000 {
+		t.Error("expected rejected tracking period to be reverted to 5000. got", period)
+	}
+
+	// settings requiring a restart keep their value, added or changed
+	write(`{"tracking_period": 7000, "authority_token": "other", "peer_address": "http://10.0.0.1:7777"}`)
+	cs.reload()
+	if token := viper.GetString("authority_token"); token != "secret" {
+		t.Error("expected authority_token to keep its value until a restart. got", token)
+	}
+	if address := viper.GetString("peer_address"); address != "" {
+		t.Error("expected added peer_address to be ignored until a restart. got", address)
+	}
+	if period := viper.GetInt("tracking_period"); period != 7000 {
+		t.Error("expected valid tracking period 7000 to be applied. got", period)
+	}
+	if len(applied) != 1 || applied[0] != 7000 {
+		t.Error("expected handler to apply 7000 only. got", applied)
+	}
+
+	// a file that fails to parse leaves the settings in effect untouched
+	write(`{"tracking_period": `)
+	cs.reload()
+	if period := viper.GetInt("tracking_period"); period != 7000 {
+		t.Error("expected tracking period 7000 after an unparsable file. got", period)
+	}
+}
@@ -0,0 +1,67 @@
+package daemon
+
+import (
+	"github.com/govice/golinks/block"
+	"github.com/govice/golinksd/pkg/blockchain"
+	"github.com/govice/golinksd/pkg/chainbackend"
+	"github.com/govice/golinksd/pkg/config"
+	"github.com/govice/golinksd/pkg/golinks"
+	"github.com/govice/golinksd/pkg/log"
+	"github.com/spf13/viper"
+)
+
+// newChainBackend returns the backend configured for remote. Filesystem and
+// memory backends are seeded with a genesis block when empty.
+func (d *Daemon) newChainBackend(remote *config.Remote) (chainbackend.ChainBackend, error) {
+	switch remote.Backend() {
+	case config.BackendAuthority:
+		return d.blockchainService, nil
+	case config.BackendFilesystem:
+		fs, err := chainbackend.NewFilesystem(remote.BackendPath())
+		if err != nil {
+			return nil, err
+		}
+		fs.SetCompression(viper.GetBool("chain_compression"))
+		length, err := fs.Length()
+		if err != nil {
+			return nil, err
+		}
+		if length == 0 {
+			log.Logln("seeding filesystem backend of remote", remote.Name(), "with genesis block")
+			if err := fs.Append(block.NewSHA512Genesis()); err != nil {
+				return nil, err
+			}
+		}
+		return fs, nil
+	case config.BackendMemory:
+		return chainbackend.NewMemory(block.NewSHA512Genesis()), nil
+	default:
+		return golinks.New(remote)
+	}
+}
+
+// newBlockchainService returns the daemon's own chain, persisted to
+// authority_dir. In authority mode the chain is created with a genesis block on
+// first start.
+func newBlockchainService() (*blockchain.Service, error) {
+	store, err := chainbackend.NewFilesystem(viper.GetString("authority_dir"))
+	if err != nil {
+		log.Errln("failed to open authority chain directory")
+		return nil, err
+	}
+	store.SetCompression(viper.GetBool("chain_compression"))
+
+	bs, err := blockchain.New(store)
+	if err != nil {
+		return nil, err
+	}
+
+	if viper.GetBool("genesis") && bs.ChainLength() == 0 {
+		log.Logln("creating genesis block of authority chain")
+		if err := bs.ResetChain(); err != nil {
+			return nil, err
+		}
+	}
+
+	return bs, nil
+}
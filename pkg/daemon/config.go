@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"os"
 
+	"github.com/govice/golinksd/pkg/atomicfile"
 	"github.com/govice/golinksd/pkg/log"
 	"github.com/govice/golinksd/pkg/worker"
 )
@@ -26,7 +27,11 @@ func (w *WorkerConfigManager) ReadConfig() (*worker.Config, error) {
 
 	workerConfig := &worker.Config{}
 	if err := json.Unmarshal(configBytes, workerConfig); err != nil {
-		return nil, err
+		log.Errln("worker configuration", w.Path, "is unreadable, initializing with empty config:", err)
+		if _, err := atomicfile.Quarantine(w.Path); err != nil {
+			return nil, err
+		}
+		return &worker.Config{}, nil
 	}
 
 	return workerConfig, nil
@@ -40,5 +45,5 @@ func (w *WorkerConfigManager) WriteConfig(cfg *worker.Config) error {
 		return err
 	}
 
-	return ioutil.WriteFile(w.Path, configBytes, 0666)
+	return atomicfile.WriteFile(w.Path, configBytes, 0666)
 }
@@ -2,17 +2,23 @@ package daemon
 
 import (
 	"context"
+	"errors"
 	"io"
 	"path/filepath"
 	"sync"
+	"time"
 
+	"github.com/govice/golinksd/internal/peerserver"
 	"github.com/govice/golinksd/internal/webserver"
 	"github.com/govice/golinksd/pkg/authentication"
+	"github.com/govice/golinksd/pkg/blobstore"
 	"github.com/govice/golinksd/pkg/blockchain"
+	"github.com/govice/golinksd/pkg/chainbackend"
 	"github.com/govice/golinksd/pkg/chaintracker"
 	"github.com/govice/golinksd/pkg/config"
-	"github.com/govice/golinksd/pkg/golinks"
+	"github.com/govice/golinksd/pkg/gossip"
 	"github.com/govice/golinksd/pkg/log"
+	"github.com/govice/golinksd/pkg/peers"
 	"github.com/govice/golinksd/pkg/worker"
 	"github.com/kardianos/service"
 	"github.com/spf13/viper"
@@ -27,8 +33,12 @@ type Daemon struct {
 	errorGroup            errgroup.Group
 	blockchainService     *blockchain.Service
 	configService         *config.Service
-	golinksService        *golinks.Service
+	chainBackends         map[string]chainbackend.ChainBackend
+	blobStore             *blobstore.Store
 	webserver             *webserver.Webserver
+	peerServer            *peerserver.PeerServer
+	gossipService         *gossip.Service
+	peerService           *peers.Service
 	workerService         *worker.Service
 	chainTrackerService   *chaintracker.Service
 	authenticationService *authentication.Service
@@ -100,6 +110,22 @@ func (d *Daemon) run() error {
 		})
 	}
 
+	if viper.GetBool("genesis") || viper.GetBool("gossip") {
+		d.errorGroup.Go(func() error {
+			return d.peerServer.Execute(primaryContext)
+		})
+	}
+
+	d.errorGroup.Go(func() error {
+		return d.peerService.Execute(primaryContext)
+	})
+
+	if viper.GetBool("gossip") {
+		d.errorGroup.Go(func() error {
+			return d.gossipService.Execute(primaryContext)
+		})
+	}
+
 	chainTrackerCtx, cancelChainTracker := context.WithCancel(primaryContext)
 	d.errorGroup.Go(func() error {
 		return d.ExecuteChainTracker(chainTrackerCtx)
@@ -107,11 +133,25 @@ func (d *Daemon) run() error {
 	d.cancelFuncs = append(d.cancelFuncs, cancelChainTracker)
 
 	var initialSyncWg sync.WaitGroup
-	initialSyncWg.Add(1)
 
 	log.Logln("performing initial chain sync...")
-	d.ChainTrackerService().ForceSync(&initialSyncWg)
-	initialSyncWg.Wait()
+	for _, tracker := range d.ChainTrackerService().Trackers() {
+		tracker := tracker
+		initialSyncWg.Add(1)
+		go func() {
+			defer initialSyncWg.Done()
+			if _, err := tracker.Sync(primaryContext); err != nil {
+				log.Errln(tracker.Remote(), "initial chain sync failed", err)
+			}
+		}()
+	}
+	if viper.GetBool("start_workers_before_sync") {
+		// workers generate blockmaps while the chains catch up, and their
+		// appends wait for the sync to finish
+		log.Logln("starting workers before the initial chain sync finishes")
+	} else {
+		initialSyncWg.Wait()
+	}
 
 	workerCtx, cancelDaemon := context.WithCancel(primaryContext)
 
@@ -132,26 +172,80 @@ func (d *Daemon) initializeServices() error {
 	}
 	d.configService = cs
 
-	gs, err := golinks.New(d.configService)
+	if err := d.recoverStateFiles(); err != nil {
+		return err
+	}
+
+	blobStore, err := blobstore.New(viper.GetString("blobs_dir"))
 	if err != nil {
-		log.Errln("failed to iniitalize golinks service")
+		log.Errln("failed to initialize blob store")
 		return err
 	}
-	d.golinksService = gs
+	d.blobStore = blobStore
 
-	bs, err := blockchain.New()
+	bs, err := newBlockchainService()
 	if err != nil {
 		log.Errln("failed to initialize blockchain service")
 		return err
 	}
 	d.blockchainService = bs
 
+	ps, err := peers.New(d, filepath.Join(d.ConfigService().HomeDir(), "peers.json"))
+	if err != nil {
+		log.Errln("failed to initialize peer service")
+		return err
+	}
+	d.peerService = ps
+	d.configService.OnChange("peer_probe_period", func() error {
+		return d.peerService.SetProbePeriod(viper.GetInt("peer_probe_period"))
+	})
+
+	if viper.GetBool("gossip") {
+		gs, err := gossip.New(d)
+		if err != nil {
+			log.Errln("failed to initialize gossip service")
+			return err
+		}
+		d.gossipService = gs
+		d.configService.OnChange("gossip_period", func() error {
+			return d.gossipService.SetGossipPeriod(viper.GetInt("gossip_period"))
+		})
+	}
+
+	d.chainBackends = make(map[string]chainbackend.ChainBackend)
+	for _, remote := range d.configService.Remotes() {
+		backend, err := d.newChainBackend(remote)
+		if err != nil {
+			log.Errln("failed to initialize", remote.Backend(), "chain backend for remote", remote.Name())
+			return err
+		}
+		d.chainBackends[remote.Name()] = backend
+	}
+
 	cts, err := chaintracker.New(d)
 	if err != nil {
 		log.Errln("failed to initialize chain tracker service")
 		return err
 	}
 	d.chainTrackerService = cts
+	// peers serve their own chain, so a remote only fails over to the peers
+	// listed as mirrors of it
+	if viper.GetBool("peer_failover") {
+		for _, remote := range d.configService.Remotes() {
+			if len(remote.MirrorPeers()) == 0 {
+				continue
+			}
+			mirrors, err := d.peerService.Mirrors(remote.MirrorPeers())
+			if err != nil {
+				log.Errln("failed to read mirror peers of remote", remote.Name())
+				return err
+			}
+			if err := d.chainTrackerService.SetFailover(remote.Name(), mirrors); err != nil {
+				log.Errln("failed to enable failover to peers for remote", remote.Name())
+				return err
+			}
+		}
+	}
 
 	as, err := authentication.New()
 	if err != nil {
@@ -170,6 +264,17 @@ func (d *Daemon) initializeServices() error {
 	}
 	d.workerService = ws
 
+	declaredWorkers := &DeclaredWorkerDirectory{Path: viper.GetString("workers_dir")}
+	reconcilePeriod := time.Millisecond * time.Duration(viper.GetInt("workers_reconcile_period"))
+	d.workerService.SetDeclaredReader(declaredWorkers, reconcilePeriod)
+
+	// blocks appended by this daemon's workers survive pruning
+	d.chainTrackerService.SetKeepFilter(d.workerService.KeepsBlock)
+
+	d.configService.OnChange("concurrent_task_limit", func() error {
+		return d.workerService.ResizeScheduler(viper.GetInt("concurrent_task_limit"))
+	})
+
 	return nil
 }
 
@@ -194,6 +299,13 @@ func (d *Daemon) initializeBackgroundTasks() error {
 	}
 	d.webserver = webserver
 
+	peerServer, err := peerserver.New(d)
+	if err != nil {
+		log.Errln("failed to initialize peer server")
+		return err
+	}
+	d.peerServer = peerServer
+
 	return nil
 }
 
@@ -205,6 +317,21 @@ func (d *Daemon) BlockchainService() *blockchain.Service {
 	return d.blockchainService
 }
 
+// GossipService returns the service gossiping the daemon's chain with its
+// peers, or nil if gossip is disabled.
+func (d *Daemon) GossipService() *gossip.Service {
+	return d.gossipService
+}
+
+// PeerService returns the service managing the daemon's peers.
+func (d *Daemon) PeerService() *peers.Service {
+	return d.peerService
+}
+
+func (d *Daemon) BlobStore() *blobstore.Store {
+	return d.blobStore
+}
+
 func (d *Daemon) ConfigService() *config.Service {
 	return d.configService
 }
@@ -213,8 +340,19 @@ func (d *Daemon) WorkerService() *worker.Service {
 	return d.workerService
 }
 
-func (d *Daemon) GolinksService() *golinks.Service {
-	return d.golinksService
+var ErrUnknownRemote = errors.New("daemon: unknown remote")
+
+// ChainBackend returns the chain backend of the named remote. An empty name
+// refers to the default remote.
+func (d *Daemon) ChainBackend(remote string) (chainbackend.ChainBackend, error) {
+	if remote == "" {
+		remote = config.DefaultRemote
+	}
+	backend, ok := d.chainBackends[remote]
+	if !ok {
+		return nil, ErrUnknownRemote
+	}
+	return backend, nil
 }
 
 func (d *Daemon) ChainTrackerService() *chaintracker.Service {
@@ -0,0 +1,110 @@
+package daemon
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/govice/golinksd/pkg/log"
+	"github.com/govice/golinksd/pkg/worker"
+	"github.com/spf13/viper"
+)
+
+// DeclaredWorkerDirectory reads worker declarations from the json and yaml
+// files of a drop-in directory.
+type DeclaredWorkerDirectory struct {
+	Path string
+}
+
+type declaredWorker struct {
+	ID               string   `mapstructure:"id"`
+	RootPath         string   `mapstructure:"root_path"`
+	GenerationPeriod int      `mapstructure:"generation_period"`
+	IgnorePaths      []string `mapstructure:"ignore_paths"`
+	Remote           string   `mapstructure:"remote"`
+}
+
+var declaredWorkerExtensions = map[string]bool{
+	".json": true,
+	".yaml": true,
+	".yml":  true,
+}
+
+// ReadDeclared returns the workers declared in the directory. A file declares
+// either a single worker or a list of workers under the workers key. Workers
+// without an id are identified by their file name and position.
+func (d *DeclaredWorkerDirectory) ReadDeclared() ([]*worker.NewWorkerConfig, error) {
+	entries, err := ioutil.ReadDir(d.Path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() || !declaredWorkerExtensions[filepath.Ext(entry.Name())] {
+			continue
+		}
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+
+	var configs []*worker.NewWorkerConfig
+	for _, name := range names {
+		fileConfigs, err := d.readFile(filepath.Join(d.Path, name))
+		if err != nil {
+			log.Errln("failed to read declared worker file", name)
+			return nil, err
+		}
+		configs = append(configs, fileConfigs...)
+	}
+
+	return configs, nil
+}
+
+func (d *DeclaredWorkerDirectory) readFile(path string) ([]*worker.NewWorkerConfig, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, err
+	}
+
+	var declared []declaredWorker
+	if v.IsSet("workers") {
+		if err := v.UnmarshalKey("workers", &declared); err != nil {
+			return nil, err
+		}
+	} else {
+		single := declaredWorker{}
+		if err := v.Unmarshal(&single); err != nil {
+			return nil, err
+		}
+		declared = append(declared, single)
+	}
+
+	stem := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	var configs []*worker.NewWorkerConfig
+	for index, dw := range declared {
+		id := dw.ID
+		if id == "" {
+			id = stem
+			if len(declared) > 1 {
+				id += "-" + strconv.Itoa(index)
+			}
+		}
+		configs = append(configs, &worker.NewWorkerConfig{
+			RootPath:         dw.RootPath,
+			GenerationPeriod: dw.GenerationPeriod,
+			IgnorePaths:      dw.IgnorePaths,
+			WorkerID:         id,
+			Remote:           dw.Remote,
+			Source:           path,
+		})
+	}
+
+	return configs, nil
+}
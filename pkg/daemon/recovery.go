@@ -0,0 +1,36 @@
+package daemon
+
+import (
+	"github.com/govice/golinksd/pkg/atomicfile"
+	"github.com/govice/golinksd/pkg/log"
+	"github.com/spf13/viper"
+)
+
+// recoverStateFiles removes the partially written state files left by a crash
+// before the services read their state. Local chains recover their own
+// segments when they are opened, and unreadable state files are moved aside
+// by the services reading them.
+func (d *Daemon) recoverStateFiles() error {
+	dirs := []string{
+		d.configService.HomeDir(),
+		viper.GetString("blobs_dir"),
+		viper.GetString("authority_dir"),
+	}
+	for _, remote := range d.configService.Remotes() {
+		dirs = append(dirs, remote.ChainDir())
+	}
+
+	removed := 0
+	for _, dir := range dirs {
+		paths, err := atomicfile.Recover(dir)
+		if err != nil {
+			log.Errln("failed to recover state files in", dir)
+			return err
+		}
+		removed += len(paths)
+	}
+	if removed > 0 {
+		log.Warnln("removed", removed, "partially written state files")
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package golinks
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/govice/golinksd/pkg/chainbackend"
+)
+
+var (
+	ErrUnauthorized     = errors.New("golinks: unauthorized by remote")
+	ErrNotFound         = errors.New("golinks: not found on remote")
+	ErrRateLimited      = errors.New("golinks: rate limited by remote")
+	ErrServerError      = errors.New("golinks: remote server error")
+	ErrMalformedPayload = errors.New("golinks: malformed payload from remote")
+	ErrConflict         = errors.New("golinks: block rejected by remote, chain head moved")
+)
+
+// RemoteError describes a failed request to a remote. It wraps one of the
+// golinks errors and carries the body the remote responded with.
+type RemoteError struct {
+	Remote     string
+	Method     string
+	URL        string
+	StatusCode int
+	Body       []byte
+	// RetryAfter is the delay requested by a rate limiting remote.
+	RetryAfter time.Duration
+	Err        error
+}
+
+// maxErrorBody is the number of bytes of a response body included in the
+// message of a RemoteError.
+const maxErrorBody = 256
+
+func (e *RemoteError) Error() string {
+	msg := fmt.Sprintf("%s: %s %s", e.Err, e.Method, e.URL)
+	if e.StatusCode != 0 {
+		msg += " " + strconv.Itoa(e.StatusCode)
+	}
+	if len(e.Body) > maxErrorBody {
+		msg += fmt.Sprintf(": %s... (%d bytes)", e.Body[:maxErrorBody], len(e.Body))
+	} else if len(e.Body) > 0 {
+		msg += ": " + string(e.Body)
+	}
+	return msg
+}
+
+func (e *RemoteError) Unwrap() error {
+	return e.Err
+}
+
+// Is matches the chainbackend errors that a remote error corresponds to.
+func (e *RemoteError) Is(target error) bool {
+	switch target {
+	case chainbackend.ErrBlockNotFound:
+		return e.Err == ErrNotFound
+	case chainbackend.ErrConflict:
+		return e.Err == ErrConflict
+	}
+	return false
+}
+
+// statusError returns the error describing a response's status code, or nil
+// for a successful response. fallback describes unexpected status codes.
+func (gs *Service) statusError(res *http.Response, body []byte, fallback error) error {
+	if res.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	remoteErr := &RemoteError{
+		Remote:     gs.RemoteName(),
+		Method:     res.Request.Method,
+		URL:        res.Request.URL.String(),
+		StatusCode: res.StatusCode,
+		Body:       body,
+		Err:        fallback,
+	}
+
+	switch {
+	case res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusForbidden:
+		remoteErr.Err = ErrUnauthorized
+	case res.StatusCode == http.StatusNotFound:
+		remoteErr.Err = ErrNotFound
+	case res.StatusCode == http.StatusConflict || res.StatusCode == http.StatusPreconditionFailed:
+		// remotes that check the parent hash as a precondition reply 412
+		remoteErr.Err = ErrConflict
+	case res.StatusCode == http.StatusTooManyRequests:
+		remoteErr.Err = ErrRateLimited
+		if seconds, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil {
+			remoteErr.RetryAfter = time.Duration(seconds) * time.Second
+		}
+	case res.StatusCode >= http.StatusInternalServerError:
+		remoteErr.Err = ErrServerError
+	}
+
+	return remoteErr
+}
+
+// payloadError reports a response body that could not be interpreted.
+func (gs *Service) payloadError(res *http.Response, body []byte) error {
+	return &RemoteError{
+		Remote:     gs.RemoteName(),
+		Method:     res.Request.Method,
+		URL:        res.Request.URL.String(),
+		StatusCode: res.StatusCode,
+		Body:       body,
+		Err:        ErrMalformedPayload,
+	}
+}
@@ -0,0 +1,95 @@
+package golinks
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/govice/golinksd/internal/remotetest"
+	"github.com/govice/golinksd/pkg/chainbackend"
+)
+
+func TestStatusError(t *testing.T) {
+	gs := &Service{remote: &remotetest.Remote{}}
+	fallback := errors.New("fallback")
+
+	tests := []struct {
+		status     int
+		retryAfter string
+		err        error
+		is         error
+	}{
+		{status: http.StatusOK},
+		{status: http.StatusUnauthorized, err: ErrUnauthorized},
+		{status: http.StatusForbidden, err: ErrUnauthorized},
+		{status: http.StatusNotFound, err: ErrNotFound, is: chainbackend.ErrBlockNotFound},
+		{status: http.StatusConflict, err: ErrConflict, is: chainbackend.ErrConflict},
+		{status: http.StatusPreconditionFailed, err: ErrConflict, is: chainbackend.ErrConflict},
+		{status: http.StatusTooManyRequests, retryAfter: "3", err: ErrRateLimited},
+		{status: http.StatusInternalServerError, err: ErrServerError},
+		{status: http.StatusBadGateway, err: ErrServerError},
+		{status: http.StatusBadRequest, err: fallback},
+	}
+	for _, test := range tests {
+		res := testResponse(test.status)
+		res.Header.Set("Retry-After", test.retryAfter)
+		err := gs.statusError(res, []byte("body"), fallback)
+		if test.err == nil {
+			if err != nil {
+				t.Error("expected no error for status", test.status, "got", err)
+			}
+			continue
+		}
+
+		var remoteErr *RemoteError
+		if !errors.As(err, &remoteErr) || !errors.Is(err, test.err) {
+			t.Error("expected", test.err, "for status", test.status, "got", err)
+			continue
+		}
+		if remoteErr.StatusCode != test.status || remoteErr.Remote != "test" {
+			t.Error("expected status and remote on error. got", remoteErr.StatusCode, remoteErr.Remote)
+		}
+		if test.is != nil && !errors.Is(err, test.is) {
+			t.Error("expected error for status", test.status, "to match", test.is)
+		}
+		if test.retryAfter != "" && remoteErr.RetryAfter != 3*time.Second {
+			t.Error("expected retry after 3s. got", remoteErr.RetryAfter)
+		}
+	}
+}
+
+func TestRemoteErrorTruncatesBody(t *testing.T) {
+	body := []byte(strings.Repeat("x", 10*maxErrorBody))
+	err := testServiceError(body)
+	msg := err.Error()
+	if len(msg) > 2*maxErrorBody {
+		t.Error("expected truncated error message. got", len(msg), "bytes")
+	}
+	if !strings.Contains(msg, "(2560 bytes)") {
+		t.Error("expected body length in error message. got", msg)
+	}
+	if len(err.(*RemoteError).Body) != len(body) {
+		t.Error("expected the full body on the error")
+	}
+
+	if msg := testServiceError([]byte("short")).Error(); !strings.HasSuffix(msg, ": short") {
+		t.Error("expected a short body in full. got", msg)
+	}
+}
+
+func testServiceError(body []byte) error {
+	gs := &Service{remote: &remotetest.Remote{}}
+	return gs.statusError(testResponse(http.StatusBadRequest), body, ErrFailedBlockRequest)
+}
+
+func testResponse(status int) *http.Response {
+	u, _ := url.Parse("http://remote/chain")
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{},
+		Request:    &http.Request{Method: http.MethodGet, URL: u},
+	}
+}
@@ -2,74 +2,127 @@ package golinks
 
 import (
 	"bytes"
+	"compress/gzip"
 	"encoding/json"
 	"errors"
 	"io/ioutil"
 	"net/http"
 	"strconv"
+	"strings"
+	"sync/atomic"
 
 	"github.com/govice/golinks/block"
+	"github.com/govice/golinksd/pkg/blobstore"
+	"github.com/govice/golinksd/pkg/chainbackend"
+	"github.com/govice/golinksd/pkg/httpclient"
 	"github.com/govice/golinksd/pkg/log"
 	"github.com/spf13/viper"
 )
 
 type Service struct {
-	tokener Tokener
+	remote Remote
+	client *httpclient.Client
+	// acceptsGzip is set once the remote advertised gzip request bodies in the
+	// Accept-Encoding header of a response.
+	acceptsGzip int32
+	// uncompressedUploads is set once the remote rejected a compressed body.
+	uncompressedUploads int32
 }
 
 type Tokener interface {
 	Token() string
 }
 
-func New(tokener Tokener) (*Service, error) {
+// Remote supplies the endpoints and credentials of the remote chain a Service
+// communicates with.
+type Remote interface {
+	Tokener
+	Name() string
+	ChainLengthEndpoint() string
+	ChainBlockEndpoint() string
+	ChainRangeEndpoint() string
+	BlobEndpoint() string
+}
+
+func New(remote Remote) (*Service, error) {
+	client, err := httpclient.New(httpclient.OptionsFromConfig())
+	if err != nil {
+		return nil, err
+	}
 	return &Service{
-		tokener: tokener,
+		remote: remote,
+		client: client,
 	}, nil
 }
 
+// do sends req to the remote and records whether the response advertises
+// gzip request bodies, as described in RFC 7694.
+func (gs *Service) do(req *http.Request) (*http.Response, error) {
+	res, err := gs.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if strings.Contains(res.Header.Get("Accept-Encoding"), "gzip") {
+		atomic.StoreInt32(&gs.acceptsGzip, 1)
+	}
+	return res, nil
+}
+
+// RemoteName returns the name of the remote the service communicates with.
+func (gs *Service) RemoteName() string {
+	return gs.remote.Name()
+}
+
 func (gs *Service) BearerToken() string {
-	return "Bearer " + gs.tokener.Token()
+	return "Bearer " + gs.remote.Token()
 }
 
 var ErrFailedChainLengthRequest = errors.New("failed to request chain length from remote")
 
+// Length returns the length of the remote chain.
+func (gs *Service) Length() (int, error) {
+	return gs.GetLength()
+}
+
 func (gs *Service) GetLength() (int, error) {
-	req, err := http.NewRequest("GET", viper.GetString("chain_length_endpoint"), nil)
+	req, err := http.NewRequest("GET", gs.remote.ChainLengthEndpoint(), nil)
 	if err != nil {
 		return -1, err
 	}
 
 	req.Header.Add("Authorization", gs.BearerToken())
 
-	res, err := http.DefaultClient.Do(req)
+	res, err := gs.do(req)
 	if err != nil {
 		log.Errln("failed to get length", err)
 		return -1, err
 	}
 
-	if res.StatusCode != http.StatusOK {
-		return -1, ErrFailedChainLengthRequest
-	}
-
 	bodyBytes, err := ioutil.ReadAll(res.Body)
 	if err != nil {
 		return -1, err
 	}
 	defer res.Body.Close()
 
+	if err := gs.statusError(res, bodyBytes, ErrFailedChainLengthRequest); err != nil {
+		return -1, err
+	}
+
 	payload := &struct {
-		Length int `json:"length"`
+		Length *int `json:"length"`
 	}{}
 
-	if err := json.Unmarshal(bodyBytes, payload); err != nil {
-		return -1, err
+	if err := json.Unmarshal(bodyBytes, payload); err != nil || payload.Length == nil || *payload.Length < 0 {
+		return -1, gs.payloadError(res, bodyBytes)
 	}
 
-	return payload.Length, nil
+	return *payload.Length, nil
 }
 
+var ErrFailedBlockRequest = errors.New("failed to request block from remote")
+
 func (gs *Service) GetBlock(index int) (*block.Block, error) {
-	req, err := http.NewRequest("GET", viper.GetString("chain_block_endpoint"), nil)
+	req, err := http.NewRequest("GET", gs.remote.ChainBlockEndpoint(), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -80,7 +133,7 @@ func (gs *Service) GetBlock(index int) (*block.Block, error) {
 	query.Add("index", strconv.Itoa(index))
 	req.URL.RawQuery = query.Encode()
 
-	res, err := http.DefaultClient.Do(req)
+	res, err := gs.do(req)
 	if err != nil {
 		log.Errln("failed to get block")
 		return nil, err
@@ -92,37 +145,236 @@ func (gs *Service) GetBlock(index int) (*block.Block, error) {
 	}
 	defer res.Body.Close()
 
-	b := &block.Block{}
-	if err := json.Unmarshal(blockBody, b); err != nil {
+	if err := gs.statusError(res, blockBody, ErrFailedBlockRequest); err != nil {
 		return nil, err
 	}
 
+	b := &block.Block{}
+	if err := json.Unmarshal(blockBody, b); err != nil || b.Index != index || len(b.BlockHash) == 0 {
+		return nil, gs.payloadError(res, blockBody)
+	}
+
 	return b, nil
 }
 
+var ErrFailedBlockRangeRequest = errors.New("failed to request block range from remote")
+
+// GetRange requests the blocks from start through end from the remote's range
+// endpoint. A remote may return fewer blocks than requested, starting at start,
+// to paginate its response.
+func (gs *Service) GetRange(start, end int) ([]*block.Block, error) {
+	endpoint := gs.remote.ChainRangeEndpoint()
+	if endpoint == "" {
+		return nil, chainbackend.ErrRangeUnsupported
+	}
+
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Add("Authorization", gs.BearerToken())
+
+	query := req.URL.Query()
+	query.Add("start", strconv.Itoa(start))
+	query.Add("end", strconv.Itoa(end))
+	req.URL.RawQuery = query.Encode()
+
+	res, err := gs.do(req)
+	if err != nil {
+		log.Errln("failed to get block range")
+		return nil, err
+	}
+
+	rangeBody, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		return nil, chainbackend.ErrRangeUnsupported
+	}
+
+	if err := gs.statusError(res, rangeBody, ErrFailedBlockRangeRequest); err != nil {
+		return nil, err
+	}
+
+	payload := &struct {
+		Blocks []*block.Block `json:"blocks"`
+	}{}
+	if err := json.Unmarshal(rangeBody, payload); err != nil || len(payload.Blocks) == 0 || len(payload.Blocks) > end-start+1 {
+		return nil, gs.payloadError(res, rangeBody)
+	}
+
+	for offset, b := range payload.Blocks {
+		if b == nil || b.Index != start+offset || len(b.BlockHash) == 0 {
+			return nil, gs.payloadError(res, rangeBody)
+		}
+	}
+
+	return payload.Blocks, nil
+}
+
 var ErrFailedBlockUpload = errors.New("failed to upload block")
 
+// Append uploads blk to the remote chain.
+func (gs *Service) Append(blk *block.Block) error {
+	return gs.UploadBlock(blk)
+}
+
 func (gs *Service) UploadBlock(blk *block.Block) error {
 	blockBytes, err := json.Marshal(blk)
 	if err != nil {
 		return err
 	}
 
-	req, err := http.NewRequest("POST", viper.GetString("chain_block_endpoint"), bytes.NewBuffer(blockBytes))
+	// blocks are only compressed for remotes that advertised gzip bodies, so
+	// remotes ignoring Content-Encoding are never sent them
+	compress := viper.GetString("http_compression") == "gzip" &&
+		atomic.LoadInt32(&gs.acceptsGzip) == 1 &&
+		atomic.LoadInt32(&gs.uncompressedUploads) == 0
+	res, resBody, err := gs.postBlock(blockBytes, compress)
 	if err != nil {
 		return err
 	}
 
+	// a remote rejecting a compressed body with 415, or with 400 while
+	// accepting the same block uncompressed, is sent plain JSON from then on
+	if compress && (res.StatusCode == http.StatusUnsupportedMediaType || res.StatusCode == http.StatusBadRequest) {
+		compressedStatus := res.StatusCode
+		res, resBody, err = gs.postBlock(blockBytes, false)
+		if err != nil {
+			return err
+		}
+		if compressedStatus == http.StatusUnsupportedMediaType || res.StatusCode != http.StatusBadRequest {
+			log.Warnln(gs.RemoteName(), "remote does not accept compressed uploads, sending uncompressed blocks")
+			atomic.StoreInt32(&gs.uncompressedUploads, 1)
+		}
+	}
+
+	return gs.statusError(res, resBody, ErrFailedBlockUpload)
+}
+
+func (gs *Service) postBlock(blockBytes []byte, compress bool) (*http.Response, []byte, error) {
+	body := blockBytes
+	if compress {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(blockBytes); err != nil {
+			return nil, nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, nil, err
+		}
+		body = buf.Bytes()
+	}
+
+	req, err := http.NewRequest("POST", gs.remote.ChainBlockEndpoint(), bytes.NewBuffer(body))
+	if err != nil {
+		return nil, nil, err
+	}
+
 	req.Header.Add("Authorization", gs.BearerToken())
+	req.Header.Set("Content-Type", "application/json")
+	if compress {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+
+	res, err := gs.do(req)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	res, err := http.DefaultClient.Do(req)
+	resBody, err := ioutil.ReadAll(res.Body)
 	if err != nil {
+		return nil, nil, err
+	}
+	defer res.Body.Close()
+
+	return res, resBody, nil
+}
+
+var (
+	ErrFailedBlobUpload  = errors.New("failed to upload blob")
+	ErrFailedBlobRequest = errors.New("failed to request blob from remote")
+)
+
+// PutBlob uploads a blob to <blob_endpoint>/<hash>.
+func (gs *Service) PutBlob(hash string, data []byte) error {
+	endpoint := gs.remote.BlobEndpoint()
+	if endpoint == "" {
+		return chainbackend.ErrBlobsUnsupported
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
 		return err
 	}
 
-	if res.StatusCode != http.StatusOK {
-		return ErrFailedBlockUpload
+	req, err := http.NewRequest("POST", strings.TrimSuffix(endpoint, "/")+"/"+hash, &buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Authorization", gs.BearerToken())
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Encoding", "gzip")
+
+	res, err := gs.do(req)
+	if err != nil {
+		return err
 	}
 
-	return nil
+	resBody, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	return gs.statusError(res, resBody, ErrFailedBlobUpload)
+}
+
+// GetBlob requests the blob addressed by hash from <blob_endpoint>/<hash>.
+func (gs *Service) GetBlob(hash string) ([]byte, error) {
+	endpoint := gs.remote.BlobEndpoint()
+	if endpoint == "" {
+		return nil, chainbackend.ErrBlobsUnsupported
+	}
+
+	req, err := http.NewRequest("GET", strings.TrimSuffix(endpoint, "/")+"/"+hash, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Authorization", gs.BearerToken())
+
+	res, err := gs.do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	blob, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if err := gs.statusError(res, blob, ErrFailedBlobRequest); err != nil {
+		return nil, err
+	}
+
+	if blobstore.Hash(blob) != hash {
+		return nil, gs.payloadError(res, nil)
+	}
+
+	return blob, nil
 }
+
+var (
+	_ chainbackend.ChainBackend = &Service{}
+	_ chainbackend.BlobBackend  = &Service{}
+)
@@ -0,0 +1,85 @@
+package golinks
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/govice/golinks/block"
+	"github.com/govice/golinksd/internal/remotetest"
+	"github.com/spf13/viper"
+)
+
+func TestUploadCompressionNegotiation(t *testing.T) {
+	viper.Set("http_compression", "gzip")
+	defer viper.Set("http_compression", nil)
+
+	tests := []struct {
+		name string
+		// advertise sets Accept-Encoding: gzip on the remote's responses
+		advertise bool
+		// rejectGzip is the status a compressed upload is answered with
+		rejectGzip int
+		// compressed lists whether each of the uploads is expected compressed
+		compressed []bool
+	}{
+		{"remote ignoring Content-Encoding", false, http.StatusBadRequest, []bool{false, false, false}},
+		{"remote accepting gzip", true, 0, []bool{false, true, true}},
+		{"remote answering gzip with 400", true, http.StatusBadRequest, []bool{false, true, false, false}},
+		{"remote answering gzip with 415", true, http.StatusUnsupportedMediaType, []bool{false, true, false, false}},
+	}
+	for _, test := range tests {
+		var compressed []bool
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if test.advertise {
+				w.Header().Set("Accept-Encoding", "gzip")
+			}
+			gzipped := r.Header.Get("Content-Encoding") == "gzip"
+			compressed = append(compressed, gzipped)
+			if gzipped && test.rejectGzip != 0 {
+				w.WriteHeader(test.rejectGzip)
+				return
+			}
+			body := r.Body
+			if gzipped {
+				zr, err := gzip.NewReader(r.Body)
+				if err != nil {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				body = ioutil.NopCloser(zr)
+			}
+			if err := json.NewDecoder(body).Decode(&block.Block{}); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+
+		gs, err := New(&remotetest.Remote{URL: server.URL})
+		if err != nil {
+			t.Fatal(err)
+		}
+		blk := block.NewSHA512(1, []byte("blockmap"), nil)
+		for upload := 0; upload < 3; upload++ {
+			if err := gs.UploadBlock(blk); err != nil {
+				t.Error(test.name+": expected successful upload.", err)
+			}
+		}
+		server.Close()
+
+		if len(compressed) != len(test.compressed) {
+			t.Error(test.name+": expected", len(test.compressed), "requests. got", len(compressed))
+			continue
+		}
+		for i := range compressed {
+			if compressed[i] != test.compressed[i] {
+				t.Error(test.name+": expected compressed", test.compressed, "got", compressed)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package gossip
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/govice/golinksd/pkg/golinks"
+	"github.com/govice/golinksd/pkg/peers"
+)
+
+var ErrFailedAnnouncement = errors.New("gossip: peer rejected announcement")
+
+// announce sends head to the peer and returns the peer's head.
+func announce(peer *peers.Peer, head Announcement) (Announcement, error) {
+	headBytes, err := json.Marshal(head)
+	if err != nil {
+		return Announcement{}, err
+	}
+	req, err := http.NewRequest("POST", peer.Address()+"/gossip/announce", bytes.NewReader(headBytes))
+	if err != nil {
+		return Announcement{}, err
+	}
+	req.Header.Add("Authorization", "Bearer "+peer.Token())
+	req.Header.Add("Content-Type", "application/json")
+
+	res, err := peer.Client().Do(req)
+	if err != nil {
+		return Announcement{}, err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return Announcement{}, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return Announcement{}, &golinks.RemoteError{
+			Remote:     peer.Address(),
+			Method:     req.Method,
+			URL:        req.URL.String(),
+			StatusCode: res.StatusCode,
+			Body:       body,
+			Err:        ErrFailedAnnouncement,
+		}
+	}
+
+	peerHead := Announcement{}
+	if err := json.Unmarshal(body, &peerHead); err != nil {
+		return Announcement{}, golinks.ErrMalformedPayload
+	}
+	peer.SetHead(peerHead.Length, peerHead.HeadHash)
+	return peerHead, nil
+}
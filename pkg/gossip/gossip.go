@@ -0,0 +1,305 @@
+package gossip
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/govice/golinks/block"
+	"github.com/govice/golinks/blockchain"
+	chainservice "github.com/govice/golinksd/pkg/blockchain"
+	"github.com/govice/golinksd/pkg/golinks"
+	"github.com/govice/golinksd/pkg/log"
+	"github.com/govice/golinksd/pkg/peers"
+	"github.com/spf13/viper"
+)
+
+// Announcement is the head of a node's chain, exchanged between peers.
+type Announcement struct {
+	// Address is the base URL the node serves its chain on. When it is empty,
+	// peers derive it from the source of the announcement and Port.
+	Address  string `json:"address,omitempty"`
+	Port     int    `json:"port"`
+	Length   int    `json:"length"`
+	HeadHash []byte `json:"head_hash,omitempty"`
+}
+
+type BlockchainServicer interface {
+	BlockchainService() *chainservice.Service
+}
+
+type PeerServicer interface {
+	PeerService() *peers.Service
+}
+
+type Servicer interface {
+	BlockchainServicer
+	PeerServicer
+}
+
+// Service gossips the daemon's own chain with its peers. It announces its head
+// to every peer each gossip period, and pulls the missing blocks from peers
+// announcing a longer chain.
+type Service struct {
+	servicer Servicer
+
+	mu     sync.Mutex
+	ticker *time.Ticker
+	pulls  chan *peers.Peer
+}
+
+func New(servicer Servicer) (*Service, error) {
+	return &Service{
+		servicer: servicer,
+		pulls:    make(chan *peers.Peer, 16),
+	}, nil
+}
+
+// Head returns the announcement of the daemon's own chain head.
+func (s *Service) Head() Announcement {
+	bs := s.servicer.BlockchainService()
+	head := Announcement{
+		Address: s.servicer.PeerService().Address(),
+		Port:    viper.GetInt("peer_port"),
+		Length:  bs.ChainLength(),
+	}
+	if head.Length > 0 {
+		if blk, err := bs.GetBlock(head.Length - 1); err == nil {
+			head.HeadHash = blk.BlockHash
+		}
+	}
+	return head
+}
+
+// ErrUnverifiedAddress indicates an announced address that is neither on the
+// host the announcement came from nor a configured or managed peer.
+var ErrUnverifiedAddress = errors.New("gossip: announced address is not on the announcing host")
+
+// HandleAnnouncement records the head announced by the peer at source, the
+// remote host of the connection the announcement came on, and returns the
+// daemon's own head. A peer announcing a longer chain is pulled from.
+//
+// The daemon sends requests to announced peers, so an announced address must
+// be on source or belong to a configured or managed peer. Without an address
+// the peer is reached on source and the announced port.
+func (s *Service) HandleAnnouncement(source string, head Announcement) (Announcement, error) {
+	address := "http://" + net.JoinHostPort(source, strconv.Itoa(head.Port))
+	if head.Address != "" {
+		var err error
+		if address, err = s.verifyAddress(source, head.Address); err != nil {
+			return Announcement{}, err
+		}
+	}
+	peer, err := s.servicer.PeerService().Discover(address)
+	if err != nil {
+		return Announcement{}, err
+	}
+	peer.SetHead(head.Length, head.HeadHash)
+
+	if head.Length > s.servicer.BlockchainService().ChainLength() {
+		select {
+		case s.pulls <- peer:
+		default:
+			// pending pulls cover the announcement, the next gossip round
+			// retries it otherwise
+		}
+	}
+	return s.Head(), nil
+}
+
+// verifyAddress returns the normalized address announced from source if it is
+// on source or belongs to a configured or managed peer.
+func (s *Service) verifyAddress(source, address string) (string, error) {
+	address, err := peers.NormalizeAddress(address)
+	if err != nil {
+		return "", err
+	}
+	if peer, err := s.servicer.PeerService().Peer(address); err == nil && peer.Source() != peers.SourceAnnounced {
+		return address, nil
+	}
+	u, err := url.Parse(address)
+	if err != nil {
+		return "", peers.ErrInvalidAddress
+	}
+	if sourceIP, hostIP := net.ParseIP(source), net.ParseIP(u.Hostname()); sourceIP != nil && sourceIP.Equal(hostIP) {
+		return address, nil
+	}
+	log.Warnln("rejecting announced address", address, "from", source)
+	return "", ErrUnverifiedAddress
+}
+
+// Execute gossips with the peers until ctx is done.
+func (s *Service) Execute(ctx context.Context) error {
+	ticker := time.NewTicker(time.Hour)
+	ticker.Stop()
+	s.mu.Lock()
+	s.ticker = ticker
+	s.mu.Unlock()
+	s.resetGossipPeriod(viper.GetInt("gossip_period"))
+	defer func() {
+		s.mu.Lock()
+		s.ticker.Stop()
+		s.ticker = nil
+		s.mu.Unlock()
+	}()
+
+	log.Logln("gossiping with", len(s.servicer.PeerService().Peers()), "peers")
+	s.gossip()
+	for {
+		select {
+		case <-ticker.C:
+			s.gossip()
+		case peer := <-s.pulls:
+			if err := s.pull(peer); err != nil {
+				log.Errln("failed to pull chain from peer", peer.Address(), err)
+			}
+		case <-ctx.Done():
+			log.Logln("received termination on gossip context")
+			return nil
+		}
+	}
+}
+
+// resetGossipPeriod applies a new gossip period to a running service.
+func (s *Service) resetGossipPeriod(gossipPeriod int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ticker == nil || gossipPeriod <= 0 {
+		return
+	}
+	s.ticker.Reset(time.Millisecond * time.Duration(gossipPeriod))
+}
+
+var ErrInvalidGossipPeriod = errors.New("gossip: gossip period must be positive")
+
+// SetGossipPeriod applies a new gossip period, in milliseconds.
+func (s *Service) SetGossipPeriod(gossipPeriod int) error {
+	if gossipPeriod <= 0 {
+		return ErrInvalidGossipPeriod
+	}
+	s.resetGossipPeriod(gossipPeriod)
+	return nil
+}
+
+// gossip announces the daemon's head to every peer that is not banned and
+// pulls from the peers whose chain is longer.
+func (s *Service) gossip() {
+	head := s.Head()
+	for _, peer := range s.servicer.PeerService().Peers() {
+		if peer.Banned() {
+			continue
+		}
+		peerHead, err := announce(peer, head)
+		if err != nil {
+			log.Warnln("failed to announce head to peer", peer.Address(), err)
+			continue
+		}
+		if peerHead.Length > head.Length {
+			if err := s.pull(peer); err != nil {
+				log.Errln("failed to pull chain from peer", peer.Address(), err)
+			}
+			head = s.Head()
+		}
+	}
+}
+
+// ErrUnauthenticatedFork indicates a fork that was not adopted because peers
+// are not authenticated.
+var ErrUnauthenticatedFork = errors.New("gossip: forks are only adopted from peers sharing an authority_token")
+
+// pull adopts the peer's chain if it is a valid chain longer than the
+// daemon's. Only the blocks after the greatest common index of both chains
+// are requested. A peer serving an invalid chain is reported to the peer
+// service.
+func (s *Service) pull(peer *peers.Peer) error {
+	if peer.Banned() {
+		return peers.ErrBannedPeer
+	}
+	bs := s.servicer.BlockchainService()
+	peerLength, err := peer.Chain().GetLength()
+	if err != nil {
+		return err
+	}
+	local := bs.Chain()
+	if peerLength <= local.Length() {
+		return nil
+	}
+
+	gci, err := greatestCommonIndex(local, peer, peerLength)
+	if err != nil {
+		return err
+	}
+	// without a shared token any host can pose as a peer, so only chains
+	// extending the local chain are taken from it
+	if gci < local.Length() && viper.GetString("authority_token") == "" {
+		log.Warnln("not adopting fork at block", gci, "from peer", peer.Address(), "without an authority_token")
+		return ErrUnauthenticatedFork
+	}
+	candidate := &blockchain.Blockchain{Blocks: append([]block.Block{}, local.Blocks[:gci]...)}
+	for candidate.Length() < peerLength {
+		blocks, err := peer.Chain().GetRange(candidate.Length(), peerLength-1)
+		if errors.Is(err, golinks.ErrMalformedPayload) {
+			s.servicer.PeerService().ReportInvalid(peer)
+			return err
+		} else if err != nil {
+			return err
+		}
+		for _, blk := range blocks {
+			candidate.Blocks = append(candidate.Blocks, *blk)
+		}
+	}
+
+	err = bs.Adopt(candidate)
+	if errors.Is(err, chainservice.ErrInvalidChain) {
+		s.servicer.PeerService().ReportInvalid(peer)
+		return err
+	} else if errors.Is(err, blockchain.ErrShorterChain) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	s.servicer.PeerService().ReportValid(peer)
+	log.Logln("adopted", peerLength-gci, "blocks from peer", peer.Address(), "after block", gci)
+	return nil
+}
+
+// greatestCommonIndex returns the number of blocks local shares with the
+// peer's chain. Blocks are linked by hash, so the chains differ at every index
+// after the first difference and it is found by binary search. The common case
+// of a peer extending local is settled by a single request.
+func greatestCommonIndex(local *blockchain.Blockchain, peer *peers.Peer, peerLength int) (int, error) {
+	n := local.Length()
+	if peerLength < n {
+		n = peerLength
+	}
+	if n == 0 {
+		return 0, nil
+	}
+
+	var readErr error
+	matches := func(i int) bool {
+		blk, err := peer.Chain().GetBlock(i)
+		if err != nil {
+			readErr = err
+			return false
+		}
+		return block.Equal(blk, local.At(i))
+	}
+	if matches(n - 1) {
+		return n, nil
+	} else if readErr != nil {
+		return 0, readErr
+	}
+	gci := sort.Search(n-1, func(i int) bool {
+		return readErr != nil || !matches(i)
+	})
+	if readErr != nil {
+		return 0, readErr
+	}
+	return gci, nil
+}
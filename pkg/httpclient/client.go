@@ -0,0 +1,218 @@
+package httpclient
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/govice/golinksd/pkg/log"
+	"github.com/spf13/viper"
+)
+
+// Options configures the timeouts, retries, circuit breaker, TLS and proxy of
+// a Client.
+type Options struct {
+	ConnectTimeout   time.Duration
+	Timeout          time.Duration
+	MaxRetries       int
+	RetryBaseDelay   time.Duration
+	RetryMaxDelay    time.Duration
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+	// CAFile is a PEM bundle of CAs trusted in addition to the system pool.
+	CAFile string
+	// CertFile and KeyFile are the PEM client certificate and key used for
+	// mutual TLS.
+	CertFile string
+	KeyFile  string
+	// Pins are base64 SHA-256 hashes of subject public keys, optionally
+	// prefixed with sha256/. When set, a remote's verified chain must
+	// contain a pinned key.
+	Pins []string
+	// HTTPSProxy and NoProxy override the HTTPS_PROXY and NO_PROXY
+	// environment variables.
+	HTTPSProxy string
+	NoProxy    string
+}
+
+// OptionsFromConfig reads the http_, tls_ and proxy settings. Durations are
+// configured in milliseconds.
+func OptionsFromConfig() *Options {
+	return &Options{
+		ConnectTimeout:   milliseconds("http_connect_timeout"),
+		Timeout:          milliseconds("http_timeout"),
+		MaxRetries:       viper.GetInt("http_max_retries"),
+		RetryBaseDelay:   milliseconds("http_retry_base_delay"),
+		RetryMaxDelay:    milliseconds("http_retry_max_delay"),
+		BreakerThreshold: viper.GetInt("http_breaker_threshold"),
+		BreakerCooldown:  milliseconds("http_breaker_cooldown"),
+		CAFile:           viper.GetString("tls_ca_file"),
+		CertFile:         viper.GetString("tls_cert_file"),
+		KeyFile:          viper.GetString("tls_key_file"),
+		Pins:             viper.GetStringSlice("tls_pins"),
+		HTTPSProxy:       viper.GetString("https_proxy"),
+		NoProxy:          viper.GetString("no_proxy"),
+	}
+}
+
+func milliseconds(key string) time.Duration {
+	return time.Millisecond * time.Duration(viper.GetInt(key))
+}
+
+// Client wraps an http.Client with retries for idempotent requests and a
+// circuit breaker that fails fast while the remote is unavailable.
+type Client struct {
+	client   *http.Client
+	options  *Options
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	trial    bool
+}
+
+func New(options *Options) (*Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{
+		Timeout:   options.ConnectTimeout,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	transport.TLSHandshakeTimeout = options.ConnectTimeout
+	transport.Proxy = proxyFunc(options)
+
+	tlsConfig, err := tlsConfig(options)
+	if err != nil {
+		log.Errln("failed to configure TLS", err)
+		return nil, err
+	}
+	if tlsConfig != nil {
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	return &Client{
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   options.Timeout,
+		},
+		options: options,
+	}, nil
+}
+
+// ErrCircuitOpen is returned without contacting the remote while the circuit
+// breaker is open.
+var ErrCircuitOpen = errors.New("httpclient: circuit breaker open")
+
+// Do sends req. GET and HEAD requests are retried with exponential backoff and
+// jitter on transport errors, 429 and 5xx responses.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	if err := c.allow(); err != nil {
+		return nil, err
+	}
+
+	attempts := 1
+	if idempotent(req) && c.options.MaxRetries > 0 {
+		attempts += c.options.MaxRetries
+	}
+
+	var res *http.Response
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			delay := c.backoff(attempt)
+			log.Logln("retrying", req.Method, req.URL.Host+req.URL.Path, "in", delay)
+			select {
+			case <-time.After(delay):
+			case <-req.Context().Done():
+				c.abandon()
+				return nil, req.Context().Err()
+			}
+		}
+
+		res, err = c.client.Do(req)
+		if req.Context().Err() != nil {
+			c.abandon()
+			return res, err
+		}
+		if !retryable(res, err) {
+			break
+		}
+		if attempt < attempts-1 && res != nil {
+			io.Copy(ioutil.Discard, res.Body)
+			res.Body.Close()
+		}
+	}
+
+	c.record(err == nil && res.StatusCode < http.StatusInternalServerError)
+	return res, err
+}
+
+func idempotent(req *http.Request) bool {
+	return req.Method == http.MethodGet || req.Method == http.MethodHead
+}
+
+func retryable(res *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	return res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= http.StatusInternalServerError
+}
+
+// backoff returns the delay before the given retry attempt, drawn between half
+// and all of the exponentially growing delay.
+func (c *Client) backoff(attempt int) time.Duration {
+	delay := c.options.RetryBaseDelay << uint(attempt-1)
+	if delay <= 0 || delay > c.options.RetryMaxDelay {
+		delay = c.options.RetryMaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+func (c *Client) allow() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.options.BreakerThreshold <= 0 || c.failures < c.options.BreakerThreshold {
+		return nil
+	}
+
+	// a single trial request is let through once the cooldown elapsed
+	if c.trial || time.Since(c.openedAt) < c.options.BreakerCooldown {
+		return ErrCircuitOpen
+	}
+	c.trial = true
+	return nil
+}
+
+// abandon releases a trial request that was canceled by its caller.
+func (c *Client) abandon() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.trial = false
+}
+
+func (c *Client) record(success bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.trial = false
+	if success {
+		if c.failures >= c.options.BreakerThreshold && c.options.BreakerThreshold > 0 {
+			log.Logln("circuit breaker closed")
+		}
+		c.failures = 0
+		return
+	}
+
+	c.failures++
+	if c.options.BreakerThreshold > 0 && c.failures >= c.options.BreakerThreshold {
+		if c.failures == c.options.BreakerThreshold {
+			log.Warnln("circuit breaker opened after", c.failures, "failed requests")
+		}
+		c.openedAt = time.Now()
+	}
+}
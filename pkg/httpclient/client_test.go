@@ -0,0 +1,108 @@
+package httpclient
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func testOptions() *Options {
+	return &Options{
+		ConnectTimeout:   time.Second,
+		Timeout:          time.Second,
+		MaxRetries:       2,
+		RetryBaseDelay:   time.Millisecond,
+		RetryMaxDelay:    5 * time.Millisecond,
+		BreakerThreshold: 2,
+		BreakerCooldown:  time.Hour,
+	}
+}
+
+func TestDoRetriesIdempotentRequests(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client, err := New(testOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatal("expected successful request.", err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		t.Error("expected status 200. got", res.StatusCode)
+	}
+
+	if requests != 3 {
+		t.Error("expected 3 requests. got", requests)
+	}
+}
+
+func TestDoDoesNotRetryPost(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	client, err := New(testOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("POST", server.URL, nil)
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatal("expected response.", err)
+	}
+	res.Body.Close()
+
+	if requests != 1 {
+		t.Error("expected 1 request. got", requests)
+	}
+}
+
+func TestCircuitBreakerOpens(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	client, err := New(testOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest("POST", server.URL, nil)
+		res, err := client.Do(req)
+		if err != nil {
+			t.Fatal("expected response.", err)
+		}
+		res.Body.Close()
+	}
+
+	req, _ := http.NewRequest("POST", server.URL, nil)
+	if _, err := client.Do(req); !errors.Is(err, ErrCircuitOpen) {
+		t.Error("expected error", ErrCircuitOpen, "got", err)
+	}
+
+	if requests != 2 {
+		t.Error("expected 2 requests before the breaker opened. got", requests)
+	}
+}
@@ -0,0 +1,100 @@
+package httpclient
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+var (
+	ErrInvalidCABundle   = errors.New("httpclient: no certificates found in CA bundle")
+	ErrIncompleteKeyPair = errors.New("httpclient: client certificate and key must be configured together")
+	ErrPinMismatch       = errors.New("httpclient: no certificate of the remote matches a pinned key")
+)
+
+// tlsConfig builds the TLS configuration of the CA bundle, client certificate
+// and pins of options. A nil config keeps the transport's default.
+func tlsConfig(options *Options) (*tls.Config, error) {
+	if options.CAFile == "" && options.CertFile == "" && options.KeyFile == "" && len(options.Pins) == 0 {
+		return nil, nil
+	}
+
+	config := &tls.Config{}
+
+	if options.CAFile != "" {
+		bundle, err := ioutil.ReadFile(options.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(bundle) {
+			return nil, ErrInvalidCABundle
+		}
+		config.RootCAs = pool
+	}
+
+	if options.CertFile != "" || options.KeyFile != "" {
+		if options.CertFile == "" || options.KeyFile == "" {
+			return nil, ErrIncompleteKeyPair
+		}
+		cert, err := tls.LoadX509KeyPair(options.CertFile, options.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if len(options.Pins) > 0 {
+		pins := make(map[string]bool)
+		for _, pin := range options.Pins {
+			pins[strings.TrimPrefix(pin, "sha256/")] = true
+		}
+		config.VerifyPeerCertificate = func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+			for _, chain := range verifiedChains {
+				for _, cert := range chain {
+					if pins[pinOf(cert)] {
+						return nil
+					}
+				}
+			}
+			return ErrPinMismatch
+		}
+	}
+
+	return config, nil
+}
+
+// pinOf returns the base64 encoded SHA-256 hash of a certificate's subject
+// public key info.
+func pinOf(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// proxyFunc returns the proxy selection of the environment, overridden by the
+// HTTPSProxy and NoProxy options.
+func proxyFunc(options *Options) func(*http.Request) (*url.URL, error) {
+	config := httpproxy.FromEnvironment()
+	if options.HTTPSProxy != "" {
+		config.HTTPSProxy = options.HTTPSProxy
+	}
+	if options.NoProxy != "" {
+		config.NoProxy = options.NoProxy
+	}
+
+	proxy := config.ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		return proxy(req.URL)
+	}
+}
@@ -0,0 +1,72 @@
+package httpclient
+
+import (
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCABundleAndPins(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "golinksd-httpclient")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	caFile := filepath.Join(dir, "ca.pem")
+	bundle := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := ioutil.WriteFile(caFile, bundle, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(options *Options) error {
+		client, err := New(options)
+		if err != nil {
+			return err
+		}
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		res, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+		return nil
+	}
+
+	options := testOptions()
+	options.MaxRetries = 0
+	if err := get(options); err == nil {
+		t.Error("expected request to an untrusted server to fail")
+	}
+
+	options.CAFile = caFile
+	if err := get(options); err != nil {
+		t.Error("expected request trusted by the CA bundle to succeed.", err)
+	}
+
+	options.Pins = []string{"sha256/" + pinOf(server.Certificate())}
+	if err := get(options); err != nil {
+		t.Error("expected request to a pinned server to succeed.", err)
+	}
+
+	options.Pins = []string{"sha256/AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="}
+	if err := get(options); !errors.Is(err, ErrPinMismatch) {
+		t.Error("expected ErrPinMismatch for an unpinned server. got", err)
+	}
+
+	options.Pins = nil
+	options.CertFile = caFile
+	if _, err := New(options); !errors.Is(err, ErrIncompleteKeyPair) {
+		t.Error("expected ErrIncompleteKeyPair without a key. got", err)
+	}
+}
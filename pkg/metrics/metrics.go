@@ -0,0 +1,30 @@
+// Package metrics publishes the daemon's counters through expvar.
+package metrics
+
+import "expvar"
+
+var (
+	// AppendConflicts counts the appends rejected because the chain head
+	// moved, keyed by remote.
+	AppendConflicts = expvar.NewMap("append_conflicts")
+	// AppendRebases counts the staged blocks rebuilt on a new head after a
+	// conflict, keyed by remote.
+	AppendRebases = expvar.NewMap("append_rebases")
+	// TamperAlerts counts the verifications of a local chain that found a
+	// tampered or corrupted block, keyed by remote.
+	TamperAlerts = expvar.NewMap("tamper_alerts")
+	// PeerBans counts the bans of peers serving invalid blocks, keyed by
+	// peer address.
+	PeerBans = expvar.NewMap("peer_bans")
+	// PeerFailovers counts the syncs served by a peer while the remote was
+	// unavailable, keyed by remote.
+	PeerFailovers = expvar.NewMap("peer_failovers")
+)
+
+// RemoteKey returns the key a remote's counters are stored under.
+func RemoteKey(remote string) string {
+	if remote == "" {
+		return "default"
+	}
+	return remote
+}
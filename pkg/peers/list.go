@@ -0,0 +1,90 @@
+package peers
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/govice/golinksd/pkg/atomicfile"
+	"github.com/govice/golinksd/pkg/log"
+)
+
+// ErrInvalidAddress indicates a peer address that is not an http or https URL.
+var ErrInvalidAddress = errors.New("peers: invalid peer address")
+
+// List is the content of the file holding the managed peers.
+type List struct {
+	Peers []string `json:"peers"`
+}
+
+// ReadList reads the managed peers from path. A missing file holds no peers,
+// and a file that cannot be parsed is quarantined and read as empty.
+func ReadList(path string) (*List, error) {
+	listBytes, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &List{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	list := &List{}
+	if err := json.Unmarshal(listBytes, list); err != nil {
+		log.Errln("peer list", path, "is unreadable, initializing with no managed peers:", err)
+		if _, err := atomicfile.Quarantine(path); err != nil {
+			return nil, err
+		}
+		return &List{}, nil
+	}
+	return list, nil
+}
+
+// WriteList replaces the managed peers in path.
+func WriteList(path string, list *List) error {
+	listBytes, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(path, listBytes, 0644)
+}
+
+// Add adds address to the list, reporting whether it was not listed yet.
+func (l *List) Add(address string) bool {
+	for _, listed := range l.Peers {
+		if listed == address {
+			return false
+		}
+	}
+	l.Peers = append(l.Peers, address)
+	return true
+}
+
+// Remove removes address from the list, reporting whether it was listed.
+func (l *List) Remove(address string) bool {
+	for i, listed := range l.Peers {
+		if listed == address {
+			l.Peers = append(l.Peers[:i], l.Peers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// NormalizeAddress returns address as a base URL without a trailing slash. An
+// address without a scheme is an http URL.
+func NormalizeAddress(address string) (string, error) {
+	address = strings.TrimSuffix(strings.TrimSpace(address), "/")
+	if address == "" {
+		return "", ErrInvalidAddress
+	}
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+	u, err := url.Parse(address)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", ErrInvalidAddress
+	}
+	return address, nil
+}
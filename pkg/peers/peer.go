@@ -0,0 +1,196 @@
+package peers
+
+import (
+	"sync"
+	"time"
+
+	"github.com/govice/golinksd/pkg/golinks"
+	"github.com/govice/golinksd/pkg/httpclient"
+	"github.com/spf13/viper"
+)
+
+// Source tells how a peer became known to the daemon.
+type Source string
+
+const (
+	// SourceConfig peers are listed in the peers setting and are read-only.
+	SourceConfig Source = "config"
+	// SourceManaged peers are added and removed through the CLI or API and
+	// persisted to the peer list file.
+	SourceManaged Source = "managed"
+	// SourceAnnounced peers announced themselves through gossip and are not
+	// persisted.
+	SourceAnnounced Source = "announced"
+)
+
+// Peer is another golinksd node serving its chain on its peer_port.
+type Peer struct {
+	address string
+	chain   *golinks.Service
+	client  *httpclient.Client
+
+	mu            sync.Mutex
+	source        Source
+	length        int
+	headHash      []byte
+	lastSeen      time.Time
+	announcedAt   time.Time
+	lastProbe     time.Time
+	probeErr      error
+	invalidBlocks int
+	bannedUntil   time.Time
+}
+
+// newPeer returns a peer with its own client, so that the circuit breaker of
+// an unavailable peer does not affect the others.
+func newPeer(address string, source Source) (*Peer, error) {
+	client, err := httpclient.New(httpclient.OptionsFromConfig())
+	if err != nil {
+		return nil, err
+	}
+	peer := &Peer{
+		address: address,
+		client:  client,
+		source:  source,
+		length:  -1,
+	}
+	chain, err := golinks.New(peer)
+	if err != nil {
+		return nil, err
+	}
+	peer.chain = chain
+	return peer, nil
+}
+
+// Address returns the base URL of the peer's chain.
+func (p *Peer) Address() string {
+	return p.address
+}
+
+// Chain returns the backend reading the peer's chain.
+func (p *Peer) Chain() *golinks.Service {
+	return p.chain
+}
+
+// Client returns the client requests to the peer are sent with.
+func (p *Peer) Client() *httpclient.Client {
+	return p.client
+}
+
+// Source returns how the peer became known.
+func (p *Peer) Source() Source {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.source
+}
+
+// SetHead records the head of the peer's chain, as announced by the peer or
+// read by a probe.
+func (p *Peer) SetHead(length int, headHash []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.length = length
+	p.headHash = headHash
+	p.lastSeen = time.Now()
+}
+
+// Length returns the length of the peer's chain when it was last seen, or -1
+// if it was never seen.
+func (p *Peer) Length() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.length
+}
+
+// Healthy reports whether the peer's last probe succeeded and it is not
+// banned.
+func (p *Peer) Healthy() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return !p.lastProbe.IsZero() && p.probeErr == nil && !p.bannedLocked()
+}
+
+// Banned reports whether the peer is banned.
+func (p *Peer) Banned() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.bannedLocked()
+}
+
+func (p *Peer) bannedLocked() bool {
+	return time.Now().Before(p.bannedUntil)
+}
+
+// Token returns the authority_token peers share.
+func (p *Peer) Token() string {
+	return viper.GetString("authority_token")
+}
+
+func (p *Peer) Name() string {
+	return p.address
+}
+
+func (p *Peer) ChainLengthEndpoint() string {
+	return p.address + "/chain/length"
+}
+
+func (p *Peer) ChainBlockEndpoint() string {
+	return p.address + "/chain"
+}
+
+func (p *Peer) ChainRangeEndpoint() string {
+	return p.address + "/chain/range"
+}
+
+func (p *Peer) BlobEndpoint() string {
+	return p.address + "/blobs"
+}
+
+// Status describes a peer for the API and console.
+type Status struct {
+	Address  string `json:"address"`
+	Source   Source `json:"source"`
+	Healthy  bool   `json:"healthy"`
+	Length   int    `json:"length"`
+	HeadHash []byte `json:"head_hash,omitempty"`
+	// Lag is the number of blocks the peer's chain is behind the local
+	// chain, negative when the peer is ahead.
+	Lag           int        `json:"lag"`
+	LastSeen      *time.Time `json:"last_seen,omitempty"`
+	LastProbe     *time.Time `json:"last_probe,omitempty"`
+	ProbeError    string     `json:"probe_error,omitempty"`
+	InvalidBlocks int        `json:"invalid_blocks"`
+	BannedUntil   *time.Time `json:"banned_until,omitempty"`
+}
+
+func (p *Peer) status(localLength int) Status {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	status := Status{
+		Address:       p.address,
+		Source:        p.source,
+		Healthy:       !p.lastProbe.IsZero() && p.probeErr == nil && !p.bannedLocked(),
+		Length:        p.length,
+		HeadHash:      p.headHash,
+		InvalidBlocks: p.invalidBlocks,
+	}
+	if p.length >= 0 {
+		status.Lag = localLength - p.length
+	}
+	if !p.lastSeen.IsZero() {
+		lastSeen := p.lastSeen
+		status.LastSeen = &lastSeen
+	}
+	if !p.lastProbe.IsZero() {
+		lastProbe := p.lastProbe
+		status.LastProbe = &lastProbe
+	}
+	if p.probeErr != nil {
+		status.ProbeError = p.probeErr.Error()
+	}
+	if p.bannedLocked() {
+		bannedUntil := p.bannedUntil
+		status.BannedUntil = &bannedUntil
+	}
+	return status
+}
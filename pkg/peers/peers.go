@@ -0,0 +1,502 @@
+// Package peers manages the golinksd nodes the daemon exchanges its chain
+// with. Peers are listed in the peers setting, managed through the CLI and API
+// in the peer list file, or announced through gossip. Every peer is probed
+// periodically, and peers serving invalid blocks are banned.
+package peers
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/govice/golinks/block"
+	"github.com/govice/golinksd/pkg/blockchain"
+	"github.com/govice/golinksd/pkg/chainbackend"
+	"github.com/govice/golinksd/pkg/log"
+	"github.com/govice/golinksd/pkg/metrics"
+	"github.com/spf13/viper"
+)
+
+var (
+	ErrUnknownPeer = errors.New("peers: unknown peer")
+	// ErrReadOnlyPeer indicates a peer listed in the peers setting, which can
+	// only be removed from the config file.
+	ErrReadOnlyPeer = errors.New("peers: peer is listed in the peers setting")
+	// ErrSelfPeer indicates a peer address referring to this daemon.
+	ErrSelfPeer   = errors.New("peers: peer address refers to this daemon")
+	ErrBannedPeer = errors.New("peers: peer is banned")
+	// ErrTooManyPeers indicates an announcement from a new peer while
+	// announced_peer_limit announced peers are known.
+	ErrTooManyPeers = errors.New("peers: too many announced peers")
+
+	ErrInvalidProbePeriod = errors.New("peers: probe period must be positive")
+)
+
+type BlockchainServicer interface {
+	BlockchainService() *blockchain.Service
+}
+
+type Servicer interface {
+	BlockchainServicer
+}
+
+type Service struct {
+	servicer Servicer
+	path     string
+	address  string
+
+	// listMu serializes changes to the peer list file
+	listMu sync.Mutex
+	mu     sync.Mutex
+	peers  map[string]*Peer
+	ticker *time.Ticker
+}
+
+// New returns a service managing the peers in the peers setting and in the
+// peer list file at path.
+func New(servicer Servicer, path string) (*Service, error) {
+	s := &Service{
+		servicer: servicer,
+		path:     path,
+		peers:    make(map[string]*Peer),
+	}
+	if address := viper.GetString("peer_address"); address != "" {
+		normalized, err := NormalizeAddress(address)
+		if err != nil {
+			log.Errln("invalid peer_address", address)
+			return nil, err
+		}
+		s.address = normalized
+	}
+
+	for _, address := range viper.GetStringSlice("peers") {
+		address, err := NormalizeAddress(address)
+		if err != nil {
+			log.Errln("invalid address in peers setting", address)
+			return nil, err
+		}
+		if _, err := s.addLocked(address, SourceConfig); err != nil && !errors.Is(err, ErrSelfPeer) {
+			return nil, err
+		}
+	}
+	if err := s.reload(); err != nil {
+		log.Errln("failed to read peer list", path)
+		return nil, err
+	}
+	return s, nil
+}
+
+// Address returns the address the daemon announces to its peers, or an empty
+// string if peers derive it from the source of announcements.
+func (s *Service) Address() string {
+	return s.address
+}
+
+// addLocked adds a peer at a normalized address. The caller holds s.mu or
+// has not shared s yet.
+func (s *Service) addLocked(address string, source Source) (*Peer, error) {
+	if address == s.address {
+		return nil, ErrSelfPeer
+	}
+	peer, err := newPeer(address, source)
+	if err != nil {
+		return nil, err
+	}
+	s.peers[address] = peer
+	log.Logln("added", source, "peer", address)
+	return peer, nil
+}
+
+// reload applies the peer list file to the managed peers.
+func (s *Service) reload() error {
+	list, err := ReadList(s.path)
+	if err != nil {
+		return err
+	}
+	managed := make(map[string]bool)
+	for _, listed := range list.Peers {
+		address, err := NormalizeAddress(listed)
+		if err != nil {
+			log.Warnln("ignoring invalid address in peer list", listed)
+			continue
+		}
+		managed[address] = true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for address := range managed {
+		peer, ok := s.peers[address]
+		if !ok {
+			if _, err := s.addLocked(address, SourceManaged); err != nil && !errors.Is(err, ErrSelfPeer) {
+				return err
+			}
+			continue
+		}
+		peer.mu.Lock()
+		if peer.source == SourceAnnounced {
+			peer.source = SourceManaged
+		}
+		peer.mu.Unlock()
+	}
+	for address, peer := range s.peers {
+		if peer.Source() == SourceManaged && !managed[address] {
+			delete(s.peers, address)
+			log.Logln("removed peer", address)
+		}
+	}
+	return nil
+}
+
+// Add adds the peer at address to the peer list file.
+func (s *Service) Add(address string) (*Peer, error) {
+	address, err := NormalizeAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	if address == s.address {
+		return nil, ErrSelfPeer
+	}
+
+	s.listMu.Lock()
+	defer s.listMu.Unlock()
+	list, err := ReadList(s.path)
+	if err != nil {
+		return nil, err
+	}
+	if list.Add(address) {
+		if err := WriteList(s.path, list); err != nil {
+			log.Errln("failed to write peer list", s.path)
+			return nil, err
+		}
+	}
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	return s.Peer(address)
+}
+
+// Remove removes the peer at address. Peers listed in the peers setting
+// cannot be removed.
+func (s *Service) Remove(address string) error {
+	address, err := NormalizeAddress(address)
+	if err != nil {
+		return err
+	}
+	peer, err := s.Peer(address)
+	if err != nil {
+		return err
+	}
+
+	switch peer.Source() {
+	case SourceConfig:
+		return ErrReadOnlyPeer
+	case SourceAnnounced:
+		s.mu.Lock()
+		delete(s.peers, address)
+		s.mu.Unlock()
+		log.Logln("removed peer", address)
+		return nil
+	}
+
+	s.listMu.Lock()
+	defer s.listMu.Unlock()
+	list, err := ReadList(s.path)
+	if err != nil {
+		return err
+	}
+	if list.Remove(address) {
+		if err := WriteList(s.path, list); err != nil {
+			log.Errln("failed to write peer list", s.path)
+			return err
+		}
+	}
+	return s.reload()
+}
+
+// Discover returns the peer at address, adding it as an announced peer if it
+// is not known yet. At most announced_peer_limit announced peers are kept.
+func (s *Service) Discover(address string) (*Peer, error) {
+	address, err := NormalizeAddress(address)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	peer, ok := s.peers[address]
+	if !ok {
+		s.expireLocked()
+		if s.announcedLocked() >= viper.GetInt("announced_peer_limit") {
+			return nil, ErrTooManyPeers
+		}
+		if peer, err = s.addLocked(address, SourceAnnounced); err != nil {
+			return nil, err
+		}
+	}
+	if peer.Banned() {
+		return nil, ErrBannedPeer
+	}
+	peer.mu.Lock()
+	peer.announcedAt = time.Now()
+	peer.mu.Unlock()
+	return peer, nil
+}
+
+// announcedLocked returns the number of announced peers. The caller holds
+// s.mu.
+func (s *Service) announcedLocked() int {
+	n := 0
+	for _, peer := range s.peers {
+		if peer.Source() == SourceAnnounced {
+			n++
+		}
+	}
+	return n
+}
+
+// expireLocked removes the announced peers that have not announced themselves
+// for announced_peer_expiry milliseconds. The caller holds s.mu.
+func (s *Service) expireLocked() {
+	expiry := time.Millisecond * time.Duration(viper.GetInt("announced_peer_expiry"))
+	for address, peer := range s.peers {
+		peer.mu.Lock()
+		expired := peer.source == SourceAnnounced && time.Since(peer.announcedAt) > expiry
+		peer.mu.Unlock()
+		if expired {
+			delete(s.peers, address)
+			log.Logln("removed announced peer", address, "not seen for", expiry)
+		}
+	}
+}
+
+// Peer returns the peer at address.
+func (s *Service) Peer(address string) (*Peer, error) {
+	address, err := NormalizeAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	peer, ok := s.peers[address]
+	if !ok {
+		return nil, ErrUnknownPeer
+	}
+	return peer, nil
+}
+
+// Peers returns the known peers ordered by address.
+func (s *Service) Peers() []*Peer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	peers := make([]*Peer, 0, len(s.peers))
+	for _, peer := range s.peers {
+		peers = append(peers, peer)
+	}
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].address < peers[j].address
+	})
+	return peers
+}
+
+// Healthy returns the healthy peers, longest chain first.
+func (s *Service) Healthy() []*Peer {
+	var healthy []*Peer
+	for _, peer := range s.Peers() {
+		if peer.Healthy() {
+			healthy = append(healthy, peer)
+		}
+	}
+	sort.SliceStable(healthy, func(i, j int) bool {
+		return healthy[i].Length() > healthy[j].Length()
+	})
+	return healthy
+}
+
+// Status describes every known peer, ordered by address.
+func (s *Service) Status() []Status {
+	localLength := s.servicer.BlockchainService().ChainLength()
+	statuses := []Status{}
+	for _, peer := range s.Peers() {
+		statuses = append(statuses, peer.status(localLength))
+	}
+	return statuses
+}
+
+// ReportInvalid records that the peer served an invalid block or chain. The
+// peer is banned for peer_ban_period milliseconds after peer_ban_threshold
+// consecutive reports.
+func (s *Service) ReportInvalid(peer *Peer) {
+	peer.mu.Lock()
+	defer peer.mu.Unlock()
+	peer.invalidBlocks++
+	log.Warnln("peer", peer.address, "served invalid blocks", peer.invalidBlocks, "times")
+	if peer.invalidBlocks < viper.GetInt("peer_ban_threshold") {
+		return
+	}
+	banPeriod := time.Millisecond * time.Duration(viper.GetInt("peer_ban_period"))
+	peer.bannedUntil = time.Now().Add(banPeriod)
+	peer.invalidBlocks = 0
+	metrics.PeerBans.Add(peer.address, 1)
+	log.Warnln("banned peer", peer.address, "for", banPeriod)
+}
+
+// ReportValid records that the peer served a valid chain, resetting its
+// count of invalid reports.
+func (s *Service) ReportValid(peer *Peer) {
+	peer.mu.Lock()
+	defer peer.mu.Unlock()
+	peer.invalidBlocks = 0
+}
+
+// Mirrors returns the failover source of the peers at addresses, which serve
+// a copy of a remote's chain.
+func (s *Service) Mirrors(addresses []string) (*Mirrors, error) {
+	mirrors := &Mirrors{service: s, addresses: make(map[string]bool)}
+	for _, address := range addresses {
+		normalized, err := NormalizeAddress(address)
+		if err != nil {
+			log.Errln("invalid mirror peer address", address)
+			return nil, err
+		}
+		if _, err := s.Peer(normalized); err != nil {
+			log.Warnln("mirror peer", normalized, "is not listed in peers or the peer list")
+		}
+		mirrors.addresses[normalized] = true
+	}
+	return mirrors, nil
+}
+
+// Mirrors is a failover source of the peers serving a copy of a remote's
+// chain. Other peers serve their own chain, so a remote's tracker never fails
+// over to them.
+type Mirrors struct {
+	service   *Service
+	addresses map[string]bool
+}
+
+// FailoverBackends returns the chains of the healthy mirror peers, longest
+// first.
+func (m *Mirrors) FailoverBackends() []chainbackend.ChainBackend {
+	var backends []chainbackend.ChainBackend
+	for _, peer := range m.service.Healthy() {
+		if m.addresses[peer.address] {
+			backends = append(backends, peer.chain)
+		}
+	}
+	return backends
+}
+
+// ReportInvalidBackend records that the mirror peer serving backend served an
+// invalid block.
+func (m *Mirrors) ReportInvalidBackend(backend chainbackend.ChainBackend) {
+	for _, peer := range m.service.Peers() {
+		if m.addresses[peer.address] && chainbackend.ChainBackend(peer.chain) == backend {
+			m.service.ReportInvalid(peer)
+			return
+		}
+	}
+}
+
+// Execute probes the peers every peer_probe_period milliseconds until ctx is
+// done. The peer list file is read again before every round, so peers added
+// or removed through the CLI are picked up.
+func (s *Service) Execute(ctx context.Context) error {
+	ticker := time.NewTicker(time.Hour)
+	ticker.Stop()
+	s.mu.Lock()
+	s.ticker = ticker
+	s.mu.Unlock()
+	s.resetProbePeriod(viper.GetInt("peer_probe_period"))
+	defer func() {
+		s.mu.Lock()
+		s.ticker.Stop()
+		s.ticker = nil
+		s.mu.Unlock()
+	}()
+
+	s.probeAll()
+	for {
+		select {
+		case <-ticker.C:
+			s.listMu.Lock()
+			err := s.reload()
+			s.listMu.Unlock()
+			if err != nil {
+				log.Errln("failed to read peer list", s.path, err)
+			}
+			s.mu.Lock()
+			s.expireLocked()
+			s.mu.Unlock()
+			s.probeAll()
+		case <-ctx.Done():
+			log.Logln("received termination on peers context")
+			return nil
+		}
+	}
+}
+
+// SetProbePeriod applies a new probe period, in milliseconds.
+func (s *Service) SetProbePeriod(probePeriod int) error {
+	if probePeriod <= 0 {
+		return ErrInvalidProbePeriod
+	}
+	s.resetProbePeriod(probePeriod)
+	return nil
+}
+
+func (s *Service) resetProbePeriod(probePeriod int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ticker == nil || probePeriod <= 0 {
+		return
+	}
+	s.ticker.Reset(time.Millisecond * time.Duration(probePeriod))
+}
+
+// probeAll probes every peer concurrently.
+func (s *Service) probeAll() {
+	var wg sync.WaitGroup
+	for _, peer := range s.Peers() {
+		peer := peer
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.probe(peer)
+		}()
+	}
+	wg.Wait()
+}
+
+// probe reads the head of the peer's chain and records whether the peer is
+// reachable.
+func (s *Service) probe(peer *Peer) {
+	length, err := peer.chain.GetLength()
+	var headHash []byte
+	if err == nil && length > 0 {
+		var head *block.Block
+		head, err = peer.chain.GetBlock(length - 1)
+		if err == nil {
+			headHash = head.BlockHash
+		}
+	}
+
+	peer.mu.Lock()
+	wasHealthy := !peer.lastProbe.IsZero() && peer.probeErr == nil
+	peer.lastProbe = time.Now()
+	peer.probeErr = err
+	peer.mu.Unlock()
+
+	if err != nil {
+		if wasHealthy {
+			log.Warnln("peer", peer.address, "is unhealthy", err)
+		}
+		return
+	}
+	if !wasHealthy {
+		log.Logln("peer", peer.address, "is healthy")
+	}
+	peer.SetHead(length, headHash)
+}
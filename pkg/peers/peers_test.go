@@ -0,0 +1,261 @@
+package peers
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/govice/golinksd/pkg/blockchain"
+	"github.com/govice/golinksd/pkg/chainbackend"
+	"github.com/spf13/viper"
+)
+
+type testServicer struct {
+	blockchainService *blockchain.Service
+}
+
+func (s *testServicer) BlockchainService() *blockchain.Service {
+	return s.blockchainService
+}
+
+func TestPeers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "golinksd-peers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	bs, err := blockchain.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bs.ResetChain(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := bs.AddBlock([]byte("data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the managed peer serves the first two blocks of the local chain
+	mux := http.NewServeMux()
+	mux.HandleFunc("/chain/length", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]int{"length": 2})
+	})
+	mux.HandleFunc("/chain", func(w http.ResponseWriter, r *http.Request) {
+		index, _ := strconv.Atoi(r.URL.Query().Get("index"))
+		blk, err := bs.GetBlock(index)
+		if err != nil || index >= 2 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(blk)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	unreachable := httptest.NewServer(mux)
+	unreachable.Close()
+
+	viper.Set("peers", []string{unreachable.URL + "/"})
+	viper.Set("peer_address", "localhost:9000")
+	viper.Set("peer_ban_threshold", 2)
+	viper.Set("peer_ban_period", 60000)
+	defer viper.Set("peers", nil)
+	defer viper.Set("peer_address", nil)
+	defer viper.Set("peer_ban_threshold", nil)
+	defer viper.Set("peer_ban_period", nil)
+
+	path := filepath.Join(dir, "peers.json")
+	ps, err := New(&testServicer{blockchainService: bs}, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ps.Add("http://localhost:9000"); !errors.Is(err, ErrSelfPeer) {
+		t.Error("expected ErrSelfPeer adding the daemon's own address. got", err)
+	}
+	if _, err := ps.Add("ftp://example.com"); !errors.Is(err, ErrInvalidAddress) {
+		t.Error("expected ErrInvalidAddress for a non http address. got", err)
+	}
+
+	peer, err := ps.Add(server.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if peer.Address() != server.URL || peer.Source() != SourceManaged {
+		t.Error("expected managed peer at", server.URL, "got", peer.Address(), peer.Source())
+	}
+	list, err := ReadList(path)
+	if err != nil || len(list.Peers) != 1 || list.Peers[0] != server.URL {
+		t.Error("expected peer list file to hold the added peer. got", list, err)
+	}
+
+	if err := ps.Remove(unreachable.URL); !errors.Is(err, ErrReadOnlyPeer) {
+		t.Error("expected ErrReadOnlyPeer removing a configured peer. got", err)
+	}
+
+	ps.probeAll()
+	statuses := ps.Status()
+	if len(statuses) != 2 {
+		t.Fatal("expected 2 peers. got", len(statuses))
+	}
+	for _, status := range statuses {
+		switch status.Address {
+		case server.URL:
+			if !status.Healthy || status.Length != 2 || status.Lag != 1 || status.LastSeen == nil {
+				t.Errorf("expected healthy peer of length 2 lagging 1 block. got %+v", status)
+			}
+		case unreachable.URL:
+			if status.Healthy || status.ProbeError == "" {
+				t.Errorf("expected unhealthy unreachable peer. got %+v", status)
+			}
+		}
+	}
+	if healthy := ps.Healthy(); len(healthy) != 1 || healthy[0] != peer {
+		t.Error("expected only the managed peer to be healthy")
+	}
+
+	ps.ReportInvalid(peer)
+	if peer.Banned() {
+		t.Error("expected peer not to be banned below the ban threshold")
+	}
+	ps.ReportInvalid(peer)
+	if !peer.Banned() || peer.Healthy() {
+		t.Error("expected peer to be banned at the ban threshold")
+	}
+	if _, err := ps.Discover(server.URL); !errors.Is(err, ErrBannedPeer) {
+		t.Error("expected ErrBannedPeer discovering a banned peer. got", err)
+	}
+
+	if err := ps.Remove(server.URL); err != nil {
+		t.Fatal(err)
+	}
+	if list, err := ReadList(path); err != nil || len(list.Peers) != 0 {
+		t.Error("expected peer list file to be empty after removal. got", list, err)
+	}
+	if _, err := ps.Peer(server.URL); !errors.Is(err, ErrUnknownPeer) {
+		t.Error("expected removed peer to be unknown. got", err)
+	}
+}
+
+func TestAnnouncedPeers(t *testing.T) {
+	viper.Set("announced_peer_limit", 2)
+	defer viper.Set("announced_peer_limit", nil)
+	viper.Set("announced_peer_expiry", 60000)
+	defer viper.Set("announced_peer_expiry", nil)
+
+	dir, err := ioutil.TempDir("", "golinksd-peers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := New(&testServicer{}, filepath.Join(dir, "peers.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, address := range []string{"10.0.0.1:7777", "10.0.0.2:7777"} {
+		if _, err := s.Discover(address); err != nil {
+			t.Fatal("expected announced peer to be added.", err)
+		}
+	}
+	if _, err := s.Discover("10.0.0.3:7777"); !errors.Is(err, ErrTooManyPeers) {
+		t.Error("expected ErrTooManyPeers past the limit. got", err)
+	}
+	if _, err := s.Add("10.0.0.4:7777"); err != nil {
+		t.Error("expected managed peers not to count toward the limit.", err)
+	}
+
+	// a peer that stopped announcing itself expires and makes room
+	stale, err := s.Peer("10.0.0.1:7777")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.mu.Lock()
+	stale.announcedAt = time.Now().Add(-2 * time.Minute)
+	stale.mu.Unlock()
+	if _, err := s.Discover("10.0.0.3:7777"); err != nil {
+		t.Error("expected announced peer to replace an expired one.", err)
+	}
+	if _, err := s.Peer("10.0.0.1:7777"); !errors.Is(err, ErrUnknownPeer) {
+		t.Error("expected the expired peer to be removed. got", err)
+	}
+}
+
+func TestMirrors(t *testing.T) {
+	viper.Set("peer_ban_threshold", 1)
+	defer viper.Set("peer_ban_threshold", nil)
+	viper.Set("peer_ban_period", 60000)
+	defer viper.Set("peer_ban_period", nil)
+
+	dir, err := ioutil.TempDir("", "golinksd-peers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	bs, err := blockchain.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bs.ResetChain(); err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/chain/length", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]int{"length": 1})
+	})
+	mux.HandleFunc("/chain", func(w http.ResponseWriter, r *http.Request) {
+		blk, _ := bs.GetBlock(0)
+		json.NewEncoder(w).Encode(blk)
+	})
+	mirrorServer := httptest.NewServer(mux)
+	defer mirrorServer.Close()
+	otherServer := httptest.NewServer(mux)
+	defer otherServer.Close()
+
+	s, err := New(&testServicer{blockchainService: bs}, filepath.Join(dir, "peers.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mirror, err := s.Add(mirrorServer.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := s.Add(otherServer.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.probeAll()
+	if len(s.Healthy()) != 2 {
+		t.Fatal("expected both peers to be healthy")
+	}
+
+	mirrors, err := s.Mirrors([]string{mirrorServer.URL + "/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	backends := mirrors.FailoverBackends()
+	if len(backends) != 1 || backends[0] != chainbackend.ChainBackend(mirror.Chain()) {
+		t.Error("expected only the mirror peer to be failed over to. got", backends)
+	}
+
+	mirrors.ReportInvalidBackend(other.Chain())
+	if other.Banned() {
+		t.Error("expected reports of peers that are not mirrors to be ignored")
+	}
+	mirrors.ReportInvalidBackend(mirror.Chain())
+	if !mirror.Banned() {
+		t.Error("expected the mirror peer to be banned at the ban threshold")
+	}
+}
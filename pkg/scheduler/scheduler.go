@@ -16,16 +16,22 @@ type Task interface {
 }
 
 type Scheduler struct {
-	id    string
-	queue []Task
-	sem   chan struct{}
-	mu    sync.Mutex
+	id      string
+	queue   []Task
+	ceiling int
+	running int
+	wake    chan struct{}
+	mu      sync.Mutex
 }
 
 func New(concurrencyCeiling int) (*Scheduler, error) {
+	if concurrencyCeiling < 1 {
+		return nil, ErrInvalidCeiling
+	}
 	return &Scheduler{
-		id:  xid.NewWithTime(time.Now()).String(),
-		sem: make(chan struct{}, concurrencyCeiling),
+		id:      xid.NewWithTime(time.Now()).String(),
+		ceiling: concurrencyCeiling,
+		wake:    make(chan struct{}, 1),
 	}, nil
 }
 
@@ -35,16 +41,41 @@ func (s *Scheduler) Schedule(task Task) error {
 	s.mu.Lock()
 	for _, t := range s.queue {
 		if t.ID() == task.ID() {
+			s.mu.Unlock()
 			return ErrTaskScheduled
 		}
 	}
 
 	s.queue = append(s.queue, task)
 	s.mu.Unlock()
+	s.notify()
 
 	return nil
 }
 
+var ErrInvalidCeiling = errors.New("ErrInvalidCeiling: concurrency ceiling must be at least 1")
+
+// Resize changes the number of tasks allowed to run concurrently. Running tasks
+// are not interrupted when the ceiling shrinks.
+func (s *Scheduler) Resize(concurrencyCeiling int) error {
+	if concurrencyCeiling < 1 {
+		return ErrInvalidCeiling
+	}
+	s.mu.Lock()
+	s.ceiling = concurrencyCeiling
+	s.mu.Unlock()
+	s.notify()
+	return nil
+}
+
+// notify wakes Run without blocking when it is already awake.
+func (s *Scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
 func (s *Scheduler) Run(c context.Context) {
 	var wg sync.WaitGroup
 
@@ -54,36 +85,43 @@ func (s *Scheduler) Run(c context.Context) {
 	}()
 
 	for {
-		if len(s.queue) == 0 {
-			// log.Logln("scheduler queue empty...")
-			time.Sleep(1 * time.Second)
+		s.mu.Lock()
+		if len(s.queue) == 0 || s.running >= s.ceiling {
+			s.mu.Unlock()
+			select {
+			case <-s.wake:
+			case <-time.After(1 * time.Second):
+			case <-c.Done():
+				log.Logln(s.id, "stopping scheduler limiter")
+				return
+			}
 			continue
 		}
-		select {
-		case s.sem <- struct{}{}:
-			var t Task
-			if len(s.queue) > 1 {
-				s.mu.Lock()
-				t, s.queue = s.queue[0], s.queue[1:]
-				s.mu.Unlock()
-			} else if len(s.queue) == 1 {
-				s.mu.Lock()
-				t, s.queue = s.queue[0], nil
-				s.mu.Unlock()
-			}
 
-			wg.Add(1)
-			go func() {
-				log.Logln(t.ID(), "executing...")
-				if err := t.Work()(); err != nil {
-					log.Errln(t.ID(), "failed")
-				}
-				<-s.sem
-				wg.Done()
-			}()
+		select {
 		case <-c.Done():
+			s.mu.Unlock()
 			log.Logln(s.id, "stopping scheduler limiter")
 			return
+		default:
 		}
+
+		var t Task
+		t, s.queue = s.queue[0], s.queue[1:]
+		s.running++
+		s.mu.Unlock()
+
+		wg.Add(1)
+		go func() {
+			log.Logln(t.ID(), "executing...")
+			if err := t.Work()(); err != nil {
+				log.Errln(t.ID(), "failed")
+			}
+			s.mu.Lock()
+			s.running--
+			s.mu.Unlock()
+			s.notify()
+			wg.Done()
+		}()
 	}
 }
@@ -0,0 +1,133 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/govice/golinksd/pkg/log"
+)
+
+// DeclaredReader reads the desired state of workers declared outside of the
+// mutable worker config.
+type DeclaredReader interface {
+	ReadDeclared() ([]*NewWorkerConfig, error)
+}
+
+var ErrReadOnlyWorker = errors.New("worker is declared in a file and is read-only")
+
+// SetDeclaredReader enables reconciliation of declared workers against reader
+// every reconcilePeriod. It must be called before Execute.
+func (w *Service) SetDeclaredReader(reader DeclaredReader, reconcilePeriod time.Duration) {
+	w.declared = reader
+	w.reconcilePeriod = reconcilePeriod
+}
+
+// executeReconciler reconciles declared workers until ctx is done.
+func (w *Service) executeReconciler(ctx context.Context) {
+	ticker := time.NewTicker(w.reconcilePeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := w.reconcileDeclared(); err != nil {
+				log.Errln("failed to reconcile declared workers", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (w *Service) reconcileDeclared() error {
+	declared, err := w.declared.ReadDeclared()
+	if err != nil {
+		return err
+	}
+	if err := w.Reconcile(declared); err != nil {
+		return err
+	}
+	if w.ctx == nil {
+		return nil
+	}
+	return w.startWorkers(w.ctx)
+}
+
+// Reconcile adds, updates and removes read-only workers so that they match
+// declared. Declarations without a root path, with a generation period that is
+// not positive or for an unknown remote are skipped. Reconciling the same
+// declarations again changes nothing.
+func (w *Service) Reconcile(declared []*NewWorkerConfig) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	desired := make(map[string]*NewWorkerConfig)
+	for _, config := range declared {
+		if config.WorkerID == "" || config.Source == "" {
+			log.Errln("skipping declared worker without id or source:", config.RootPath)
+			continue
+		}
+		if config.RootPath == "" {
+			log.Errln("skipping declared worker", config.WorkerID, "without root path from", config.Source)
+			continue
+		}
+		if config.GenerationPeriod <= 0 {
+			log.Errln("skipping declared worker", config.WorkerID, "with generation period", config.GenerationPeriod, "from", config.Source)
+			continue
+		}
+		if _, err := w.servicer.ConfigService().Remote(config.Remote); err != nil {
+			log.Errln("skipping declared worker", config.WorkerID, "for remote", config.Remote, "from", config.Source, err)
+			continue
+		}
+		desired[config.WorkerID] = config
+	}
+
+	var workers []*Worker
+	for _, worker := range w.WorkerConfig.Workers {
+		if !worker.ReadOnly() {
+			if _, ok := desired[worker.WorkerID]; ok {
+				log.Errln("declared worker", worker.WorkerID, "conflicts with a worker in the worker config")
+				delete(desired, worker.WorkerID)
+			}
+			workers = append(workers, worker)
+			continue
+		}
+
+		config, ok := desired[worker.WorkerID]
+		if !ok {
+			log.Logln("removing declared worker", worker.WorkerID)
+			worker.cancelFunc()
+			continue
+		}
+		delete(desired, worker.WorkerID)
+
+		if worker.matches(config) {
+			workers = append(workers, worker)
+			continue
+		}
+
+		log.Logln("updating declared worker", worker.WorkerID, "from", config.Source)
+		worker.cancelFunc()
+		updated, err := NewWorker(w.servicer, config, w.LogWriterProducer)
+		if err != nil {
+			return err
+		}
+		workers = append(workers, updated)
+	}
+
+	for _, config := range declared {
+		if _, ok := desired[config.WorkerID]; !ok {
+			continue
+		}
+		log.Logln("adding declared worker", config.WorkerID, "from", config.Source)
+		worker, err := NewWorker(w.servicer, config, w.LogWriterProducer)
+		if err != nil {
+			return err
+		}
+		workers = append(workers, worker)
+		delete(desired, config.WorkerID)
+	}
+
+	w.WorkerConfig.Workers = workers
+	return nil
+}
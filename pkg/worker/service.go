@@ -2,16 +2,22 @@ package worker
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"io"
 	"os"
 	"path/filepath"
 	"runtime"
 	"sync"
+	"time"
 
+	"github.com/govice/golinks/block"
+	"github.com/govice/golinks/blockmap"
+	"github.com/govice/golinksd/pkg/blobstore"
+	"github.com/govice/golinksd/pkg/blockref"
+	"github.com/govice/golinksd/pkg/chainbackend"
 	"github.com/govice/golinksd/pkg/chaintracker"
 	"github.com/govice/golinksd/pkg/config"
-	"github.com/govice/golinksd/pkg/golinks"
 	"github.com/govice/golinksd/pkg/log"
 	"github.com/govice/golinksd/pkg/scheduler"
 	"golang.org/x/sync/errgroup"
@@ -25,6 +31,8 @@ type Service struct {
 	scheduler         *scheduler.Scheduler
 	servicer          Servicer
 	crw               ConfigReaderWriter
+	declared          DeclaredReader
+	reconcilePeriod   time.Duration
 	LogWriterProducer func(id string) io.Writer
 }
 
@@ -32,8 +40,12 @@ type ConfigServicer interface {
 	ConfigService() *config.Service
 }
 
-type GolinksServicer interface {
-	GolinksService() *golinks.Service
+type ChainBackendServicer interface {
+	ChainBackend(remote string) (chainbackend.ChainBackend, error)
+}
+
+type BlobStoreServicer interface {
+	BlobStore() *blobstore.Store
 }
 
 type ChainTrackerServicer interface {
@@ -46,7 +58,8 @@ type WorkerServicer interface {
 
 type Servicer interface {
 	ConfigServicer
-	GolinksServicer
+	ChainBackendServicer
+	BlobStoreServicer
 	ChainTrackerServicer
 	WorkerServicer
 }
@@ -102,12 +115,15 @@ func (w *Service) loadWorkerConfig() (*Config, error) {
 
 	// reinitialize with initialized worker
 	configOut := &Config{}
+	assigned := false
 	for _, worker := range workerConfig.Workers {
+		assigned = assigned || worker.WorkerID == ""
 		config := &NewWorkerConfig{
 			RootPath:         worker.RootPath,
 			GenerationPeriod: worker.GenerationPeriod,
 			IgnorePaths:      worker.IgnorePaths,
-			WorkerID:         worker.id,
+			WorkerID:         worker.WorkerID,
+			Remote:           worker.Remote,
 		}
 		w, err := NewWorker(w.servicer, config, w.LogWriterProducer)
 		if err != nil {
@@ -117,16 +133,41 @@ func (w *Service) loadWorkerConfig() (*Config, error) {
 		configOut.Workers = append(configOut.Workers, w)
 	}
 
+	// workers of earlier versions are given an id on every start; keep the
+	// one they were given now
+	if assigned {
+		log.Logln("saving ids assigned to workers...")
+		if err := w.crw.WriteConfig(configOut); err != nil {
+			log.Errln("failed to save worker ids", err)
+			return nil, err
+		}
+	}
+
 	return configOut, nil
 }
 
 func (w *Service) saveWorkerConfig() error {
 	log.Logln("saving worker config...")
-	return w.crw.WriteConfig(w.WorkerConfig)
+	// declared workers are owned by their files
+	configOut := &Config{}
+	for _, worker := range w.WorkerConfig.Workers {
+		if !worker.ReadOnly() {
+			configOut.Workers = append(configOut.Workers, worker)
+		}
+	}
+	return w.crw.WriteConfig(configOut)
 }
 
 func (w *Service) Execute(ctx context.Context) error {
 	w.ctx = ctx
+	if w.declared != nil {
+		log.Logln("reconciling declared workers...")
+		if err := w.reconcileDeclared(); err != nil {
+			log.Errln("failed to reconcile declared workers", err)
+		}
+		go w.executeReconciler(ctx)
+	}
+
 	log.Logln("starting workers...")
 	if err := w.startWorkers(ctx); err != nil {
 		return err
@@ -138,7 +179,7 @@ func (w *Service) Execute(ctx context.Context) error {
 		select {
 		case <-ctx.Done():
 			log.Logln("worker manager terminating...")
-			for _, worker := range w.WorkerConfig.Workers {
+			for _, worker := range w.Workers() {
 				worker.cancelFunc()
 			}
 			return nil
@@ -176,6 +217,11 @@ func (w *Service) startNewWorkers() error {
 func (w *Service) removeWorker(index int) error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
+	return w.removeWorkerLocked(index)
+}
+
+// removeWorkerLocked removes the worker at index. The caller holds w.mu.
+func (w *Service) removeWorkerLocked(index int) error {
 	worker := w.WorkerConfig.Workers[index]
 	worker.cancelFunc()
 	w.WorkerConfig.Workers = append(w.WorkerConfig.Workers[:index], w.WorkerConfig.Workers[index+1:]...)
@@ -206,6 +252,11 @@ func (w *Service) addWorker(config *NewWorkerConfig) (*Worker, error) {
 	return worker, nil
 }
 
+// ResizeScheduler changes the number of worker tasks allowed to run concurrently.
+func (w *Service) ResizeScheduler(size int) error {
+	return w.scheduler.Resize(size)
+}
+
 func (w *Service) ScheduleWork(workerID string, task func() error) error {
 	t := &WorkerTask{
 		id:   workerID,
@@ -218,6 +269,8 @@ func (w *Service) ScheduleWork(workerID string, task func() error) error {
 var ErrWorkerIndexOutOfBonds = errors.New("worker index out of bounds")
 
 func (w *Service) GetWorkerByIndex(index int) (*Worker, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
 	if index < 0 || index > w.WorkerConfig.Length()-1 {
 		return nil, ErrWorkerIndexOutOfBonds
 	}
@@ -225,14 +278,73 @@ func (w *Service) GetWorkerByIndex(index int) (*Worker, error) {
 	return w.WorkerConfig.Workers[index], nil
 }
 
+// Workers returns a snapshot of the configured workers. The reconciler
+// replaces the workers concurrently, so callers iterate the snapshot instead
+// of WorkerConfig.
+func (w *Service) Workers() []*Worker {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]*Worker{}, w.WorkerConfig.Workers...)
+}
+
+// HasWorker reports whether a worker with the given id is configured.
+func (w *Service) HasWorker(id string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, worker := range w.WorkerConfig.Workers {
+		if worker.ID() == id {
+			return true
+		}
+	}
+	return false
+}
+
+// HasRoot reports whether a worker generating blockmaps of root is configured.
+func (w *Service) HasRoot(root string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, worker := range w.WorkerConfig.Workers {
+		if worker.RootPath == root {
+			return true
+		}
+	}
+	return false
+}
+
+// KeepsBlock reports whether blk was appended by one of the configured
+// workers. Blocks naming a worker are matched by its id only, since hosts
+// tracking the same root path would otherwise keep each other's blocks. Inline
+// blockmaps and references of earlier versions name no worker and are matched
+// by their root.
+func (w *Service) KeepsBlock(blk *block.Block) bool {
+	ref, err := blockref.Parse(blk.Data)
+	if err != nil {
+		blkmap := &blockmap.BlockMap{}
+		return json.Unmarshal(blk.Data, blkmap) == nil && blkmap.Root != "" && w.HasRoot(blkmap.Root)
+	}
+	if ref.Worker != "" {
+		return w.HasWorker(ref.Worker)
+	}
+	return w.HasRoot(ref.Root)
+}
+
 func (w *Service) DeleteWorkerByIndex(index int) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
 	if index < 0 || index > w.WorkerConfig.Length()-1 {
 		return ErrWorkerIndexOutOfBonds
 	}
-	return w.removeWorker(index)
+	if w.WorkerConfig.Workers[index].ReadOnly() {
+		return ErrReadOnlyWorker
+	}
+	return w.removeWorkerLocked(index)
 }
 
 func (w *Service) AddWorker(config *NewWorkerConfig) error {
+	if _, err := w.servicer.ConfigService().Remote(config.Remote); err != nil {
+		log.Errln("cannot add worker for remote", config.Remote, err)
+		return err
+	}
 	if _, err := w.addWorker(config); err != nil {
 		return err
 	}
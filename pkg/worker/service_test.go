@@ -2,24 +2,33 @@ package worker
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"io"
 	"testing"
 	"time"
 
+	"github.com/govice/golinks/block"
+	"github.com/govice/golinks/blockmap"
+	"github.com/govice/golinksd/pkg/blobstore"
+	"github.com/govice/golinksd/pkg/blockref"
+	"github.com/govice/golinksd/pkg/chainbackend"
 	"github.com/govice/golinksd/pkg/chaintracker"
 	"github.com/govice/golinksd/pkg/config"
-	"github.com/govice/golinksd/pkg/golinks"
 )
 
 type testServicer struct{}
 
 func (s *testServicer) ConfigService() *config.Service {
-	return &config.Service{}
+	return config.NewMemory(config.DefaultRemote)
 }
 
-func (s *testServicer) GolinksService() *golinks.Service {
-	return &golinks.Service{}
+func (s *testServicer) ChainBackend(remote string) (chainbackend.ChainBackend, error) {
+	return chainbackend.NewMemory(), nil
+}
+
+func (s *testServicer) BlobStore() *blobstore.Store {
+	return nil
 }
 
 func (s *testServicer) ChainTrackerService() *chaintracker.Service {
@@ -64,6 +73,7 @@ func TestNew(t *testing.T) {
 	cm := newTestConfigManager(&Config{
 		Workers: []*Worker{
 			{
+				WorkerID:         "root",
 				RootPath:         "/tmp/root",
 				GenerationPeriod: 100,
 				IgnorePaths:      []string{"/tmp/ignore"},
@@ -107,6 +117,7 @@ func TestAddWorker(t *testing.T) {
 	initial := &Config{
 		Workers: []*Worker{
 			{
+				WorkerID:         "root",
 				RootPath:         "/tmp/root",
 				GenerationPeriod: 100,
 				IgnorePaths:      []string{"/tmp/ignore"},
@@ -153,6 +164,7 @@ func TestRemoveWorker(t *testing.T) {
 	initial := &Config{
 		Workers: []*Worker{
 			{
+				WorkerID:         "root",
 				RootPath:         "/tmp/root",
 				GenerationPeriod: 100,
 				IgnorePaths:      []string{"/tmp/ignore"},
@@ -188,6 +200,7 @@ func TestGetWorkerByIndex(t *testing.T) {
 	initial := &Config{
 		Workers: []*Worker{
 			{
+				WorkerID:         "root",
 				RootPath:         "/tmp/root",
 				GenerationPeriod: 100,
 				IgnorePaths:      []string{"/tmp/ignore"},
@@ -224,6 +237,7 @@ func TestScheduleWork(t *testing.T) {
 	initial := &Config{
 		Workers: []*Worker{
 			{
+				WorkerID:         "root",
 				RootPath:         "/tmp/root",
 				GenerationPeriod: 100,
 				IgnorePaths:      []string{"/tmp/ignore"},
@@ -267,3 +281,261 @@ func TestScheduleWork(t *testing.T) {
 		t.Error("deadline exceeded. expected scheduled work execution")
 	}
 }
+
+func TestReconcile(t *testing.T) {
+	ts := &testServicer{}
+	initial := &Config{
+		Workers: []*Worker{
+			{
+				WorkerID:         "root",
+				RootPath:         "/tmp/root",
+				GenerationPeriod: 100,
+			},
+		}}
+	cm := newTestConfigManager(initial)
+	service, err := New(ts, cm, 1, func(id string) io.Writer {
+		return &TestLogger{}
+	})
+	if err != nil {
+		t.Error("failed to instantiate new service", err)
+	}
+
+	declared := []*NewWorkerConfig{
+		{
+			RootPath:         "/tmp/declared",
+			GenerationPeriod: 100,
+			WorkerID:         "declared",
+			Source:           "/etc/golinksd/workers.d/declared.json",
+		},
+	}
+
+	if err := service.Reconcile(declared); err != nil {
+		t.Error("expected successful reconcile.", err)
+	}
+
+	if service.WorkerConfig.Length() != 2 {
+		t.Fatal("expected 2 workers after reconcile. got", service.WorkerConfig.Length())
+	}
+
+	declaredWorker := service.WorkerConfig.Workers[1]
+	if !declaredWorker.ReadOnly() {
+		t.Error("expected declared worker to be read-only")
+	}
+
+	if err := service.Reconcile(declared); err != nil {
+		t.Error("expected successful reconcile.", err)
+	}
+
+	if service.WorkerConfig.Workers[1] != declaredWorker {
+		t.Error("expected unchanged declared worker to be kept on repeated reconcile")
+	}
+
+	if err := service.DeleteWorkerByIndex(1); !errors.Is(err, ErrReadOnlyWorker) {
+		t.Error("expected error", ErrReadOnlyWorker)
+	}
+
+	declared[0].GenerationPeriod = 200
+	if err := service.Reconcile(declared); err != nil {
+		t.Error("expected successful reconcile.", err)
+	}
+
+	if service.WorkerConfig.Workers[1].GenerationPeriod != 200 {
+		t.Error("expected updated generation period 200. got", service.WorkerConfig.Workers[1].GenerationPeriod)
+	}
+
+	if err := service.Reconcile(nil); err != nil {
+		t.Error("expected successful reconcile.", err)
+	}
+
+	if service.WorkerConfig.Length() != 1 {
+		t.Error("expected declared worker removal. got", service.WorkerConfig.Length())
+	}
+
+	if cm.ConfigWrites != 0 {
+		t.Error("expected 0 config writes for declared workers. got", cm.ConfigWrites)
+	}
+}
+
+func TestReconcileSkipsInvalidDeclarations(t *testing.T) {
+	ts := &testServicer{}
+	cm := newTestConfigManager(&Config{})
+	service, err := New(ts, cm, 1, func(id string) io.Writer {
+		return &TestLogger{}
+	})
+	if err != nil {
+		t.Fatal("failed to instantiate new service", err)
+	}
+
+	valid := NewWorkerConfig{
+		RootPath:         "/tmp/declared",
+		GenerationPeriod: 100,
+		WorkerID:         "declared",
+		Source:           "/etc/golinksd/workers.d/declared.json",
+	}
+	tests := []struct {
+		name   string
+		modify func(*NewWorkerConfig)
+	}{
+		{"without generation period", func(c *NewWorkerConfig) { c.GenerationPeriod = 0 }},
+		{"with negative generation period", func(c *NewWorkerConfig) { c.GenerationPeriod = -1 }},
+		{"without root path", func(c *NewWorkerConfig) { c.RootPath = "" }},
+		{"for an unknown remote", func(c *NewWorkerConfig) { c.Remote = "unknown" }},
+	}
+	for _, test := range tests {
+		invalid := valid
+		invalid.WorkerID = "invalid"
+		test.modify(&invalid)
+		declared := valid
+		if err := service.Reconcile([]*NewWorkerConfig{&declared, &invalid}); err != nil {
+			t.Error(test.name+": expected successful reconcile.", err)
+		}
+		if service.HasWorker("invalid") {
+			t.Error(test.name + ": expected the declaration to be skipped")
+		}
+		if !service.HasWorker("declared") {
+			t.Error(test.name + ": expected the valid declaration to be added")
+		}
+	}
+}
+
+func TestReconcileConcurrentReads(t *testing.T) {
+	ts := &testServicer{}
+	cm := newTestConfigManager(&Config{})
+	service, err := New(ts, cm, 1, func(id string) io.Writer {
+		return &TestLogger{}
+	})
+	if err != nil {
+		t.Fatal("failed to instantiate new service", err)
+	}
+
+	declared := []*NewWorkerConfig{
+		{
+			RootPath:         "/tmp/declared",
+			GenerationPeriod: 100,
+			WorkerID:         "declared",
+			Source:           "/etc/golinksd/workers.d/declared.json",
+		},
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			if i%2 == 0 {
+				service.Reconcile(declared)
+			} else {
+				service.Reconcile(nil)
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		for _, worker := range service.Workers() {
+			if !worker.ReadOnly() {
+				t.Error("expected only declared workers")
+			}
+		}
+		if err := service.DeleteWorkerByIndex(0); err != nil && !errors.Is(err, ErrReadOnlyWorker) && !errors.Is(err, ErrWorkerIndexOutOfBonds) {
+			t.Error("expected a read-only or out of bounds error. got", err)
+		}
+	}
+}
+
+func TestWorkerIDsPersist(t *testing.T) {
+	ts := &testServicer{}
+	cm := newTestConfigManager(&Config{
+		Workers: []*Worker{
+			{
+				RootPath:         "/tmp/root",
+				GenerationPeriod: 100,
+			},
+		}})
+	service, err := NewDefault(ts, cm)
+	if err != nil {
+		t.Fatal("failed to instantiate new service", err)
+	}
+
+	id := service.WorkerConfig.Workers[0].ID()
+	if id == "" {
+		t.Fatal("expected an id to be assigned to the worker")
+	}
+	if cm.ConfigWrites != 1 {
+		t.Error("expected the assigned id to be written. got", cm.ConfigWrites, "writes")
+	}
+
+	// a restart reads the worker config back from its JSON
+	configBytes, err := json.Marshal(cm.Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restarted := &Config{}
+	if err := json.Unmarshal(configBytes, restarted); err != nil {
+		t.Fatal(err)
+	}
+	cm = newTestConfigManager(restarted)
+	service, err = NewDefault(ts, cm)
+	if err != nil {
+		t.Fatal("failed to instantiate restarted service", err)
+	}
+
+	if restartedID := service.WorkerConfig.Workers[0].ID(); restartedID != id {
+		t.Error("expected worker id", id, "after restart. got", restartedID)
+	}
+	if !service.HasWorker(id) {
+		t.Error("expected the restarted service to have worker", id)
+	}
+	if cm.ConfigWrites != 0 {
+		t.Error("expected no config writes for workers with ids. got", cm.ConfigWrites)
+	}
+}
+
+func TestKeepsBlock(t *testing.T) {
+	ts := &testServicer{}
+	cm := newTestConfigManager(&Config{
+		Workers: []*Worker{
+			{
+				WorkerID:         "local",
+				RootPath:         "/var/www",
+				GenerationPeriod: 100,
+			},
+		}})
+	service, err := NewDefault(ts, cm)
+	if err != nil {
+		t.Fatal("failed to instantiate new service", err)
+	}
+
+	refBlock := func(worker, root string) *block.Block {
+		data, err := (&blockref.Reference{Type: blockref.Type, Version: 1, Root: root, Worker: worker}).Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return block.NewSHA512(1, data, nil)
+	}
+	inline, err := json.Marshal(&blockmap.BlockMap{Root: "/var/www"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		blk  *block.Block
+		keep bool
+	}{
+		{"own worker", refBlock("local", "/var/www"), true},
+		{"other worker with the same root", refBlock("remote", "/var/www"), false},
+		{"reference without worker", refBlock("", "/var/www"), true},
+		{"reference without worker of another root", refBlock("", "/srv"), false},
+		{"inline blockmap", block.NewSHA512(1, inline, nil), true},
+		{"other data", block.NewSHA512(1, []byte("genesis"), nil), false},
+	}
+	for _, test := range tests {
+		if keep := service.KeepsBlock(test.blk); keep != test.keep {
+			t.Error(test.name+": expected keep", test.keep, "got", keep)
+		}
+	}
+}
@@ -8,15 +8,20 @@ import (
 	glog "log"
 	"os"
 	"path/filepath"
-	"sync"
 	"time"
 
 	"github.com/govice/golinks/block"
 	"github.com/govice/golinks/blockchain"
 	"github.com/govice/golinks/blockmap"
+	"github.com/govice/golinksd/pkg/blockref"
+	"github.com/govice/golinksd/pkg/chainbackend"
+	"github.com/govice/golinksd/pkg/chaintracker"
+	"github.com/govice/golinksd/pkg/golinks"
 	"github.com/govice/golinksd/pkg/log"
+	"github.com/govice/golinksd/pkg/metrics"
 	"github.com/govice/golinksd/pkg/scheduler"
 	"github.com/rs/xid"
+	"github.com/spf13/viper"
 )
 
 type Worker struct {
@@ -24,8 +29,10 @@ type Worker struct {
 	RootPath         string   `json:"root_path"`
 	GenerationPeriod int      `json:"generation_period"`
 	IgnorePaths      []string `json:"ignore_paths"`
+	Remote           string   `json:"remote,omitempty"`
+	WorkerID         string   `json:"id,omitempty"`
 	running          bool
-	id               string
+	source           string
 	logger           *glog.Logger
 	servicer         Servicer
 }
@@ -35,6 +42,10 @@ type NewWorkerConfig struct {
 	GenerationPeriod int
 	IgnorePaths      []string
 	WorkerID         string
+	Remote           string
+	// Source is the file a declared worker is defined in. Workers with a
+	// source are read-only.
+	Source string
 }
 
 // DefaultLogger satisfies io.WriteCloser interface to supply io.Multiwriter with a
@@ -79,19 +90,19 @@ func (w *Worker) Execute(ctx context.Context) error {
 	generationTicker := time.NewTicker(genDuration)
 	schedulerFunc := func() {
 		generationTicker.Stop()
-		if err := w.servicer.WorkerService().ScheduleWork(w.id, func() error {
-			berr := w.generateAndUploadBlockmap()
-			log.Logln(w.id, "resetting generation ticker...")
+		if err := w.servicer.WorkerService().ScheduleWork(w.WorkerID, func() error {
+			berr := w.generateAndUploadBlockmap(ctx)
+			log.Logln(w.WorkerID, "resetting generation ticker...")
 			generationTicker.Reset(genDuration)
 			return berr
 		}); errors.Is(err, scheduler.ErrTaskScheduled) {
-			log.Logln(w.id, "task already scheduled. waiting until next epoch...")
+			log.Logln(w.WorkerID, "task already scheduled. waiting until next epoch...")
 			generationTicker.Reset(genDuration)
 		} else if err != nil {
-			log.Errln(w.id, err)
+			log.Errln(w.WorkerID, err)
 			generationTicker.Reset(genDuration)
 		}
-		w.logger.Println(w.id, "finished scheduled generation epoch")
+		w.logger.Println(w.WorkerID, "finished scheduled generation epoch")
 	}
 
 	w.logger.Println("scheduling startup blockmap")
@@ -113,7 +124,7 @@ func (w *Worker) Execute(ctx context.Context) error {
 	}
 }
 
-func (w *Worker) generateAndUploadBlockmap() error {
+func (w *Worker) generateAndUploadBlockmap(ctx context.Context) error {
 	blkmap := blockmap.New(w.RootPath)
 	blkmap.AutoIgnore = true
 	blkmap.FailOnError = false
@@ -133,39 +144,124 @@ func (w *Worker) generateAndUploadBlockmap() error {
 		return err
 	}
 
-	w.logger.Println("sending force sync to chain tracker")
-	var wg sync.WaitGroup
-	wg.Add(1)
-	w.servicer.ChainTrackerService().ForceSync(&wg)
-	wg.Wait()
+	if viper.GetString("blockmap_storage") == "inline" {
+		return w.appendBlockmap(ctx, blockmapBytes)
+	}
 
-	localHeadBlock, err := w.servicer.ChainTrackerService().LocalHead()
+	data, err := w.storeBlockmap(blkmap, blockmapBytes)
 	if err != nil {
-		w.logger.Println("failed to get local head block", err)
 		return err
 	}
+	return w.appendBlockmap(ctx, data)
+}
 
-	stagedBlock := block.NewSHA512(localHeadBlock.Index+1, blockmapBytes, localHeadBlock.BlockHash)
+// storeBlockmap puts the blockmap in the blob store, uploads it to remotes
+// that store blobs, and returns the block data referencing it.
+func (w *Worker) storeBlockmap(blkmap *blockmap.BlockMap, blockmapBytes []byte) ([]byte, error) {
+	hash, err := w.servicer.BlobStore().Put(blockmapBytes)
+	if err != nil {
+		w.logger.Println("failed to store blockmap blob", err)
+		return nil, err
+	}
 
-	subchain := &blockchain.Blockchain{
-		Blocks: []block.Block{*localHeadBlock, *stagedBlock},
+	backend, err := w.servicer.ChainBackend(w.Remote)
+	if err != nil {
+		return nil, err
+	}
+	if blobs, ok := backend.(chainbackend.BlobBackend); ok {
+		err := blobs.PutBlob(hash, blockmapBytes)
+		if errors.Is(err, chainbackend.ErrBlobsUnsupported) {
+			w.logger.Println("remote does not store blobs, keeping blockmap blob", hash, "locally")
+		} else if err != nil {
+			w.logger.Println("failed to upload blockmap blob", hash, err)
+			return nil, err
+		}
 	}
 
-	if err := subchain.Validate(); err != nil {
-		w.logger.Println("failed to validate subchain")
+	return blockref.New(blkmap, blockmapBytes, w.WorkerID).Bytes()
+}
+
+var ErrConflictRetriesExhausted = errors.New("worker: chain head kept moving while appending block")
+
+// appendBlockmap stages a block holding blockmapBytes on the local head of the
+// worker's remote and appends it. When the remote rejects the block because
+// its head moved, the chain is re-synced and the block is rebuilt on the new
+// head, up to append_max_retries times.
+func (w *Worker) appendBlockmap(ctx context.Context, blockmapBytes []byte) error {
+	tracker, err := w.servicer.ChainTrackerService().Tracker(w.Remote)
+	if err != nil {
+		w.logger.Println("failed to get chain tracker for remote", w.Remote, err)
 		return err
 	}
+	return w.appendRebasing(ctx, tracker, blockmapBytes)
+}
 
-	if err := w.uploadBlock(stagedBlock); err != nil {
-		w.logger.Println("failed to upload staged block")
-		return err
+// headSyncer syncs the local chain of a remote and reads its head.
+type headSyncer interface {
+	Sync(ctx context.Context) (chaintracker.SyncResult, error)
+	LocalHead() (*block.Block, error)
+}
+
+// appendRebasing stages a block holding blockmapBytes on the synced local head
+// and uploads it, rebasing it on the new head when the remote reports a
+// conflict.
+func (w *Worker) appendRebasing(ctx context.Context, tracker headSyncer, blockmapBytes []byte) error {
+	maxRetries := viper.GetInt("append_max_retries")
+	for attempt := 0; attempt <= maxRetries; attempt++ {
+		if attempt > 0 {
+			metrics.AppendRebases.Add(metrics.RemoteKey(w.Remote), 1)
+			w.logger.Println("rebasing staged block on new chain head, attempt", attempt, "of", maxRetries)
+		}
+
+		w.logger.Println("syncing chain before staging block")
+		if _, err := tracker.Sync(ctx); err != nil {
+			w.logger.Println("failed to sync chain", err)
+			return err
+		}
+
+		localHeadBlock, err := tracker.LocalHead()
+		if err != nil {
+			w.logger.Println("failed to get local head block", err)
+			return err
+		}
+
+		stagedBlock := block.NewSHA512(localHeadBlock.Index+1, blockmapBytes, localHeadBlock.BlockHash)
+
+		subchain := &blockchain.Blockchain{
+			Blocks: []block.Block{*localHeadBlock, *stagedBlock},
+		}
+
+		if err := subchain.Validate(); err != nil {
+			w.logger.Println("failed to validate subchain")
+			return err
+		}
+
+		err = w.uploadBlock(stagedBlock)
+		if errors.Is(err, chainbackend.ErrConflict) {
+			metrics.AppendConflicts.Add(metrics.RemoteKey(w.Remote), 1)
+			w.logger.Println("staged block", stagedBlock.Index, "conflicts with the remote chain head", err)
+			continue
+		} else if errors.Is(err, golinks.ErrUnauthorized) {
+			w.logger.Println("upload of staged block was not authorized by remote", err)
+			return err
+		} else if err != nil {
+			w.logger.Println("failed to upload staged block", err)
+			return err
+		}
+
+		return nil
 	}
 
-	return nil
+	w.logger.Println("giving up on staged block after", maxRetries, "rebases")
+	return ErrConflictRetriesExhausted
 }
 
 func (w *Worker) uploadBlock(blk *block.Block) error {
-	return w.servicer.GolinksService().UploadBlock(blk)
+	backend, err := w.servicer.ChainBackend(w.Remote)
+	if err != nil {
+		return err
+	}
+	return backend.Append(blk)
 }
 
 func (w *Worker) logln(v ...interface{}) {
@@ -182,14 +278,53 @@ func NewWorker(servicer Servicer, config *NewWorkerConfig, logWriterProucer func
 		RootPath:         config.RootPath,
 		GenerationPeriod: config.GenerationPeriod,
 		logger:           glog.New(io.MultiWriter(logWriter, os.Stderr), config.WorkerID+" ", glog.Ltime),
-		id:               config.WorkerID,
+		WorkerID:         config.WorkerID,
 		IgnorePaths:      config.IgnorePaths,
+		Remote:           config.Remote,
+		source:           config.Source,
 		servicer:         servicer,
 	}
 
 	return worker, nil
 }
 
+// ID returns the id recorded in the blocks the worker appends. It is written to
+// the worker config, so it stays the same across restarts.
+func (w *Worker) ID() string {
+	return w.WorkerID
+}
+
+// Source returns the file the worker is declared in, or an empty string for
+// workers managed through the worker config.
+func (w *Worker) Source() string {
+	return w.source
+}
+
+// ReadOnly reports whether the worker is declared in a file and cannot be
+// modified at runtime.
+func (w *Worker) ReadOnly() bool {
+	return w.source != ""
+}
+
+// matches reports whether the worker runs with the settings of config.
+func (w *Worker) matches(config *NewWorkerConfig) bool {
+	if w.RootPath != config.RootPath || w.GenerationPeriod != config.GenerationPeriod {
+		return false
+	}
+	if w.Remote != config.Remote || w.source != config.Source {
+		return false
+	}
+	if len(w.IgnorePaths) != len(config.IgnorePaths) {
+		return false
+	}
+	for i := range w.IgnorePaths {
+		if w.IgnorePaths[i] != config.IgnorePaths[i] {
+			return false
+		}
+	}
+	return true
+}
+
 func (w *Worker) AddCancelFunc(cancelFunc func()) {
 	if w.cancelFunc == nil {
 		w.cancelFunc = cancelFunc
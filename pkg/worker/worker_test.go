@@ -0,0 +1,143 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/govice/golinks/block"
+	"github.com/govice/golinksd/internal/remotetest"
+	"github.com/govice/golinksd/pkg/chainbackend"
+	"github.com/govice/golinksd/pkg/chaintracker"
+	"github.com/govice/golinksd/pkg/golinks"
+	"github.com/spf13/viper"
+)
+
+type remoteServicer struct {
+	testServicer
+	backend chainbackend.ChainBackend
+}
+
+func (s *remoteServicer) ChainBackend(remote string) (chainbackend.ChainBackend, error) {
+	return s.backend, nil
+}
+
+// memoryTracker reports the head of a memory chain and counts syncs.
+type memoryTracker struct {
+	chain *chainbackend.Memory
+	syncs int
+}
+
+func (t *memoryTracker) Sync(ctx context.Context) (chaintracker.SyncResult, error) {
+	t.syncs++
+	return chaintracker.SyncResult{}, nil
+}
+
+func (t *memoryTracker) LocalHead() (*block.Block, error) {
+	length, err := t.chain.Length()
+	if err != nil {
+		return nil, err
+	}
+	return t.chain.GetBlock(length - 1)
+}
+
+func TestAppendRebasesOnConflict(t *testing.T) {
+	viper.Set("append_max_retries", 3)
+	defer viper.Set("append_max_retries", nil)
+
+	genesis := block.NewSHA512(0, []byte("genesis"), nil)
+	chain := chainbackend.NewMemory(genesis)
+
+	// the remote accepts the first upload only after another worker appended
+	// a block on the same head, so the first upload is rejected with a 409
+	posts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/chain" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		posts++
+		var blk block.Block
+		if err := json.NewDecoder(r.Body).Decode(&blk); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if posts == 1 {
+			if err := chain.Append(block.NewSHA512(1, []byte("competing"), genesis.BlockHash)); err != nil {
+				t.Error(err)
+			}
+		}
+		if err := chain.Append(&blk); err != nil {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	gs, err := golinks.New(&remotetest.Remote{URL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := NewWorker(&remoteServicer{backend: gs}, &NewWorkerConfig{Remote: "test"}, func(id string) io.Writer {
+		return &TestLogger{}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tracker := &memoryTracker{chain: chain}
+	if err := w.appendRebasing(context.Background(), tracker, []byte("blockmap")); err != nil {
+		t.Fatal("expected append after one rebase.", err)
+	}
+
+	if posts != 2 {
+		t.Error("expected 2 uploads. got", posts)
+	}
+	if tracker.syncs != 2 {
+		t.Error("expected a sync before each upload. got", tracker.syncs)
+	}
+	if length, _ := chain.Length(); length != 3 {
+		t.Fatal("expected chain length 3. got", length)
+	}
+	head, _ := chain.GetBlock(2)
+	competing, _ := chain.GetBlock(1)
+	if string(head.Data) != "blockmap" || !bytes.Equal(head.ParentHash, competing.BlockHash) {
+		t.Error("expected the staged block to be rebased on the competing block")
+	}
+}
+
+func TestAppendGivesUpAfterMaxRetries(t *testing.T) {
+	viper.Set("append_max_retries", 2)
+	defer viper.Set("append_max_retries", nil)
+
+	posts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posts++
+		w.WriteHeader(http.StatusConflict)
+	}))
+	defer server.Close()
+
+	gs, err := golinks.New(&remotetest.Remote{URL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := NewWorker(&remoteServicer{backend: gs}, &NewWorkerConfig{Remote: "test"}, func(id string) io.Writer {
+		return &TestLogger{}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tracker := &memoryTracker{chain: chainbackend.NewMemory(block.NewSHA512(0, []byte("genesis"), nil))}
+	if err := w.appendRebasing(context.Background(), tracker, []byte("blockmap")); err != ErrConflictRetriesExhausted {
+		t.Error("expected ErrConflictRetriesExhausted. got", err)
+	}
+	if posts != 3 {
+		t.Error("expected the first upload and 2 rebases. got", posts)
+	}
+}